@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net/url"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -13,16 +14,29 @@ type Config struct {
 	
 	UserDatabaseOptions UserDatabaseOptions `mapstructure:"user_db"`
 	OAuthOptions OAuthOptions `mapstructure:"oauth"`
+	FlagsOptions FlagsOptions `mapstructure:"flags"`
 }
 
 type FactsProviderOptions struct {
 	Provider string `mapstructure:"provider"`
 	RedisURI string `mapstructure:"redis_uri"`
+	RedisLayout string `mapstructure:"redis_layout"`
+	PostgresDSN string `mapstructure:"postgres_dsn"`
+	EventsBufferSize int `mapstructure:"events_buffer_size"`
 }
 
 type SecretsProviderOptions struct {
 	Provider string `mapstructure:"provider"`
 	ASMDeletionRecoveryDays int `mapstructure:"asm_deletion_recovery_days"`
+
+	VaultAddress string `mapstructure:"vault_address"`
+	VaultAuthMethod string `mapstructure:"vault_auth_method"`
+	VaultToken string `mapstructure:"vault_token"`
+	VaultRoleID string `mapstructure:"vault_role_id"`
+	VaultSecretID string `mapstructure:"vault_secret_id"`
+	VaultAppRoleMount string `mapstructure:"vault_approle_mount"`
+	VaultMount string `mapstructure:"vault_mount"`
+	VaultPathPrefix string `mapstructure:"vault_path_prefix"`
 }
 
 type UserDatabaseOptions struct {
@@ -33,28 +47,133 @@ type UserDatabaseOptions struct {
 	RefreshTokenExpirationMinutes int `mapstructure:"refresh_token_expiration_minutes"`
 }
 
+type FlagsOptions struct {
+	Source string `mapstructure:"source"`
+	FilePath string `mapstructure:"file_path"`
+}
+
 type OAuthOptions struct {
+	// Comma separated list of enabled login providers. Any name other than github is an OIDC provider.
 	Provider string `mapstructure:"provider"`
 	Hostname string `mapstructure:"hostname"`
 
 	GithubClientKey string `mapstructure:"github_client_key"`
 	GithubClientSecret string `mapstructure:"github_client_secret"`
+	// Requests the read:org scope and uses the organizations and teams of GitHub users as their groups
+	GithubGroups bool `mapstructure:"github_groups"`
+
+	OIDC map[string]OIDCOptions `mapstructure:"oidc"`
 }
 
-func ParseConfig() (*Config, error) {
-	v := viper.New()
+type OIDCOptions struct {
+	DisplayName string `mapstructure:"display_name"`
+	IssuerURL string `mapstructure:"issuer_url"`
+	ClientID string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	Scopes []string `mapstructure:"scopes"`
+	UsernameClaim string `mapstructure:"username_claim"`
+	EmailClaim string `mapstructure:"email_claim"`
+	GroupsClaim string `mapstructure:"groups_claim"`
+}
 
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AutomaticEnv()
+// Returns the names of the enabled login providers
+func (o OAuthOptions) EnabledProviders() []string {
+	providers := []string{}
+	for _, name := range strings.Split(o.Provider, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			providers = append(providers, name)
+		}
+	}
+	return providers
+}
+
+// Environment variables cannot be unmarshalled into a map so the options of
+// every enabled OIDC provider are looked up by name e.g. OAUTH_OIDC_KEYCLOAK_ISSUER_URL
+func loadOIDCOptions(v *viper.Viper, conf *Config) {
+	if conf.OAuthOptions.OIDC == nil {
+		conf.OAuthOptions.OIDC = map[string]OIDCOptions{}
+	}
+
+	for _, name := range conf.OAuthOptions.EnabledProviders() {
+		if _, ok := conf.OAuthOptions.OIDC[name]; ok || name == "github" {
+			continue
+		}
+
+		prefix := "oauth.oidc." + name + "."
+		conf.OAuthOptions.OIDC[name] = OIDCOptions{
+			DisplayName: v.GetString(prefix + "display_name"),
+			IssuerURL: v.GetString(prefix + "issuer_url"),
+			ClientID: v.GetString(prefix + "client_id"),
+			ClientSecret: v.GetString(prefix + "client_secret"),
+			Scopes: v.GetStringSlice(prefix + "scopes"),
+			UsernameClaim: v.GetString(prefix + "username_claim"),
+			EmailClaim: v.GetString(prefix + "email_claim"),
+			GroupsClaim: v.GetString(prefix + "groups_claim"),
+		}
+	}
+}
+
+const redactedValue = "[redacted]"
+
+// Returns a copy of the config safe to log with every secret and password removed
+func (c Config) redacted() Config {
+	c.FactsProviderOptions.RedisURI = redactURI(c.FactsProviderOptions.RedisURI)
+	c.FactsProviderOptions.PostgresDSN = redactURI(c.FactsProviderOptions.PostgresDSN)
+	c.UserDatabaseOptions.PostgresDSN = redactURI(c.UserDatabaseOptions.PostgresDSN)
+
+	c.SecretsProviderOptions.VaultToken = redactSecret(c.SecretsProviderOptions.VaultToken)
+	c.SecretsProviderOptions.VaultSecretID = redactSecret(c.SecretsProviderOptions.VaultSecretID)
+	c.UserDatabaseOptions.JWTSecret = redactSecret(c.UserDatabaseOptions.JWTSecret)
+	c.OAuthOptions.GithubClientSecret = redactSecret(c.OAuthOptions.GithubClientSecret)
+
+	// The map is shared with the original config
+	oidc := map[string]OIDCOptions{}
+	for name, opts := range c.OAuthOptions.OIDC {
+		opts.ClientSecret = redactSecret(opts.ClientSecret)
+		oidc[name] = opts
+	}
+	c.OAuthOptions.OIDC = oidc
+
+	return c
+}
+
+// Keeps empty values so missing secrets still show up in the logs
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// Removes the password from connection URIs. Connection strings that are not URIs are removed completely.
+func redactURI(uri string) string {
+	if uri == "" {
+		return ""
+	}
 
-	// Set default values here
-	conf := Config{
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redactedValue
+	}
+	return u.Redacted()
+}
+
+// Returns the config with every default value set
+func defaultConfig() Config {
+	return Config{
 		FactsProviderOptions: FactsProviderOptions{
 			Provider: "redis",
+			RedisLayout: "string",
+			EventsBufferSize: 1000,
 		},
 		SecretsProviderOptions: SecretsProviderOptions{
 			Provider: "asm",
 			ASMDeletionRecoveryDays: 7,
+			VaultAuthMethod: "token",
+			VaultAppRoleMount: "approle",
+			VaultMount: "secret",
+			VaultPathPrefix: "flagops",
 		},
 		UserDatabaseOptions: UserDatabaseOptions{
 			RequireAuth: true,
@@ -63,13 +182,43 @@ func ParseConfig() (*Config, error) {
 			RefreshTokenExpirationMinutes: 720,
 		},
 	}
+}
+
+func ParseConfig() (*Config, error) {
+	v := viper.New()
+
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
 
+	conf := defaultConfig()
 	err := v.Unmarshal(&conf)
 	if err != nil {
 	  return nil, err
 	}
+	loadOIDCOptions(v, &conf)
 
-	logrus.Infof("%+v", conf)
+	logrus.Infof("%+v", conf.redacted())
+
+	return &conf, nil
+}
+
+// Reads the config from a file using the same keys as the environment variables
+// in lower case e.g. facts.provider. The environment is not used.
+func ParseConfigFile(path string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	err := v.ReadInConfig()
+	if err != nil {
+	  return nil, err
+	}
+
+	conf := defaultConfig()
+	err = v.Unmarshal(&conf)
+	if err != nil {
+	  return nil, err
+	}
+	loadOIDCOptions(v, &conf)
 
 	return &conf, nil
 }
@@ -13,7 +13,7 @@ func GetDBClient(dsn string) (*gorm.DB, error) {
 	  return nil, err
 	}
 
-	err = dbClient.AutoMigrate(&User{}, &Permission{})
+	err = dbClient.AutoMigrate(&User{}, &Permission{}, &ServiceAccount{}, &APIToken{}, &FactSchema{}, &FactChange{}, &AuditEvent{}, &Webhook{}, &WebhookDelivery{}, &GroupMapping{}, &IdentityGroup{}, &IdentityGroupMembership{}, &FactSchedule{}, &Identity{})
 	if err != nil {
 	  return nil, err
 	}
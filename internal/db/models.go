@@ -1,8 +1,10 @@
 package db
 
 import (
+	"slices"
 	"time"
 
+	"github.com/graytonio/flagops-data-store/internal/facts"
 	"gorm.io/gorm"
 )
 
@@ -14,7 +16,185 @@ type User struct {
 	SSOProvider string
 	SSOID       string
 
+	// Tokens of the identity provider used to look up the groups of the user again when their access token is refreshed.
+	// Both are encrypted, use UserDataService.SSOTokens to read them.
+	SSOAccessToken  string `json:"-"`
+	SSORefreshToken string `json:"-"`
+
+	// Manually granted permissions
 	Permissions []Permission `gorm:"many2many:user_permissions"`
+
+	// Groups reported by the identity provider and the permissions mapped to them.
+	// Both are replaced on every login and token refresh.
+	Groups           []string     `gorm:"serializer:json"`
+	GroupPermissions []Permission `gorm:"many2many:user_group_permissions"`
+}
+
+// Returns the ids of the manually granted and group permissions of the user
+func (u *User) PermissionIDs() []string {
+	permissions := []string{}
+	for _, p := range slices.Concat(u.Permissions, u.GroupPermissions) {
+		if !slices.Contains(permissions, p.ID) {
+			permissions = append(permissions, p.ID)
+		}
+	}
+	return permissions
+}
+
+// Grants a permission to every user in a group of an identity provider.
+// An empty provider matches the group from any provider.
+type GroupMapping struct {
+	gorm.Model
+	Provider     string `gorm:"not null;default:'';uniqueIndex:idx_group_mapping"`
+	Group        string `gorm:"column:group_name;not null;uniqueIndex:idx_group_mapping"`
+	PermissionID string `gorm:"not null;uniqueIndex:idx_group_mapping"`
+	Permission   Permission
+}
+
+// A non human account used by machine clients that authenticate with api tokens
+type ServiceAccount struct {
+	gorm.Model
+	Name string `gorm:"uniqueIndex"`
+
+	Tokens []APIToken
+}
+
+// A long lived token belonging to a service account. Only a hash of the token is stored.
+type APIToken struct {
+	gorm.Model
+	Name string
+
+	ServiceAccountID uint `gorm:"index"`
+	ServiceAccount   ServiceAccount
+
+	TokenHash   string       `gorm:"uniqueIndex" json:"-"`
+	Permissions []Permission `gorm:"many2many:api_token_permissions"`
+
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// Describes an allowed fact key. Schemas with an identity prefix only apply to
+// identities starting with that prefix and take precedence over global schemas.
+type FactSchema struct {
+	gorm.Model
+	Key            string `gorm:"not null;uniqueIndex:idx_fact_schema_scope"`
+	IdentityPrefix string `gorm:"not null;default:'';uniqueIndex:idx_fact_schema_scope"`
+
+	// Fact type the value must have. An empty type allows any type.
+	Type     string
+	Enum     []string `gorm:"serializer:json"`
+	Pattern  string
+	Required bool
+
+	Description string
+}
+
+// A named set of facts shared by identities. Facts of the parent groups are
+// inherited with later parents overriding earlier ones and the group's own facts overriding both.
+type IdentityGroup struct {
+	gorm.Model
+	Name        string `gorm:"not null;uniqueIndex"`
+	Description string
+	Parents     []string    `gorm:"serializer:json"`
+	Facts       facts.Facts `gorm:"serializer:json"`
+}
+
+// Metadata of a registered identity. Facts and secrets of the identity stay in their providers.
+type Identity struct {
+	ID          string            `gorm:"primarykey" json:"id"`
+	Description string            `json:"description"`
+	Owner       string            `gorm:"index" json:"owner"`
+	Labels      map[string]string `gorm:"serializer:json" json:"labels"`
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// The ordered list of groups an identity belongs to
+type IdentityGroupMembership struct {
+	Identity  string    `gorm:"primarykey" json:"identity"`
+	Groups    []string  `gorm:"serializer:json" json:"groups"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// A recorded change to a single fact. Values are nil when the fact did not exist.
+type FactChange struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index:idx_fact_change_identity_time,priority:2" json:"time"`
+
+	Identity    string      `gorm:"not null;index:idx_fact_change_identity_time,priority:1" json:"identity"`
+	Key         string      `gorm:"not null" json:"key"`
+	Environment string      `gorm:"not null;default:''" json:"environment,omitempty"`
+	Operation   string      `gorm:"not null" json:"operation"`
+	OldValue    *facts.Fact `gorm:"serializer:json" json:"old_value"`
+	NewValue    *facts.Fact `gorm:"serializer:json" json:"new_value"`
+	Actor       string      `json:"actor"`
+}
+
+// A change to a fact applied at a later time. Expirations delete a fact that was set with a ttl.
+type FactSchedule struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Identity    string      `gorm:"not null;index" json:"identity"`
+	Key         string      `gorm:"not null" json:"key"`
+	Environment string      `gorm:"not null;default:''" json:"environment,omitempty"`
+	Operation   string      `gorm:"not null" json:"operation"`
+	Value       *facts.Fact `gorm:"serializer:json" json:"value,omitempty"`
+	Expiration  bool        `gorm:"not null;default:false" json:"expiration"`
+	Actor       string      `json:"actor"`
+
+	Status    string    `gorm:"not null;index:idx_fact_schedule_queue,priority:1" json:"status"`
+	RunAt     time.Time `gorm:"index:idx_fact_schedule_queue,priority:2" json:"run_at"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// A single audited api call
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"time"`
+
+	Actor     string         `gorm:"index" json:"actor"`
+	Action    string         `gorm:"index" json:"action"`
+	Identity  string         `gorm:"index" json:"identity,omitempty"`
+	Key       string         `json:"key,omitempty"`
+	Outcome   string         `gorm:"index" json:"outcome"`
+	Status    int            `json:"status"`
+	SourceIP  string         `json:"source_ip"`
+	RequestID string         `json:"request_id"`
+	Details   map[string]any `gorm:"serializer:json" json:"details,omitempty"`
+}
+
+// An outbound webhook notified of data changes. Empty events or identity prefix match everything.
+type Webhook struct {
+	gorm.Model
+	URL            string   `gorm:"not null"`
+	Events         []string `gorm:"serializer:json"`
+	IdentityPrefix string
+	Secret         string `gorm:"not null" json:"-"`
+	Enabled        bool   `gorm:"not null;default:true"`
+
+	Description string
+}
+
+// A single event queued for delivery to a webhook
+type WebhookDelivery struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	WebhookID uint   `gorm:"not null;index" json:"webhook_id"`
+	Event     string `gorm:"not null" json:"event"`
+	Payload   string `gorm:"not null" json:"payload"`
+
+	Status         string     `gorm:"not null;index:idx_webhook_delivery_queue,priority:1" json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index:idx_webhook_delivery_queue,priority:2" json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
 }
 
 type Permission struct {
@@ -33,6 +213,7 @@ const (
 	SecretsWrite    = "secrets-write"
 	ReadUsers       = "users-read"
 	WriteUsers      = "users-write"
+	AuditRead       = "audit-read"
 )
 
 var BootstrapPermissions = []Permission{
@@ -43,4 +224,5 @@ var BootstrapPermissions = []Permission{
 	{ID: SecretsWrite, DisplayName: "Write Secrets"},
 	{ID: ReadUsers, DisplayName: "Read Users"},
 	{ID: WriteUsers, DisplayName: "Write Users"},
+	{ID: AuditRead, DisplayName: "Read Audit Log"},
 }
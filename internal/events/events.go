@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sent to a subscriber in place of events it missed and that can no longer be replayed.
+// The subscriber has to read the facts again and continues from the id of the reset event.
+const OperationReset facts.ChangeOperation = "reset"
+
+// A fact change published on the bus
+type Event struct {
+	ID        uint64                `json:"id"`
+	Identity  string                `json:"identity"`
+	Key       string                `json:"key"`
+	Operation facts.ChangeOperation `json:"operation"`
+	Value     *facts.Fact           `json:"value,omitempty"`
+	OldValue  *facts.Fact           `json:"old_value,omitempty"`
+	Actor     string                `json:"actor,omitempty"`
+	Time      time.Time             `json:"time"`
+
+	// Set when an environment override changed instead of the base facts
+	Environment string `json:"environment,omitempty"`
+
+	// Id of the server replica the change was made on
+	Origin string `json:"-"`
+}
+
+type Bus interface {
+	// Id of this server replica set as the origin of events published through it
+	Origin() string
+
+	// Assigns the event an id and delivers it to every subscriber
+	Publish(ctx context.Context, event Event) error
+
+	// Streams events published after the given id. Buffered events are replayed
+	// first, or a single OperationReset event when the missed events are not buffered.
+	// An id of 0 only streams events published from now on.
+	// The channel is closed when ctx is done or the subscriber falls too far behind.
+	Subscribe(ctx context.Context, afterID uint64) <-chan Event
+}
+
+// Creates the bus matching the facts provider. Redis shares events between
+// every replica connected to the same redis while other providers only see
+// changes made on the local replica.
+func GetBus(config config.FactsProviderOptions) (Bus, error) {
+	switch config.Provider {
+	case "redis":
+		opts, err := redis.ParseURL(config.RedisURI)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRedisBus(redis.NewClient(opts), config.EventsBufferSize), nil
+	case "postgres":
+		return NewMemoryBus(config.EventsBufferSize), nil
+	default:
+		return nil, fmt.Errorf("no event bus for fact provider %s", config.Provider)
+	}
+}
+
+var _ facts.FactObserver = &FactPublisher{}
+
+// Publishes every observed fact change on the bus
+type FactPublisher struct {
+	Bus Bus
+}
+
+// ObserveFactChange implements facts.FactObserver.
+func (p *FactPublisher) ObserveFactChange(ctx context.Context, change facts.FactChange) error {
+	return p.Bus.Publish(ctx, Event{
+		Identity:  change.Identity,
+		Key:       change.Key,
+		Operation: change.Operation,
+		Value:     change.NewValue,
+		OldValue:  change.OldValue,
+		Actor:     change.Actor,
+		Time:      change.Time,
+
+		Environment: change.Environment,
+	})
+}
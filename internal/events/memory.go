@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var _ Bus = &MemoryBus{}
+
+// Number of events a subscriber can fall behind before it is disconnected
+const subscriberBufferSize = 256
+
+// An in process bus keeping the most recent events in a ring buffer so
+// subscribers can resume from the last event they saw
+type MemoryBus struct {
+	origin string
+
+	lock   sync.Mutex
+	lastID uint64
+
+	// Highest id of an event that is no longer buffered or was never delivered to this bus
+	missedID uint64
+
+	buffer      []Event
+	next        int
+	full        bool
+	subscribers map[chan Event]struct{}
+}
+
+func NewMemoryBus(bufferSize int) *MemoryBus {
+	return &MemoryBus{
+		origin:      uuid.NewString(),
+		buffer:      make([]Event, max(bufferSize, 1)),
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Origin implements Bus.
+func (m *MemoryBus) Origin() string {
+	return m.origin
+}
+
+// Publish implements Bus.
+func (m *MemoryBus) Publish(ctx context.Context, event Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.lastID++
+	event.ID = m.lastID
+	event.Origin = m.origin
+	m.deliver(event)
+	return nil
+}
+
+// Stores the event and sends it to every subscriber. Must be called with the lock held.
+func (m *MemoryBus) deliver(event Event) {
+	if event.ID > m.lastID+1 {
+		m.missedID = event.ID - 1
+	}
+	m.lastID = max(m.lastID, event.ID)
+
+	if m.full {
+		m.missedID = max(m.missedID, m.buffer[m.next].ID)
+	}
+	m.buffer[m.next] = event
+	m.next = (m.next + 1) % len(m.buffer)
+	if m.next == 0 {
+		m.full = true
+	}
+
+	for sub := range m.subscribers {
+		select {
+		case sub <- event:
+		default:
+			// Never block publishers on slow subscribers, they can resume with the last id they saw
+			delete(m.subscribers, sub)
+			close(sub)
+		}
+	}
+}
+
+// Returns the buffered events oldest first. Must be called with the lock held.
+func (m *MemoryBus) buffered() []Event {
+	if !m.full {
+		return m.buffer[:m.next]
+	}
+
+	return append(append([]Event{}, m.buffer[m.next:]...), m.buffer[:m.next]...)
+}
+
+// Subscribe implements Bus. Only subscribers resuming with an id get events replayed.
+// Subscribers resuming from an event that can not be replayed, because it is no longer
+// buffered or is newer than any event seen, receive a reset event instead of the events
+// they missed.
+func (m *MemoryBus) Subscribe(ctx context.Context, afterID uint64) <-chan Event {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	replay := []Event{}
+	switch {
+	case afterID == 0:
+		// New subscribers only receive events published from now on
+	case afterID < m.missedID || afterID > m.lastID:
+		replay = append(replay, Event{ID: m.lastID, Operation: OperationReset, Time: time.Now()})
+	default:
+		for _, event := range m.buffered() {
+			if event.ID > afterID {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	sub := make(chan Event, len(replay)+subscriberBufferSize)
+	for _, event := range replay {
+		sub <- event
+	}
+	m.subscribers[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if _, ok := m.subscribers[sub]; ok {
+			delete(m.subscribers, sub)
+			close(sub)
+		}
+	}()
+
+	return sub
+}
@@ -0,0 +1,131 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBusSubscribe(t *testing.T) {
+	bus := events.NewMemoryBus(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := bus.Subscribe(ctx, 0)
+	assert.NoError(t, bus.Publish(context.Background(), events.Event{Identity: "customer0", Key: "fact0"}))
+
+	event := <-sub
+	assert.Equal(t, uint64(1), event.ID)
+	assert.Equal(t, "customer0", event.Identity)
+	assert.Equal(t, bus.Origin(), event.Origin)
+
+	cancel()
+	_, ok := <-sub
+	assert.False(t, ok)
+}
+
+func TestMemoryBusReplay(t *testing.T) {
+	bus := events.NewMemoryBus(3)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, bus.Publish(context.Background(), events.Event{Identity: "customer0"}))
+	}
+
+	var tests = []struct {
+		name        string
+		afterID     uint64
+		expectedIDs []uint64
+	}{
+		{
+			name:        "replays events after id",
+			afterID:     3,
+			expectedIDs: []uint64{4, 5},
+		},
+		{
+			name:        "nothing to replay",
+			afterID:     5,
+			expectedIDs: []uint64{},
+		},
+		{
+			name:        "resuming from the oldest buffered event",
+			afterID:     2,
+			expectedIDs: []uint64{3, 4, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			sub := bus.Subscribe(ctx, tt.afterID)
+
+			ids := []uint64{}
+			for len(ids) < len(tt.expectedIDs) {
+				ids = append(ids, (<-sub).ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+			assert.Empty(t, sub)
+		})
+	}
+}
+
+func TestMemoryBusSubscribeWithoutID(t *testing.T) {
+	bus := events.NewMemoryBus(3)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, bus.Publish(context.Background(), events.Event{Identity: "customer0"}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Buffered events are only replayed to subscribers resuming with an id
+	sub := bus.Subscribe(ctx, 0)
+	assert.Empty(t, sub)
+
+	assert.NoError(t, bus.Publish(context.Background(), events.Event{Identity: "customer0"}))
+	assert.Equal(t, uint64(3), (<-sub).ID)
+	assert.Empty(t, sub)
+}
+
+func TestMemoryBusReset(t *testing.T) {
+	var tests = []struct {
+		name    string
+		afterID uint64
+	}{
+		{
+			name:    "missed events are no longer buffered",
+			afterID: 1,
+		},
+		{
+			name:    "id newer than any event",
+			afterID: 42,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := events.NewMemoryBus(3)
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, bus.Publish(context.Background(), events.Event{Identity: "customer0"}))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			sub := bus.Subscribe(ctx, tt.afterID)
+
+			event := <-sub
+			assert.Equal(t, events.OperationReset, event.Operation)
+			assert.Equal(t, uint64(5), event.ID)
+			assert.Empty(t, sub)
+
+			// Events after the reset are streamed as usual
+			assert.NoError(t, bus.Publish(context.Background(), events.Event{Identity: "customer0"}))
+			assert.Equal(t, uint64(6), (<-sub).ID)
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+var _ Bus = &RedisBus{}
+
+const (
+	// Counter used to give events an id that is unique across replicas
+	redisEventIDKey = "flagops:events:id"
+
+	// Stream events are added to in the order of their ids
+	redisEventStreamKey = "flagops:events"
+
+	// Field of a stream entry holding the encoded event
+	redisEventField = "message"
+
+	// How long a read of the stream waits for new events before it is sent again
+	redisEventReadBlock = 5 * time.Second
+)
+
+// Assigns the next id and adds the event to the stream under it in one step, so events
+// are added in the order of their ids no matter which replica published them.
+var redisPublishScript = redis.NewScript(`
+local id = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], id .. '-0', ARGV[3], ARGV[1])
+return id
+`)
+
+type redisEventMessage struct {
+	Event  Event  `json:"event"`
+	Origin string `json:"origin"`
+}
+
+// A bus sharing events between every replica connected to the same redis. Events are
+// added to a redis stream and each replica keeps its own buffer of recent events fed
+// by reading the stream in order.
+type RedisBus struct {
+	client     redis.UniversalClient
+	bufferSize int
+	local      *MemoryBus
+}
+
+// Starts reading events published by any replica
+func NewRedisBus(client redis.UniversalClient, bufferSize int) *RedisBus {
+	bus := &RedisBus{
+		client:     client,
+		bufferSize: max(bufferSize, 1),
+		local:      NewMemoryBus(bufferSize),
+	}
+
+	go bus.listen(context.Background())
+	return bus
+}
+
+func (r *RedisBus) listen(ctx context.Context) {
+	log := logrus.WithFields(logrus.Fields{"bus": "redis", "stream": redisEventStreamKey})
+
+	// The buffer starts with the most recent events so clients can resume from any replica
+	lastID, err := r.loadRecent(ctx)
+	for err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.WithError(err).Warn("could not read recent events, retrying")
+		time.Sleep(time.Second)
+		lastID, err = r.loadRecent(ctx)
+	}
+
+	for {
+		streams, err := r.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{redisEventStreamKey, lastID},
+			Count:   int64(r.bufferSize),
+			Block:   redisEventReadBlock,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, redis.Nil) { // No new events before the read timed out
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Warn("could not read events, retrying")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				r.deliver(log, message)
+				lastID = message.ID
+			}
+		}
+	}
+}
+
+// Buffers the most recent events of the stream and returns the stream id to read new events after
+func (r *RedisBus) loadRecent(ctx context.Context) (string, error) {
+	messages, err := r.client.XRevRangeN(ctx, redisEventStreamKey, "+", "-", int64(r.bufferSize)).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if len(messages) == 0 {
+		return "0", nil
+	}
+
+	log := logrus.WithFields(logrus.Fields{"bus": "redis", "stream": redisEventStreamKey})
+	for i := len(messages) - 1; i >= 0; i-- {
+		r.deliver(log, messages[i])
+	}
+
+	return messages[0].ID, nil
+}
+
+// Stores the event of a stream entry in the local buffer and sends it to local subscribers
+func (r *RedisBus) deliver(log *logrus.Entry, message redis.XMessage) {
+	log = log.WithField("entry", message.ID)
+
+	id, err := strconv.ParseUint(strings.TrimSuffix(message.ID, "-0"), 10, 64)
+	if err != nil {
+		log.WithError(err).Error("could not parse event id")
+		return
+	}
+
+	payload, _ := message.Values[redisEventField].(string)
+
+	var decoded redisEventMessage
+	if err := json.Unmarshal([]byte(payload), &decoded); err != nil {
+		log.WithError(err).Error("could not decode event")
+		return
+	}
+
+	decoded.Event.ID = id
+	decoded.Event.Origin = decoded.Origin
+
+	r.local.lock.Lock()
+	r.local.deliver(decoded.Event)
+	r.local.lock.Unlock()
+}
+
+// Origin implements Bus.
+func (r *RedisBus) Origin() string {
+	return r.local.Origin()
+}
+
+// Publish implements Bus.
+func (r *RedisBus) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(redisEventMessage{Event: event, Origin: r.Origin()})
+	if err != nil {
+		return err
+	}
+
+	// The event reaches the local buffer through the stream like every other replica
+	return redisPublishScript.Run(ctx, r.client, []string{redisEventIDKey, redisEventStreamKey}, payload, r.bufferSize, redisEventField).Err()
+}
+
+// Subscribe implements Bus.
+func (r *RedisBus) Subscribe(ctx context.Context, afterID uint64) <-chan Event {
+	return r.local.Subscribe(ctx, afterID)
+}
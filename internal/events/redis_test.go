@@ -0,0 +1,128 @@
+package events_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/events"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func getRedisContainer(ctx context.Context) (testcontainers.Container, string, error) {
+	req := testcontainers.ContainerRequest{
+		Image:        "redis:latest",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForLog("Ready to accept connections"),
+	}
+
+	redisC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	endpoint, err := redisC.Endpoint(ctx, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	return redisC, endpoint, nil
+}
+
+func TestRedisBusAcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+	container, endpoint, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Fatalf("Could not stop redis: %s", err)
+		}
+	}()
+
+	replica0 := events.NewRedisBus(redis.NewClient(&redis.Options{Addr: endpoint}), 10)
+	replica1 := events.NewRedisBus(redis.NewClient(&redis.Options{Addr: endpoint}), 10)
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub := replica1.Subscribe(subCtx, 0)
+
+	// Give both replicas time to start reading the stream
+	time.Sleep(500 * time.Millisecond)
+
+	assert.NoError(t, replica0.Publish(ctx, events.Event{Identity: "customer0", Key: "fact0"}))
+	assert.NoError(t, replica0.Publish(ctx, events.Event{Identity: "customer0", Key: "fact1"}))
+
+	for _, key := range []string{"fact0", "fact1"} {
+		select {
+		case event := <-sub:
+			assert.Equal(t, key, event.Key)
+			assert.Equal(t, replica0.Origin(), event.Origin)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	replay := replica1.Subscribe(subCtx, 1)
+	event := <-replay
+	assert.Equal(t, uint64(2), event.ID)
+
+	// Replicas started later buffer the recent events so clients can resume on them
+	replica2 := events.NewRedisBus(redis.NewClient(&redis.Options{Addr: endpoint}), 10)
+	time.Sleep(500 * time.Millisecond)
+
+	replay = replica2.Subscribe(subCtx, 1)
+	event = <-replay
+	assert.Equal(t, uint64(2), event.ID)
+	assert.Equal(t, "fact1", event.Key)
+}
+
+func TestRedisBusOrder(t *testing.T) {
+	ctx := context.Background()
+	container, endpoint, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Fatalf("Could not stop redis: %s", err)
+		}
+	}()
+
+	replica0 := events.NewRedisBus(redis.NewClient(&redis.Options{Addr: endpoint}), 100)
+	replica1 := events.NewRedisBus(redis.NewClient(&redis.Options{Addr: endpoint}), 100)
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub := replica0.Subscribe(subCtx, 0)
+
+	// Replicas publishing at the same time still deliver events in the order of their ids
+	var wg sync.WaitGroup
+	for _, replica := range []*events.RedisBus{replica0, replica1} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				assert.NoError(t, replica.Publish(ctx, events.Event{Identity: "customer0"}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for want := uint64(1); want <= 50; want++ {
+		select {
+		case event := <-sub:
+			assert.Equal(t, want, event.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+}
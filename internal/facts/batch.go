@@ -0,0 +1,35 @@
+package facts
+
+import (
+	"context"
+	"errors"
+)
+
+// Providers that can read the facts of many identities in one round trip implement this
+type BatchFactReader interface {
+	// Returns the facts of every identity that exists by id. Missing identities are left out.
+	GetIdentitiesFacts(ctx context.Context, ids []string) (map[string]Facts, error)
+}
+
+// Returns the facts of every identity that exists by id using a single batch read
+// when the provider supports it, otherwise reading each identity in turn
+func GetIdentitiesFacts(ctx context.Context, provider FactProvider, ids []string) (map[string]Facts, error) {
+	if reader, ok := provider.(BatchFactReader); ok {
+		return reader.GetIdentitiesFacts(ctx, ids)
+	}
+
+	result := map[string]Facts{}
+	for _, id := range ids {
+		identityFacts, err := provider.GetIdentityFacts(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrIdentityNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		result[id] = identityFacts
+	}
+
+	return result, nil
+}
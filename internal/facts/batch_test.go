@@ -0,0 +1,27 @@
+package facts_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetIdentitiesFacts(t *testing.T) {
+	provider := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": facts.NewStringFact("foo")},
+			"customer1": {"fact0": facts.NewStringFact("bar")},
+			"customer2": {"fact0": facts.NewStringFact("baz")},
+		},
+	}
+
+	identities, err := facts.GetIdentitiesFacts(context.Background(), provider, []string{"customer0", "customer2", "missing"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]facts.Facts{
+			"customer0": {"fact0": facts.NewStringFact("foo")},
+			"customer2": {"fact0": facts.NewStringFact("baz")},
+		}, identities)
+	}
+}
@@ -0,0 +1,166 @@
+package facts_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+)
+
+// Checks the environment overrides of a provider behave the same for every provider
+func testProviderEnvironments(t *testing.T, ctx context.Context, provider facts.FactProvider) {
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer0", "fact0", facts.NewStringFact("foo")))
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer0", "fact1", facts.NewStringFact("bar")))
+
+	assert.NoError(t, provider.SetIdentityEnvironmentFact(ctx, "customer0", "prod", "fact0", facts.NewStringFact("baz")))
+	assert.NoError(t, provider.SetIdentityEnvironmentFact(ctx, "customer0", "prod", "fact2", facts.Fact{Type: facts.FactTypeNumber, Value: "3"}))
+	assert.NoError(t, provider.SetIdentityEnvironmentFact(ctx, "customer0", "staging", "fact1", facts.NewStringFact("qux")))
+	assert.ErrorIs(t, provider.SetIdentityEnvironmentFact(ctx, "customer0", "prod:eu", "fact0", facts.NewStringFact("baz")), facts.ErrInvalidEnvironment)
+
+	envs, err := provider.GetIdentityEnvironments(ctx, "customer0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"prod", "staging"}, envs)
+	}
+
+	// Overrides do not change the base facts
+	base, err := provider.GetIdentityFacts(ctx, "customer0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, facts.Facts{"fact0": facts.NewStringFact("foo"), "fact1": facts.NewStringFact("bar")}, base)
+	}
+
+	prod, err := facts.GetIdentityFactsInEnvironment(ctx, provider, "customer0", "prod")
+	if assert.NoError(t, err) {
+		assert.Equal(t, facts.Facts{
+			"fact0": facts.NewStringFact("baz"),
+			"fact1": facts.NewStringFact("bar"),
+			"fact2": {Type: facts.FactTypeNumber, Value: "3"},
+		}, prod)
+	}
+
+	overrides, err := facts.GetIdentityOverridesByKey(ctx, provider, "customer0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, facts.Facts{
+			"prod:fact0":    facts.NewStringFact("baz"),
+			"prod:fact2":    {Type: facts.FactTypeNumber, Value: "3"},
+			"staging:fact1": facts.NewStringFact("qux"),
+		}, overrides)
+
+		env, key := facts.SplitOverrideKey(facts.OverrideKey("prod", "fact:with:colons"))
+		assert.Equal(t, "prod", env)
+		assert.Equal(t, "fact:with:colons", key)
+	}
+
+	// Environments without overrides fall back to the base facts
+	dev, err := facts.GetIdentityFactsInEnvironment(ctx, provider, "customer0", "dev")
+	if assert.NoError(t, err) {
+		assert.Equal(t, base, dev)
+	}
+
+	assert.NoError(t, provider.DeleteIdentityEnvironmentFact(ctx, "customer0", "staging", "fact1"))
+	envs, err = provider.GetIdentityEnvironments(ctx, "customer0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"prod"}, envs)
+	}
+
+	// Deleting the identity removes its overrides
+	assert.NoError(t, provider.DeleteIdentity(ctx, "customer0"))
+	envs, err = provider.GetIdentityEnvironments(ctx, "customer0")
+	if assert.NoError(t, err) {
+		assert.Empty(t, envs)
+	}
+
+	_, err = facts.GetIdentityFactsInEnvironment(ctx, provider, "customer0", "prod")
+	assert.ErrorIs(t, err, facts.ErrIdentityNotFound)
+
+	// Identities that only have overrides are still listed
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer2", "fact0", facts.NewStringFact("foo")))
+	assert.NoError(t, provider.SetIdentityEnvironmentFact(ctx, "customer1", "prod", "fact0", facts.NewStringFact("baz")))
+	ids, err := provider.GetAllIdentities(ctx)
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"customer1", "customer2"}, ids)
+	}
+}
+
+func TestMockEnvironments(t *testing.T) {
+	// The mock only sets facts of existing identities
+	testProviderEnvironments(t, context.Background(), &facts.MockFactsProvider{FactsDB: map[string]facts.Facts{
+		"customer0": {},
+		"customer2": {},
+	}})
+}
+
+func TestRedisEnvironments(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	provider := facts.NewRedisFactProvider(client)
+	testProviderEnvironments(t, ctx, provider)
+
+	// Override keys are not mistaken for identities of the string layout
+	provider.SetIdentityFact(ctx, "customer1", "fact0", facts.NewStringFact("foo"))
+	provider.SetIdentityEnvironmentFact(ctx, "customer1", "prod", "fact0", facts.NewStringFact("bar"))
+
+	ids, err := provider.GetAllIdentities(ctx)
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"customer1", "customer2"}, ids)
+	}
+}
+
+func TestRedisReservedIdentity(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	provider := facts.NewRedisFactProvider(client)
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer0", "fact0", facts.NewStringFact("foo")))
+	assert.NoError(t, provider.SetIdentityEnvironmentFact(ctx, "customer0", "prod", "fact0", facts.NewStringFact("bar")))
+
+	// The keys of these identities would match the environment keys
+	for _, id := range []string{"flagops", "flagops:environments"} {
+		assert.ErrorIs(t, provider.DeleteIdentity(ctx, id), facts.ErrReservedIdentity)
+
+		_, err := provider.GetIdentityFacts(ctx, id)
+		assert.ErrorIs(t, err, facts.ErrReservedIdentity)
+	}
+
+	// Glob characters only match the identity itself
+	assert.NoError(t, provider.DeleteIdentity(ctx, "c*"))
+
+	prod, err := facts.GetIdentityFactsInEnvironment(ctx, provider, "customer0", "prod")
+	if assert.NoError(t, err) {
+		assert.Equal(t, facts.Facts{"fact0": facts.NewStringFact("bar")}, prod)
+	}
+}
+
+func TestRedisHashEnvironments(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	testProviderEnvironments(t, ctx, facts.NewRedisHashFactProvider(client))
+
+	// Identities with overrides are indexed so listing does not scan the keyspace
+	assert.Equal(t, []string{"customer1"}, client.SMembers(ctx, "flagops:overridden").Val())
+
+	assert.NoError(t, facts.NewRedisHashFactProvider(client).DeleteIdentityEnvironmentFact(ctx, "customer1", "prod", "fact0"))
+	assert.Empty(t, client.SMembers(ctx, "flagops:overridden").Val())
+}
+
+func TestPostgresEnvironments(t *testing.T) {
+	ctx := context.Background()
+	container, provider := getPostgresProvider(t, ctx)
+	defer closePostgresContainer(t, ctx, container)
+
+	testProviderEnvironments(t, ctx, provider)
+}
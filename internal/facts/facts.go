@@ -1,36 +1,193 @@
 package facts
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 
-	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/config"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
-type Facts map[string]string
+type Facts map[string]Fact
 
 var (
 	ErrIdentityNotFound = errors.New("identity not found")
 	ErrSecretNotFound   = errors.New("fact not found")
+
+	ErrInvalidEnvironment = errors.New("invalid environment")
+
+	// Returned by conditional updates when the facts changed since they were read
+	ErrVersionMismatch = errors.New("facts version does not match")
+
+	// Returned by an ObservedFactProvider when a change was stored but a recorder failed to record it
+	ErrChangeNotRecorded = errors.New("fact change was stored but not recorded")
 )
 
 type FactProvider interface {
-	// Returns a list of all available identities in the provider
-	GetAllIdentities(ctx *gin.Context) ([]string, error)
+	// Returns a list of all available identities in the provider including
+	// identities that only have environment overrides
+	GetAllIdentities(ctx context.Context) ([]string, error)
 
 	// Deletes all records belonging to identity
-	DeleteIdentity(ctx *gin.Context, id string) error
+	DeleteIdentity(ctx context.Context, id string) error
 
 	// Returns all facts belonging to the identity
-	GetIdentityFacts(ctx *gin.Context, id string) (Facts, error)
+	GetIdentityFacts(ctx context.Context, id string) (Facts, error)
 
 	// Set the key for the given identity to the value
-	SetIdentityFact(ctx *gin.Context, id string, key string, value string) error
+	SetIdentityFact(ctx context.Context, id string, key string, value Fact) error
 
 	// Deletes the key for the given identity
-	DeleteIdentityFact(ctx *gin.Context, id string, key string) error
+	DeleteIdentityFact(ctx context.Context, id string, key string) error
+
+	// Sets and deletes multiple keys for the given identity in a single atomic operation
+	UpdateIdentityFacts(ctx context.Context, id string, set Facts, remove []string) error
+
+	// Same as UpdateIdentityFacts but only applied while the facts of the identity are
+	// still at the version returned by Version. Returns ErrVersionMismatch otherwise.
+	UpdateIdentityFactsIfMatch(ctx context.Context, id string, version string, set Facts, remove []string) error
+
+	// Returns the environments the identity has overrides in
+	GetIdentityEnvironments(ctx context.Context, id string) ([]string, error)
+
+	// Returns only the facts overriding the base facts of the identity in the environment
+	GetIdentityEnvironmentFacts(ctx context.Context, id string, env string) (Facts, error)
+
+	// Set the key for the given identity in the environment without changing the base fact
+	SetIdentityEnvironmentFact(ctx context.Context, id string, env string, key string, value Fact) error
+
+	// Deletes the override of the key for the given identity in the environment
+	DeleteIdentityEnvironmentFact(ctx context.Context, id string, env string, key string) error
+}
+
+var environmentPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Checks the environment name can be stored by every provider
+func CheckEnvironment(env string) error {
+	if !environmentPattern.MatchString(env) {
+		return fmt.Errorf("%w: %q may only contain letters, numbers, - and _", ErrInvalidEnvironment, env)
+	}
+	return nil
+}
+
+// Returns the facts of the identity in the environment with its overrides applied
+// over the base facts. An empty environment returns the base facts.
+func GetIdentityFactsInEnvironment(ctx context.Context, provider FactProvider, id string, env string) (Facts, error) {
+	base, err := provider.GetIdentityFacts(ctx, id)
+	if env == "" {
+		return base, err
+	}
+
+	if err != nil && !errors.Is(err, ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	overrides, err := provider.GetIdentityEnvironmentFacts(ctx, id, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(base) == 0 && len(overrides) == 0 {
+		return nil, ErrIdentityNotFound
+	}
+
+	result := Facts{}
+	for key, value := range base {
+		result[key] = value
+	}
+
+	for key, value := range overrides {
+		result[key] = value
+	}
+
+	return result, nil
+}
+
+// Returns the overrides of the identity by environment. Environments without overrides are left out.
+func GetIdentityOverrides(ctx context.Context, provider FactProvider, id string) (map[string]Facts, error) {
+	envs, err := provider.GetIdentityEnvironments(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]Facts{}
+	for _, env := range envs {
+		overrides, err := provider.GetIdentityEnvironmentFacts(ctx, id, env)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(overrides) > 0 {
+			result[env] = overrides
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the overrides of the identity in every environment keyed by OverrideKey so
+// they can be compared like facts
+func GetIdentityOverridesByKey(ctx context.Context, provider FactProvider, id string) (Facts, error) {
+	overrides, err := GetIdentityOverrides(ctx, provider, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := Facts{}
+	for env, envFacts := range overrides {
+		for key, value := range envFacts {
+			result[OverrideKey(env, key)] = value
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the env:key form of an override. Environment names can not contain a colon
+// so SplitOverrideKey always recovers the environment and key.
+func OverrideKey(env string, key string) string {
+	return env + ":" + key
+}
+
+// Returns the environment and key of an override in the form returned by OverrideKey
+func SplitOverrideKey(override string) (string, string) {
+	env, key, _ := strings.Cut(override, ":")
+	return env, key
+}
+
+// Checks the arguments of UpdateIdentityFacts are usable by every provider
+func checkUpdate(id string, set Facts, remove []string) error {
+	if id == "" {
+		return errors.New("id is blank")
+	}
+
+	for key, value := range set {
+		if key == "" {
+			return errors.New("key is blank")
+		}
+
+		if value.Value == "" {
+			return fmt.Errorf("value of %s is blank", key)
+		}
+	}
+
+	for _, key := range remove {
+		if key == "" {
+			return errors.New("key is blank")
+		}
+
+		if _, ok := set[key]; ok {
+			return fmt.Errorf("%s is both set and removed", key)
+		}
+	}
+
+	return nil
 }
 
 func GetFactProvider(config config.FactsProviderOptions) (FactProvider, error) {
@@ -41,7 +198,21 @@ func GetFactProvider(config config.FactsProviderOptions) (FactProvider, error) {
 		  return nil, err
 		}
 
-		return NewRedisFactProvider(redis.NewClient(opts)), nil
+		switch config.RedisLayout {
+		case "string":
+			return NewRedisFactProvider(redis.NewClient(opts)), nil
+		case "hash":
+			return NewRedisHashFactProvider(redis.NewClient(opts)), nil
+		default:
+			return nil, fmt.Errorf("no such redis layout %s", config.RedisLayout)
+		}
+	case "postgres":
+		client, err := gorm.Open(postgres.Open(config.PostgresDSN))
+		if err != nil {
+		  return nil, err
+		}
+
+		return NewPostgresFactProvider(client)
 	default:
 		return nil, fmt.Errorf("no such fact provider %s", config.Provider)
 	}
@@ -50,11 +221,16 @@ func GetFactProvider(config config.FactsProviderOptions) (FactProvider, error) {
 var _ FactProvider = &MockFactsProvider{}
 
 type MockFactsProvider struct {
-	FactsDB map[string]map[string]string // Holds our "facts lookup table"
+	FactsDB map[string]Facts // Holds our "facts lookup table"
+
+	// Overrides of each identity by environment
+	EnvironmentsDB map[string]map[string]Facts
 }
 
 // DeleteIdentity implements FactProvider.
-func (m *MockFactsProvider) DeleteIdentity(ctx *gin.Context, id string) error {
+func (m *MockFactsProvider) DeleteIdentity(ctx context.Context, id string) error {
+	delete(m.EnvironmentsDB, id)
+
 	if _, ok := m.FactsDB[id]; !ok {
 		return nil
 	}
@@ -64,7 +240,7 @@ func (m *MockFactsProvider) DeleteIdentity(ctx *gin.Context, id string) error {
 }
 
 // DeleteIdentityFact implements FactProvider.
-func (m *MockFactsProvider) DeleteIdentityFact(ctx *gin.Context, id string, key string) error {
+func (m *MockFactsProvider) DeleteIdentityFact(ctx context.Context, id string, key string) error {
 	if _, ok := m.FactsDB[id]; !ok {
 		return nil
 	}
@@ -78,16 +254,22 @@ func (m *MockFactsProvider) DeleteIdentityFact(ctx *gin.Context, id string, key
 }
 
 // GetAllIdentities implements FactProvider.
-func (m *MockFactsProvider) GetAllIdentities(ctx *gin.Context) ([]string, error) {
+func (m *MockFactsProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
 	ids := []string{}
 	for k := range m.FactsDB {
 		ids = append(ids, k)
 	}
+
+	for k, envs := range m.EnvironmentsDB {
+		if _, ok := m.FactsDB[k]; !ok && len(envs) > 0 {
+			ids = append(ids, k)
+		}
+	}
 	return ids, nil
 }
 
 // GetIdentityFacts implements FactProvider.
-func (m *MockFactsProvider) GetIdentityFacts(ctx *gin.Context, id string) (Facts, error) {
+func (m *MockFactsProvider) GetIdentityFacts(ctx context.Context, id string) (Facts, error) {
 	identityFacts, ok := m.FactsDB[id]
 	if !ok {
 		return nil, ErrIdentityNotFound
@@ -97,7 +279,7 @@ func (m *MockFactsProvider) GetIdentityFacts(ctx *gin.Context, id string) (Facts
 }
 
 // SetIdentityFact implements FactProvider.
-func (m *MockFactsProvider) SetIdentityFact(ctx *gin.Context, id string, key string, value string) error {
+func (m *MockFactsProvider) SetIdentityFact(ctx context.Context, id string, key string, value Fact) error {
 	identityFacts, ok := m.FactsDB[id]
 	if !ok {
 		return ErrIdentityNotFound
@@ -106,3 +288,96 @@ func (m *MockFactsProvider) SetIdentityFact(ctx *gin.Context, id string, key str
 	identityFacts[key] = value
 	return nil
 }
+
+// UpdateIdentityFacts implements FactProvider.
+func (m *MockFactsProvider) UpdateIdentityFacts(ctx context.Context, id string, set Facts, remove []string) error {
+	if err := checkUpdate(id, set, remove); err != nil {
+		return err
+	}
+
+	identityFacts, ok := m.FactsDB[id]
+	if !ok {
+		identityFacts = Facts{}
+	}
+
+	for key, value := range set {
+		identityFacts[key] = value
+	}
+
+	for _, key := range remove {
+		delete(identityFacts, key)
+	}
+
+	if len(identityFacts) == 0 {
+		delete(m.FactsDB, id)
+		return nil
+	}
+
+	m.FactsDB[id] = identityFacts
+	return nil
+}
+
+// UpdateIdentityFactsIfMatch implements FactProvider.
+func (m *MockFactsProvider) UpdateIdentityFactsIfMatch(ctx context.Context, id string, version string, set Facts, remove []string) error {
+	if err := checkVersion(m.FactsDB[id], version); err != nil {
+		return err
+	}
+
+	return m.UpdateIdentityFacts(ctx, id, set, remove)
+}
+
+// GetIdentityEnvironments implements FactProvider.
+func (m *MockFactsProvider) GetIdentityEnvironments(ctx context.Context, id string) ([]string, error) {
+	envs := []string{}
+	for env := range m.EnvironmentsDB[id] {
+		envs = append(envs, env)
+	}
+	slices.Sort(envs)
+	return envs, nil
+}
+
+// GetIdentityEnvironmentFacts implements FactProvider.
+func (m *MockFactsProvider) GetIdentityEnvironmentFacts(ctx context.Context, id string, env string) (Facts, error) {
+	overrides, ok := m.EnvironmentsDB[id][env]
+	if !ok {
+		return Facts{}, nil
+	}
+
+	return overrides, nil
+}
+
+// SetIdentityEnvironmentFact implements FactProvider.
+func (m *MockFactsProvider) SetIdentityEnvironmentFact(ctx context.Context, id string, env string, key string, value Fact) error {
+	if err := CheckEnvironment(env); err != nil {
+		return err
+	}
+
+	if m.EnvironmentsDB == nil {
+		m.EnvironmentsDB = map[string]map[string]Facts{}
+	}
+
+	if m.EnvironmentsDB[id] == nil {
+		m.EnvironmentsDB[id] = map[string]Facts{}
+	}
+
+	if m.EnvironmentsDB[id][env] == nil {
+		m.EnvironmentsDB[id][env] = Facts{}
+	}
+
+	m.EnvironmentsDB[id][env][key] = value
+	return nil
+}
+
+// DeleteIdentityEnvironmentFact implements FactProvider.
+func (m *MockFactsProvider) DeleteIdentityEnvironmentFact(ctx context.Context, id string, env string, key string) error {
+	overrides, ok := m.EnvironmentsDB[id][env]
+	if !ok {
+		return nil
+	}
+
+	delete(overrides, key)
+	if len(overrides) == 0 {
+		delete(m.EnvironmentsDB[id], env)
+	}
+	return nil
+}
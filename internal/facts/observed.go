@@ -0,0 +1,381 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	_ FactProvider    = &ObservedFactProvider{}
+	_ SelectorQuerier = &ObservedFactProvider{}
+	_ BatchFactReader = &ObservedFactProvider{}
+)
+
+type ChangeOperation string
+
+const (
+	ChangeOperationSet    ChangeOperation = "set"
+	ChangeOperationDelete ChangeOperation = "delete"
+)
+
+// A single change to a fact. OldValue is nil when the fact did not exist and
+// NewValue is nil when the fact was deleted.
+type FactChange struct {
+	Identity string
+	Key      string
+
+	// Set when the change is to an environment override instead of the base facts
+	Environment string
+
+	Operation ChangeOperation
+	OldValue  *Fact
+	NewValue  *Fact
+
+	// The caller responsible for the change taken from the request context
+	Actor string
+	Time  time.Time
+}
+
+// Receives every change made through an ObservedFactProvider
+type FactObserver interface {
+	ObserveFactChange(ctx context.Context, change FactChange) error
+}
+
+// Wraps a fact provider and notifies observers of every successful change so
+// features like history work the same regardless of the provider storing the facts
+type ObservedFactProvider struct {
+	provider  FactProvider
+	observers []FactObserver
+
+	// Observers keeping records that must follow the stored facts
+	recorders []FactObserver
+}
+
+func NewObservedFactProvider(provider FactProvider, observers ...FactObserver) *ObservedFactProvider {
+	return &ObservedFactProvider{
+		provider:  provider,
+		observers: observers,
+	}
+}
+
+// Adds observers keeping records that must follow the stored facts, like the history.
+// Unlike other observers their failures are returned to the caller as ErrChangeNotRecorded.
+func (o *ObservedFactProvider) WithRecorders(recorders ...FactObserver) *ObservedFactProvider {
+	o.recorders = append(o.recorders, recorders...)
+	return o
+}
+
+func (o *ObservedFactProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "observed",
+		"api":      "facts",
+	})
+
+	if id != "" {
+		entry = entry.WithField("id", id)
+	}
+
+	if key != "" {
+		entry = entry.WithField("key", key)
+	}
+
+	return entry
+}
+
+// Returns a copy of the current facts of the identity treating a missing identity as having no facts
+func (o *ObservedFactProvider) currentFacts(ctx context.Context, id string) (Facts, error) {
+	current, err := o.provider.GetIdentityFacts(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrIdentityNotFound) {
+			return Facts{}, nil
+		}
+		return nil, err
+	}
+
+	// Providers may return the facts they hold themselves
+	result := Facts{}
+	for k, v := range current {
+		result[k] = v
+	}
+
+	return result, nil
+}
+
+// The change has already been stored so every observer is notified even when one fails.
+// Recorder failures are returned, other observer failures are only logged.
+func (o *ObservedFactProvider) notify(ctx context.Context, change FactChange) error {
+	change.Actor = requestmeta.Caller(ctx)
+	change.Time = time.Now()
+
+	errs := []error{}
+	for _, recorder := range o.recorders {
+		if err := recorder.ObserveFactChange(ctx, change); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrChangeNotRecorded, err))
+		}
+	}
+
+	for _, observer := range o.observers {
+		if err := observer.ObserveFactChange(ctx, change); err != nil {
+			o.getLogEntry(ctx, change.Identity, change.Key).WithError(err).Error("could not notify observer of fact change")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// GetAllIdentities implements FactProvider.
+func (o *ObservedFactProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	return o.provider.GetAllIdentities(ctx)
+}
+
+// GetIdentityFacts implements FactProvider.
+func (o *ObservedFactProvider) GetIdentityFacts(ctx context.Context, id string) (Facts, error) {
+	return o.provider.GetIdentityFacts(ctx, id)
+}
+
+// GetIdentitiesFacts implements BatchFactReader so batch reads still reach the wrapped provider.
+func (o *ObservedFactProvider) GetIdentitiesFacts(ctx context.Context, ids []string) (map[string]Facts, error) {
+	return GetIdentitiesFacts(ctx, o.provider, ids)
+}
+
+// QueryIdentities implements SelectorQuerier so queries are still pushed down to the wrapped provider.
+func (o *ObservedFactProvider) QueryIdentities(ctx context.Context, selector Selector) (map[string]Facts, error) {
+	return SelectIdentities(ctx, o.provider, selector)
+}
+
+// SetIdentityFact implements FactProvider.
+func (o *ObservedFactProvider) SetIdentityFact(ctx context.Context, id string, key string, value Fact) error {
+	current, err := o.currentFacts(ctx, id)
+	if err != nil {
+		return err
+	}
+	old, existed := current[key]
+
+	err = o.provider.SetIdentityFact(ctx, id, key, value)
+	if err != nil {
+		return err
+	}
+
+	change := FactChange{
+		Identity:  id,
+		Key:       key,
+		Operation: ChangeOperationSet,
+		NewValue:  &value,
+	}
+	if existed {
+		change.OldValue = &old
+	}
+
+	return o.notify(ctx, change)
+}
+
+// DeleteIdentityFact implements FactProvider.
+func (o *ObservedFactProvider) DeleteIdentityFact(ctx context.Context, id string, key string) error {
+	current, err := o.currentFacts(ctx, id)
+	if err != nil {
+		return err
+	}
+	old, existed := current[key]
+
+	err = o.provider.DeleteIdentityFact(ctx, id, key)
+	if err != nil {
+		return err
+	}
+
+	if !existed {
+		return nil
+	}
+
+	return o.notify(ctx, FactChange{
+		Identity:  id,
+		Key:       key,
+		Operation: ChangeOperationDelete,
+		OldValue:  &old,
+	})
+}
+
+// UpdateIdentityFacts implements FactProvider.
+func (o *ObservedFactProvider) UpdateIdentityFacts(ctx context.Context, id string, set Facts, remove []string) error {
+	current, err := o.currentFacts(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = o.provider.UpdateIdentityFacts(ctx, id, set, remove)
+	if err != nil {
+		return err
+	}
+
+	return o.notifyUpdate(ctx, id, current, set, remove)
+}
+
+// UpdateIdentityFactsIfMatch implements FactProvider.
+func (o *ObservedFactProvider) UpdateIdentityFactsIfMatch(ctx context.Context, id string, version string, set Facts, remove []string) error {
+	current, err := o.currentFacts(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// The version covers the content of the facts so when the update is applied
+	// current holds exactly the values it replaced
+	if err := checkVersion(current, version); err != nil {
+		return err
+	}
+
+	err = o.provider.UpdateIdentityFactsIfMatch(ctx, id, version, set, remove)
+	if err != nil {
+		return err
+	}
+
+	return o.notifyUpdate(ctx, id, current, set, remove)
+}
+
+// Notifies observers of every change made by an update of the identity
+func (o *ObservedFactProvider) notifyUpdate(ctx context.Context, id string, current Facts, set Facts, remove []string) error {
+	errs := []error{}
+	for _, key := range slices.Sorted(maps.Keys(set)) {
+		value := set[key]
+		change := FactChange{
+			Identity:  id,
+			Key:       key,
+			Operation: ChangeOperationSet,
+			NewValue:  &value,
+		}
+		if old, existed := current[key]; existed {
+			change.OldValue = &old
+		}
+
+		errs = append(errs, o.notify(ctx, change))
+	}
+
+	for _, key := range remove {
+		old, existed := current[key]
+		if !existed {
+			continue
+		}
+
+		errs = append(errs, o.notify(ctx, FactChange{
+			Identity:  id,
+			Key:       key,
+			Operation: ChangeOperationDelete,
+			OldValue:  &old,
+		}))
+	}
+
+	return errors.Join(errs...)
+}
+
+// GetIdentityEnvironments implements FactProvider.
+func (o *ObservedFactProvider) GetIdentityEnvironments(ctx context.Context, id string) ([]string, error) {
+	return o.provider.GetIdentityEnvironments(ctx, id)
+}
+
+// GetIdentityEnvironmentFacts implements FactProvider.
+func (o *ObservedFactProvider) GetIdentityEnvironmentFacts(ctx context.Context, id string, env string) (Facts, error) {
+	return o.provider.GetIdentityEnvironmentFacts(ctx, id, env)
+}
+
+// SetIdentityEnvironmentFact implements FactProvider.
+func (o *ObservedFactProvider) SetIdentityEnvironmentFact(ctx context.Context, id string, env string, key string, value Fact) error {
+	current, err := o.provider.GetIdentityEnvironmentFacts(ctx, id, env)
+	if err != nil {
+		return err
+	}
+	old, existed := current[key]
+
+	err = o.provider.SetIdentityEnvironmentFact(ctx, id, env, key, value)
+	if err != nil {
+		return err
+	}
+
+	change := FactChange{
+		Identity:    id,
+		Key:         key,
+		Environment: env,
+		Operation:   ChangeOperationSet,
+		NewValue:    &value,
+	}
+	if existed {
+		change.OldValue = &old
+	}
+
+	return o.notify(ctx, change)
+}
+
+// DeleteIdentityEnvironmentFact implements FactProvider.
+func (o *ObservedFactProvider) DeleteIdentityEnvironmentFact(ctx context.Context, id string, env string, key string) error {
+	current, err := o.provider.GetIdentityEnvironmentFacts(ctx, id, env)
+	if err != nil {
+		return err
+	}
+	old, existed := current[key]
+
+	err = o.provider.DeleteIdentityEnvironmentFact(ctx, id, env, key)
+	if err != nil {
+		return err
+	}
+
+	if !existed {
+		return nil
+	}
+
+	return o.notify(ctx, FactChange{
+		Identity:    id,
+		Key:         key,
+		Environment: env,
+		Operation:   ChangeOperationDelete,
+		OldValue:    &old,
+	})
+}
+
+// DeleteIdentity implements FactProvider.
+func (o *ObservedFactProvider) DeleteIdentity(ctx context.Context, id string) error {
+	current, err := o.currentFacts(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := GetIdentityOverrides(ctx, o.provider, id)
+	if err != nil {
+		return err
+	}
+
+	err = o.provider.DeleteIdentity(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, key := range slices.Sorted(maps.Keys(current)) {
+		old := current[key]
+		errs = append(errs, o.notify(ctx, FactChange{
+			Identity:  id,
+			Key:       key,
+			Operation: ChangeOperationDelete,
+			OldValue:  &old,
+		}))
+	}
+
+	// Deleting the identity also deletes its overrides in every environment
+	for _, env := range slices.Sorted(maps.Keys(overrides)) {
+		for _, key := range slices.Sorted(maps.Keys(overrides[env])) {
+			old := overrides[env][key]
+			errs = append(errs, o.notify(ctx, FactChange{
+				Identity:    id,
+				Key:         key,
+				Environment: env,
+				Operation:   ChangeOperationDelete,
+				OldValue:    &old,
+			}))
+		}
+	}
+
+	return errors.Join(errs...)
+}
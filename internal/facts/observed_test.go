@@ -0,0 +1,198 @@
+package facts_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingObserver struct {
+	changes []facts.FactChange
+}
+
+func (r *recordingObserver) ObserveFactChange(ctx context.Context, change facts.FactChange) error {
+	r.changes = append(r.changes, change)
+	return nil
+}
+
+type failingObserver struct{}
+
+func (f *failingObserver) ObserveFactChange(ctx context.Context, change facts.FactChange) error {
+	return errors.New("database is down")
+}
+
+func TestObservedFactProvider(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+	bar := facts.NewStringFact("bar")
+
+	observer := &recordingObserver{}
+	provider := facts.NewObservedFactProvider(&facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": foo},
+		},
+	}, observer)
+
+	ctx := requestmeta.WithCaller(context.Background(), "user:1")
+
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer0", "fact0", bar))
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer0", "fact1", foo))
+	assert.NoError(t, provider.DeleteIdentityFact(ctx, "customer0", "fact0"))
+	assert.NoError(t, provider.DeleteIdentityFact(ctx, "customer0", "missing"))
+
+	if assert.Len(t, observer.changes, 3) {
+		assert.Equal(t, facts.ChangeOperationSet, observer.changes[0].Operation)
+		assert.Equal(t, &foo, observer.changes[0].OldValue)
+		assert.Equal(t, &bar, observer.changes[0].NewValue)
+		assert.Equal(t, "user:1", observer.changes[0].Actor)
+
+		assert.Nil(t, observer.changes[1].OldValue)
+		assert.Equal(t, &foo, observer.changes[1].NewValue)
+
+		assert.Equal(t, facts.ChangeOperationDelete, observer.changes[2].Operation)
+		assert.Equal(t, &bar, observer.changes[2].OldValue)
+		assert.Nil(t, observer.changes[2].NewValue)
+	}
+}
+
+func TestObservedFactProviderUpdate(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+	bar := facts.NewStringFact("bar")
+
+	observer := &recordingObserver{}
+	mock := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": foo, "fact1": foo},
+		},
+	}
+	provider := facts.NewObservedFactProvider(mock, observer)
+
+	err := provider.UpdateIdentityFacts(context.Background(), "customer0", facts.Facts{"fact0": bar, "fact2": bar}, []string{"fact1", "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, facts.Facts{"fact0": bar, "fact2": bar}, mock.FactsDB["customer0"])
+
+	if assert.Len(t, observer.changes, 3) {
+		assert.Equal(t, "fact0", observer.changes[0].Key)
+		assert.Equal(t, &foo, observer.changes[0].OldValue)
+		assert.Equal(t, &bar, observer.changes[0].NewValue)
+
+		assert.Equal(t, "fact2", observer.changes[1].Key)
+		assert.Nil(t, observer.changes[1].OldValue)
+
+		assert.Equal(t, "fact1", observer.changes[2].Key)
+		assert.Equal(t, facts.ChangeOperationDelete, observer.changes[2].Operation)
+	}
+
+	err = provider.UpdateIdentityFacts(context.Background(), "customer0", facts.Facts{"fact0": bar}, []string{"fact0"})
+	assert.Error(t, err)
+	assert.Len(t, observer.changes, 3)
+}
+
+func TestObservedFactProviderEnvironment(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+	bar := facts.NewStringFact("bar")
+
+	observer := &recordingObserver{}
+	provider := facts.NewObservedFactProvider(&facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": foo},
+		},
+	}, observer)
+
+	ctx := context.Background()
+	assert.NoError(t, provider.SetIdentityEnvironmentFact(ctx, "customer0", "prod", "fact0", bar))
+	assert.NoError(t, provider.DeleteIdentityEnvironmentFact(ctx, "customer0", "prod", "fact0"))
+	assert.NoError(t, provider.DeleteIdentityEnvironmentFact(ctx, "customer0", "prod", "missing"))
+
+	if assert.Len(t, observer.changes, 2) {
+		// The base value is not the old value of an override
+		assert.Equal(t, "prod", observer.changes[0].Environment)
+		assert.Nil(t, observer.changes[0].OldValue)
+		assert.Equal(t, &bar, observer.changes[0].NewValue)
+
+		assert.Equal(t, "prod", observer.changes[1].Environment)
+		assert.Equal(t, facts.ChangeOperationDelete, observer.changes[1].Operation)
+		assert.Equal(t, &bar, observer.changes[1].OldValue)
+	}
+}
+
+func TestObservedFactProviderDeleteIdentity(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+	bar := facts.NewStringFact("bar")
+
+	observer := &recordingObserver{}
+	provider := facts.NewObservedFactProvider(&facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": foo},
+		},
+		EnvironmentsDB: map[string]map[string]facts.Facts{
+			"customer0": {"prod": {"fact0": bar}, "staging": {"fact1": bar}},
+		},
+	}, observer)
+
+	assert.NoError(t, provider.DeleteIdentity(context.Background(), "customer0"))
+
+	if assert.Len(t, observer.changes, 3) {
+		assert.Empty(t, observer.changes[0].Environment)
+		assert.Equal(t, &foo, observer.changes[0].OldValue)
+
+		// Overrides are deleted with the identity
+		assert.Equal(t, "prod", observer.changes[1].Environment)
+		assert.Equal(t, "fact0", observer.changes[1].Key)
+		assert.Equal(t, facts.ChangeOperationDelete, observer.changes[1].Operation)
+		assert.Equal(t, &bar, observer.changes[1].OldValue)
+
+		assert.Equal(t, "staging", observer.changes[2].Environment)
+		assert.Equal(t, "fact1", observer.changes[2].Key)
+	}
+}
+
+func TestObservedFactProviderUpdateIfMatch(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+	bar := facts.NewStringFact("bar")
+
+	observer := &recordingObserver{}
+	mock := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": foo},
+		},
+	}
+	provider := facts.NewObservedFactProvider(mock, observer)
+
+	version := facts.Version(mock.FactsDB["customer0"])
+	assert.NoError(t, provider.UpdateIdentityFactsIfMatch(context.Background(), "customer0", version, facts.Facts{"fact0": bar}, nil))
+
+	// Rejected updates are not observed
+	assert.ErrorIs(t, provider.UpdateIdentityFactsIfMatch(context.Background(), "customer0", version, nil, []string{"fact0"}), facts.ErrVersionMismatch)
+
+	if assert.Len(t, observer.changes, 1) {
+		assert.Equal(t, &foo, observer.changes[0].OldValue)
+		assert.Equal(t, &bar, observer.changes[0].NewValue)
+	}
+}
+
+func TestObservedFactProviderRecorders(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+
+	observer := &recordingObserver{}
+	mock := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": foo},
+		},
+	}
+	provider := facts.NewObservedFactProvider(mock, &failingObserver{}, observer)
+
+	// Observer failures are only logged
+	assert.NoError(t, provider.SetIdentityFact(context.Background(), "customer0", "fact1", foo))
+
+	provider.WithRecorders(&failingObserver{})
+
+	// Recorder failures are returned even though the change was stored and observed
+	assert.ErrorIs(t, provider.SetIdentityFact(context.Background(), "customer0", "fact2", foo), facts.ErrChangeNotRecorded)
+	assert.ErrorIs(t, provider.UpdateIdentityFacts(context.Background(), "customer0", nil, []string{"fact0"}), facts.ErrChangeNotRecorded)
+	assert.Equal(t, facts.Facts{"fact1": foo, "fact2": foo}, mock.FactsDB["customer0"])
+	assert.Len(t, observer.changes, 3)
+}
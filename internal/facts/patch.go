@@ -0,0 +1,80 @@
+package facts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Applies an RFC 7396 merge patch to a decoded JSON value
+func mergePatchValue(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+
+		targetObject[key] = mergePatchValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// Returns the facts to set and the keys to remove to apply an RFC 7396 merge patch
+// to the facts of an identity. Null removes a fact and objects are merged into
+// existing object facts.
+func MergePatch(current Facts, patch map[string]json.RawMessage) (Facts, []string, error) {
+	set := Facts{}
+	remove := []string{}
+
+	for key, raw := range patch {
+		trimmed := bytes.TrimSpace(raw)
+		if bytes.Equal(trimmed, []byte("null")) {
+			if _, ok := current[key]; ok {
+				remove = append(remove, key)
+			}
+			continue
+		}
+
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			fact, err := ParseJSONFact(trimmed)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", key, err)
+			}
+			set[key] = fact
+			continue
+		}
+
+		// Objects are merged into an empty object when there is no object fact so nested nulls are dropped
+		var target any = map[string]any{}
+		if existing, ok := current[key]; ok && existing.Type == FactTypeJSON {
+			target = existing.Native()
+		}
+
+		var patchValue any
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		if err := decoder.Decode(&patchValue); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		merged, err := json.Marshal(mergePatchValue(target, patchValue))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		set[key] = Fact{Type: FactTypeJSON, Value: string(merged)}
+	}
+
+	return set, remove, nil
+}
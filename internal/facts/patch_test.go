@@ -0,0 +1,74 @@
+package facts_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePatch(t *testing.T) {
+	current := facts.Facts{
+		"tier":    facts.NewStringFact("gold"),
+		"limits":  facts.Fact{Type: facts.FactTypeJSON, Value: `{"cpu":2,"memory":"4Gi","burst":{"enabled":true}}`},
+		"regions": facts.Fact{Type: facts.FactTypeJSON, Value: `["us-east-1"]`},
+	}
+
+	tests := []struct {
+		name           string
+		patch          string
+		expectedSet    facts.Facts
+		expectedRemove []string
+	}{
+		{
+			name:           "set and remove",
+			patch:          `{"tier": "platinum", "replicas": 3, "regions": null}`,
+			expectedSet:    facts.Facts{"tier": facts.NewStringFact("platinum"), "replicas": {Type: facts.FactTypeNumber, Value: "3"}},
+			expectedRemove: []string{"regions"},
+		},
+		{
+			name:           "remove missing fact",
+			patch:          `{"missing": null}`,
+			expectedSet:    facts.Facts{},
+			expectedRemove: []string{},
+		},
+		{
+			name:           "merge object fact",
+			patch:          `{"limits": {"cpu": 4, "memory": null, "burst": {"max": 8}}}`,
+			expectedSet:    facts.Facts{"limits": {Type: facts.FactTypeJSON, Value: `{"burst":{"enabled":true,"max":8},"cpu":4}`}},
+			expectedRemove: []string{},
+		},
+		{
+			name:           "replace non object fact",
+			patch:          `{"regions": {"primary": "us-east-1"}}`,
+			expectedSet:    facts.Facts{"regions": {Type: facts.FactTypeJSON, Value: `{"primary":"us-east-1"}`}},
+			expectedRemove: []string{},
+		},
+		{
+			name:           "nested nulls in new fact",
+			patch:          `{"quota": {"cpu": 2, "memory": null, "burst": {"max": null}}}`,
+			expectedSet:    facts.Facts{"quota": {Type: facts.FactTypeJSON, Value: `{"burst":{},"cpu":2}`}},
+			expectedRemove: []string{},
+		},
+		{
+			name:           "nested nulls replacing string fact",
+			patch:          `{"tier": {"name": "gold", "legacy": null}}`,
+			expectedSet:    facts.Facts{"tier": {Type: facts.FactTypeJSON, Value: `{"name":"gold"}`}},
+			expectedRemove: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch map[string]json.RawMessage
+			assert.NoError(t, json.Unmarshal([]byte(tt.patch), &patch))
+
+			set, remove, err := facts.MergePatch(current, patch)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedSet, set)
+				assert.Equal(t, tt.expectedRemove, remove)
+			}
+		})
+	}
+}
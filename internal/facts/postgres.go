@@ -0,0 +1,525 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	_ FactProvider    = &PostgresFactProvider{}
+	_ SelectorQuerier = &PostgresFactProvider{}
+	_ BatchFactReader = &PostgresFactProvider{}
+)
+
+// A single fact row stored in the facts table. The composite primary key
+// covers lookups by identity and the extra index covers lookups by key.
+type factRecord struct {
+	Identity string   `gorm:"primaryKey"`
+	Key      string   `gorm:"primaryKey;index"`
+	Type     FactType `gorm:"not null;default:string"`
+	Value    string   `gorm:"not null"`
+}
+
+func (factRecord) TableName() string {
+	return "facts"
+}
+
+// An override of a fact for an identity in a single environment
+type factEnvironmentRecord struct {
+	Identity    string   `gorm:"primaryKey"`
+	Environment string   `gorm:"primaryKey"`
+	Key         string   `gorm:"primaryKey"`
+	Type        FactType `gorm:"not null;default:string"`
+	Value       string   `gorm:"not null"`
+}
+
+func (factEnvironmentRecord) TableName() string {
+	return "fact_environments"
+}
+
+// A facts provider backed by a postgres table
+type PostgresFactProvider struct {
+	client *gorm.DB
+}
+
+// Creates a new postgres facts provider and runs auto migration on the facts tables
+func NewPostgresFactProvider(client *gorm.DB) (*PostgresFactProvider, error) {
+	err := client.AutoMigrate(&factRecord{}, &factEnvironmentRecord{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgresFactProvider{
+		client: client,
+	}, nil
+}
+
+// Holds a lock on the facts of the identity until the transaction ends. Every writer
+// takes it so conditional updates cannot interleave with other writes.
+func lockIdentity(tx *gorm.DB, id string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext('flagops:facts'), hashtext(?))", id).Error
+}
+
+func (p *PostgresFactProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "postgres",
+		"api":      "facts",
+	})
+
+	if id != "" {
+		entry = entry.WithField("id", id)
+	}
+
+	if key != "" {
+		entry = entry.WithField("key", key)
+	}
+
+	return entry
+}
+
+// GetAllIdentities implements FactProvider.
+func (p *PostgresFactProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	log := p.getLogEntry(ctx, "", "")
+	log.Debug("fetching all identities from provider")
+
+	// Identities may only have environment overrides
+	ids := []string{}
+	err := p.client.WithContext(ctx).Raw("SELECT identity FROM facts UNION SELECT identity FROM fact_environments").Scan(&ids).Error
+	if err != nil {
+		log.WithError(err).Error("could not fetch identities from provider")
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetIdentityFacts implements FactProvider.
+func (p *PostgresFactProvider) GetIdentityFacts(ctx context.Context, id string) (Facts, error) {
+	log := p.getLogEntry(ctx, id, "")
+	if id == "" {
+		log.Debug("called with no identity")
+		return nil, errors.New("id is blank")
+	}
+
+	records := []factRecord{}
+	err := p.client.WithContext(ctx).Where("identity = ?", id).Find(&records).Error
+	if err != nil {
+		log.WithError(err).Error("could not fetch facts from provider")
+		return nil, err
+	}
+	log.WithField("keys", len(records)).Debug("fetched identity facts")
+
+	if len(records) == 0 {
+		return nil, ErrIdentityNotFound
+	}
+
+	result := Facts{}
+	for _, r := range records {
+		result[r.Key] = Fact{Type: r.Type, Value: r.Value}
+	}
+
+	return result, nil
+}
+
+// GetIdentitiesFacts implements BatchFactReader.
+func (p *PostgresFactProvider) GetIdentitiesFacts(ctx context.Context, ids []string) (map[string]Facts, error) {
+	log := p.getLogEntry(ctx, "", "").WithField("identities", len(ids))
+	log.Debug("fetching facts of identities from provider")
+
+	result := map[string]Facts{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	records := []factRecord{}
+	err := p.client.WithContext(ctx).Where("identity IN ?", ids).Find(&records).Error
+	if err != nil {
+		log.WithError(err).Error("could not fetch facts from provider")
+		return nil, err
+	}
+
+	for _, r := range records {
+		if _, ok := result[r.Identity]; !ok {
+			result[r.Identity] = Facts{}
+		}
+		result[r.Identity][r.Key] = Fact{Type: r.Type, Value: r.Value}
+	}
+
+	return result, nil
+}
+
+// SetIdentityFact implements FactProvider.
+func (p *PostgresFactProvider) SetIdentityFact(ctx context.Context, id string, key string, value Fact) error {
+	log := p.getLogEntry(ctx, id, key)
+	log.Debug("setting fact for identity")
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	if value.Value == "" {
+		log.Debug("called with no value")
+		return errors.New("value is blank")
+	}
+
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "identity"}, {Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"type", "value"}),
+		}).Create(&factRecord{Identity: id, Key: key, Type: value.Type, Value: value.Value}).Error
+	})
+	if err != nil {
+		log.WithError(err).Error("could not set key in provider")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIdentityFact implements FactProvider.
+func (p *PostgresFactProvider) DeleteIdentityFact(ctx context.Context, id string, key string) error {
+	log := p.getLogEntry(ctx, id, key)
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	log.Debug("deleting identity fact")
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		return tx.Where("identity = ? AND key = ?", id, key).Delete(&factRecord{}).Error
+	})
+	if err != nil {
+		log.WithError(err).Error("could not delete key in provider")
+		return err
+	}
+
+	return nil
+}
+
+// UpdateIdentityFacts implements FactProvider.
+func (p *PostgresFactProvider) UpdateIdentityFacts(ctx context.Context, id string, set Facts, remove []string) error {
+	log := p.getLogEntry(ctx, id, "")
+	if err := checkUpdate(id, set, remove); err != nil {
+		log.WithError(err).Debug("called with invalid update")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"set": len(set), "remove": len(remove)}).Debug("updating identity facts")
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		return applyUpdate(tx, id, set, remove)
+	})
+	if err != nil {
+		log.WithError(err).Error("could not update identity facts in provider")
+		return err
+	}
+
+	return nil
+}
+
+// UpdateIdentityFactsIfMatch implements FactProvider.
+func (p *PostgresFactProvider) UpdateIdentityFactsIfMatch(ctx context.Context, id string, version string, set Facts, remove []string) error {
+	log := p.getLogEntry(ctx, id, "")
+	if err := checkUpdate(id, set, remove); err != nil {
+		log.WithError(err).Debug("called with invalid update")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"set": len(set), "remove": len(remove)}).Debug("updating identity facts if version matches")
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		records := []factRecord{}
+		if err := tx.Where("identity = ?", id).Find(&records).Error; err != nil {
+			return err
+		}
+
+		current := Facts{}
+		for _, r := range records {
+			current[r.Key] = Fact{Type: r.Type, Value: r.Value}
+		}
+
+		if err := checkVersion(current, version); err != nil {
+			return err
+		}
+
+		return applyUpdate(tx, id, set, remove)
+	})
+	if errors.Is(err, ErrVersionMismatch) {
+		log.Debug("identity facts version does not match")
+		return err
+	}
+	if err != nil {
+		log.WithError(err).Error("could not update identity facts in provider")
+		return err
+	}
+
+	return nil
+}
+
+// Sets and removes the facts of the identity inside a transaction holding its lock
+func applyUpdate(tx *gorm.DB, id string, set Facts, remove []string) error {
+	if len(set) > 0 {
+		records := []factRecord{}
+		for key, value := range set {
+			records = append(records, factRecord{Identity: id, Key: key, Type: value.Type, Value: value.Value})
+		}
+
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "identity"}, {Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"type", "value"}),
+		}).Create(&records).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(remove) > 0 {
+		return tx.Where("identity = ? AND key IN ?", id, remove).Delete(&factRecord{}).Error
+	}
+
+	return nil
+}
+
+// GetIdentityEnvironments implements FactProvider.
+func (p *PostgresFactProvider) GetIdentityEnvironments(ctx context.Context, id string) ([]string, error) {
+	log := p.getLogEntry(ctx, id, "")
+	if id == "" {
+		log.Debug("called with no identity")
+		return nil, errors.New("id is blank")
+	}
+
+	envs := []string{}
+	err := p.client.WithContext(ctx).Model(&factEnvironmentRecord{}).Where("identity = ?", id).Distinct("environment").Order("environment").Pluck("environment", &envs).Error
+	if err != nil {
+		log.WithError(err).Error("could not fetch environments from provider")
+		return nil, err
+	}
+
+	return envs, nil
+}
+
+// GetIdentityEnvironmentFacts implements FactProvider.
+func (p *PostgresFactProvider) GetIdentityEnvironmentFacts(ctx context.Context, id string, env string) (Facts, error) {
+	log := p.getLogEntry(ctx, id, "").WithField("env", env)
+	if id == "" {
+		log.Debug("called with no identity")
+		return nil, errors.New("id is blank")
+	}
+
+	if err := CheckEnvironment(env); err != nil {
+		return nil, err
+	}
+
+	records := []factEnvironmentRecord{}
+	err := p.client.WithContext(ctx).Where("identity = ? AND environment = ?", id, env).Find(&records).Error
+	if err != nil {
+		log.WithError(err).Error("could not fetch environment facts from provider")
+		return nil, err
+	}
+
+	result := Facts{}
+	for _, r := range records {
+		result[r.Key] = Fact{Type: r.Type, Value: r.Value}
+	}
+
+	return result, nil
+}
+
+// SetIdentityEnvironmentFact implements FactProvider.
+func (p *PostgresFactProvider) SetIdentityEnvironmentFact(ctx context.Context, id string, env string, key string, value Fact) error {
+	log := p.getLogEntry(ctx, id, key).WithField("env", env)
+	log.Debug("setting environment fact for identity")
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if err := CheckEnvironment(env); err != nil {
+		return err
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	if value.Value == "" {
+		log.Debug("called with no value")
+		return errors.New("value is blank")
+	}
+
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "identity"}, {Name: "environment"}, {Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"type", "value"}),
+		}).Create(&factEnvironmentRecord{Identity: id, Environment: env, Key: key, Type: value.Type, Value: value.Value}).Error
+	})
+	if err != nil {
+		log.WithError(err).Error("could not set environment fact in provider")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIdentityEnvironmentFact implements FactProvider.
+func (p *PostgresFactProvider) DeleteIdentityEnvironmentFact(ctx context.Context, id string, env string, key string) error {
+	log := p.getLogEntry(ctx, id, key).WithField("env", env)
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if err := CheckEnvironment(env); err != nil {
+		return err
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	log.Debug("deleting environment fact")
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		return tx.Where("identity = ? AND environment = ? AND key = ?", id, env, key).Delete(&factEnvironmentRecord{}).Error
+	})
+	if err != nil {
+		log.WithError(err).Error("could not delete environment fact in provider")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIdentity implements FactProvider.
+func (p *PostgresFactProvider) DeleteIdentity(ctx context.Context, id string) error {
+	log := p.getLogEntry(ctx, id, "")
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	log.Debug("deleting identity")
+	err := p.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockIdentity(tx, id); err != nil {
+			return err
+		}
+
+		res := tx.Where("identity = ?", id).Delete(&factRecord{})
+		if res.Error != nil {
+			return res.Error
+		}
+		log.WithField("keys", res.RowsAffected).Debug("deleted identity facts")
+
+		return tx.Where("identity = ?", id).Delete(&factEnvironmentRecord{}).Error
+	})
+	if err != nil {
+		log.WithError(err).Error("could not delete identity in provider")
+		return err
+	}
+
+	return nil
+}
+
+// Builds the condition comparing the stored value the same way Requirement.Matches does
+func valueCondition(r Requirement) (string, []any) {
+	condition := "value IN ? OR (type = 'bool' AND value IN ?)"
+	args := []any{r.Values, r.boolValues()}
+
+	// Values are only cast inside the CASE so facts of other types are never parsed as numbers
+	if numbers := r.numberValues(); len(numbers) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("CAST(CAST(? AS text) AS numeric), ", len(numbers)), ", ")
+		condition += " OR CASE WHEN type = 'number' THEN CAST(value AS numeric) IN (" + placeholders + ") ELSE false END"
+		for _, number := range numbers {
+			args = append(args, number)
+		}
+	}
+
+	return "(" + condition + ")", args
+}
+
+// Builds the HAVING condition matching identities that meet the requirement
+func requirementCondition(r Requirement) (string, []any) {
+	switch r.Operator {
+	case SelectorExists:
+		return "bool_or(key = ?)", []any{r.Key}
+	case SelectorDoesNotExist:
+		return "NOT bool_or(key = ?)", []any{r.Key}
+	case SelectorEquals, SelectorIn:
+		condition, args := valueCondition(r)
+		return "bool_or(key = ? AND " + condition + ")", append([]any{r.Key}, args...)
+	case SelectorNotEquals, SelectorNotIn:
+		condition, args := valueCondition(r)
+		return "NOT bool_or(key = ? AND " + condition + ")", append([]any{r.Key}, args...)
+	default:
+		return "false", nil
+	}
+}
+
+// QueryIdentities implements SelectorQuerier.
+func (p *PostgresFactProvider) QueryIdentities(ctx context.Context, selector Selector) (map[string]Facts, error) {
+	log := p.getLogEntry(ctx, "", "")
+	log.Debug("querying identities from provider")
+
+	matching := p.client.WithContext(ctx).Model(&factRecord{}).Select("identity").Group("identity")
+	for _, requirement := range selector {
+		condition, args := requirementCondition(requirement)
+		matching = matching.Having(condition, args...)
+	}
+
+	records := []factRecord{}
+	err := p.client.WithContext(ctx).Where("identity IN (?)", matching).Find(&records).Error
+	if err != nil {
+		log.WithError(err).Error("could not query identities from provider")
+		return nil, err
+	}
+
+	result := map[string]Facts{}
+	for _, r := range records {
+		if _, ok := result[r.Identity]; !ok {
+			result[r.Identity] = Facts{}
+		}
+		result[r.Identity][r.Key] = Fact{Type: r.Type, Value: r.Value}
+	}
+	log.WithField("identities", len(result)).Debug("queried identities")
+
+	return result, nil
+}
@@ -0,0 +1,473 @@
+package facts_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func getPostgresContainer(ctx context.Context) (testcontainers.Container, *gorm.DB, error) {
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:16",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_USER":     "flagops",
+			"POSTGRES_PASSWORD": "password",
+			"POSTGRES_DB":       "flagops",
+		},
+		WaitingFor: wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).
+			WithStartupTimeout(time.Minute),
+	}
+
+	postgresC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	endpoint, err := postgresC.Endpoint(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := gorm.Open(postgres.Open(fmt.Sprintf("postgresql://flagops:password@%s/flagops?sslmode=disable", endpoint)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return postgresC, client, nil
+}
+
+func closePostgresContainer(t *testing.T, ctx context.Context, container testcontainers.Container) {
+	if err := container.Terminate(ctx); err != nil {
+		t.Fatalf("Could not stop postgres: %s", err)
+	}
+}
+
+func getPostgresProvider(t *testing.T, ctx context.Context) (testcontainers.Container, *facts.PostgresFactProvider) {
+	container, client, err := getPostgresContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start postgres: %s", err)
+	}
+
+	provider, err := facts.NewPostgresFactProvider(client)
+	if err != nil {
+		closePostgresContainer(t, ctx, container)
+		t.Fatalf("Could not migrate postgres: %s", err)
+	}
+
+	return container, provider
+}
+
+func TestPostgresGetAllIdentities(t *testing.T) {
+	ctx := context.Background()
+	container, provider := getPostgresProvider(t, ctx)
+	defer closePostgresContainer(t, ctx, container)
+
+	provider.SetIdentityFact(context.Background(), "customer0", "fact0", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(context.Background(), "customer0", "fact2", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(context.Background(), "customer1", "fact0", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(context.Background(), "customer1", "fact3", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(context.Background(), "customer2", "fact0", facts.NewStringFact("foo"))
+
+	ids, err := provider.GetAllIdentities(context.Background())
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"customer0", "customer1", "customer2"}, ids)
+	}
+}
+
+func TestPostgresSetIdentityFact(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		key           string
+		value         facts.Fact
+		setupDB       func(provider *facts.PostgresFactProvider)
+		expectError   bool
+		expectedFacts facts.Facts
+	}{
+		{
+			name:          "core function",
+			id:            "customer0",
+			key:           "fact0",
+			value:         facts.NewStringFact("foo"),
+			setupDB:       func(provider *facts.PostgresFactProvider) {},
+			expectError:   false,
+			expectedFacts: facts.Facts{"fact0": facts.NewStringFact("foo")},
+		},
+		{
+			name:  "overwrite existing value",
+			id:    "customer0",
+			key:   "fact0",
+			value: facts.NewStringFact("bar"),
+			setupDB: func(provider *facts.PostgresFactProvider) {
+				provider.SetIdentityFact(context.Background(), "customer0", "fact0", facts.NewStringFact("foo"))
+			},
+			expectError:   false,
+			expectedFacts: facts.Facts{"fact0": facts.NewStringFact("bar")},
+		},
+		{
+			name:          "typed value",
+			id:            "customer0",
+			key:           "fact0",
+			value:         facts.Fact{Type: facts.FactTypeNumber, Value: "42"},
+			setupDB:       func(provider *facts.PostgresFactProvider) {},
+			expectError:   false,
+			expectedFacts: facts.Facts{"fact0": {Type: facts.FactTypeNumber, Value: "42"}},
+		},
+		{
+			name:        "missing id",
+			id:          "",
+			key:         "fact0",
+			value:       facts.NewStringFact("foo"),
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+			expectError: true,
+		},
+		{
+			name:        "missing key",
+			id:          "customer0",
+			key:         "",
+			value:       facts.NewStringFact("foo"),
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+			expectError: true,
+		},
+		{
+			name:        "missing value",
+			id:          "customer0",
+			key:         "fact0",
+			value:       facts.NewStringFact(""),
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, provider := getPostgresProvider(t, ctx)
+			defer closePostgresContainer(t, ctx, container)
+
+			tt.setupDB(provider)
+
+			err := provider.SetIdentityFact(context.Background(), tt.id, tt.key, tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				if assert.NoError(t, err) {
+					identityFacts, err := provider.GetIdentityFacts(context.Background(), tt.id)
+					if assert.NoError(t, err) {
+						assert.Equal(t, tt.expectedFacts, identityFacts)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresGetIdentityFacts(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		setupDB       func(provider *facts.PostgresFactProvider)
+		expectError   error
+		expectedFacts facts.Facts
+	}{
+		{
+			name:        "core functionality",
+			id:          "customer0",
+			expectError: nil,
+			expectedFacts: facts.Facts{
+				"fact0": facts.NewStringFact("foo"),
+				"fact1": facts.NewStringFact("bar"),
+			},
+			setupDB: func(provider *facts.PostgresFactProvider) {
+				provider.SetIdentityFact(context.Background(), "customer0", "fact0", facts.NewStringFact("foo"))
+				provider.SetIdentityFact(context.Background(), "customer0", "fact1", facts.NewStringFact("bar"))
+				provider.SetIdentityFact(context.Background(), "customer1", "fact0", facts.NewStringFact("baz"))
+			},
+		},
+		{
+			name:        "identity does not exist",
+			id:          "customer0",
+			expectError: facts.ErrIdentityNotFound,
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, provider := getPostgresProvider(t, ctx)
+			defer closePostgresContainer(t, ctx, container)
+
+			tt.setupDB(provider)
+
+			identityFacts, err := provider.GetIdentityFacts(context.Background(), tt.id)
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.expectedFacts, identityFacts)
+				}
+			}
+		})
+	}
+
+	t.Run("missing id", func(t *testing.T) {
+		ctx := context.Background()
+		container, provider := getPostgresProvider(t, ctx)
+		defer closePostgresContainer(t, ctx, container)
+
+		_, err := provider.GetIdentityFacts(context.Background(), "")
+		assert.Error(t, err)
+	})
+}
+
+func TestPostgresDeleteIdentityFact(t *testing.T) {
+	var tests = []struct {
+		name        string
+		id          string
+		key         string
+		setupDB     func(provider *facts.PostgresFactProvider)
+		expectError bool
+	}{
+		{
+			name:        "core functionality",
+			id:          "customer0",
+			key:         "fact0",
+			expectError: false,
+			setupDB: func(provider *facts.PostgresFactProvider) {
+				provider.SetIdentityFact(context.Background(), "customer0", "fact0", facts.NewStringFact("foo"))
+				provider.SetIdentityFact(context.Background(), "customer0", "fact1", facts.NewStringFact("bar"))
+			},
+		},
+		{
+			name:        "key to delete does not exist",
+			id:          "customer0",
+			key:         "fact0",
+			expectError: false,
+			setupDB: func(provider *facts.PostgresFactProvider) {
+				provider.SetIdentityFact(context.Background(), "customer0", "fact1", facts.NewStringFact("bar"))
+			},
+		},
+		{
+			name:        "missing id",
+			id:          "",
+			key:         "fact0",
+			expectError: true,
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+		},
+		{
+			name:        "missing key",
+			id:          "customer0",
+			key:         "",
+			expectError: true,
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, provider := getPostgresProvider(t, ctx)
+			defer closePostgresContainer(t, ctx, container)
+
+			tt.setupDB(provider)
+
+			err := provider.DeleteIdentityFact(context.Background(), tt.id, tt.key)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				if assert.NoError(t, err) {
+					identityFacts, err := provider.GetIdentityFacts(context.Background(), tt.id)
+					if assert.NoError(t, err) {
+						assert.NotContains(t, identityFacts, tt.key)
+						assert.Contains(t, identityFacts, "fact1")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresDeleteIdentity(t *testing.T) {
+	var tests = []struct {
+		name        string
+		id          string
+		setupDB     func(provider *facts.PostgresFactProvider)
+		expectError bool
+	}{
+		{
+			name:        "core functionality",
+			id:          "customer0",
+			expectError: false,
+			setupDB: func(provider *facts.PostgresFactProvider) {
+				provider.SetIdentityFact(context.Background(), "customer0", "fact0", facts.NewStringFact("foo"))
+				provider.SetIdentityFact(context.Background(), "customer0", "fact1", facts.NewStringFact("bar"))
+			},
+		},
+		{
+			name:        "identity to delete does not exist",
+			id:          "customer0",
+			expectError: false,
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+		},
+		{
+			name:        "missing id",
+			id:          "",
+			expectError: true,
+			setupDB:     func(provider *facts.PostgresFactProvider) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, provider := getPostgresProvider(t, ctx)
+			defer closePostgresContainer(t, ctx, container)
+
+			tt.setupDB(provider)
+
+			err := provider.DeleteIdentity(context.Background(), tt.id)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				if assert.NoError(t, err) {
+					_, err := provider.GetIdentityFacts(context.Background(), tt.id)
+					assert.ErrorIs(t, err, facts.ErrIdentityNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresQueryIdentities(t *testing.T) {
+	ctx := context.Background()
+	container, provider := getPostgresProvider(t, ctx)
+	defer closePostgresContainer(t, ctx, container)
+
+	provider.SetIdentityFact(ctx, "customer0", "cluster", facts.NewStringFact("us-east-1"))
+	provider.SetIdentityFact(ctx, "customer0", "tier", facts.NewStringFact("gold"))
+	provider.SetIdentityFact(ctx, "customer1", "cluster", facts.NewStringFact("us-east-1"))
+	provider.SetIdentityFact(ctx, "customer1", "tier", facts.NewStringFact("silver"))
+	provider.SetIdentityFact(ctx, "customer2", "tier", facts.NewStringFact("platinum"))
+	provider.SetIdentityFact(ctx, "customer2", "deprecated", facts.NewStringFact("yes"))
+	provider.SetIdentityFact(ctx, "customer3", "replicas", facts.Fact{Type: facts.FactTypeNumber, Value: "3.0"})
+	provider.SetIdentityFact(ctx, "customer3", "enabled", facts.Fact{Type: facts.FactTypeBool, Value: "true"})
+	provider.SetIdentityFact(ctx, "customer4", "replicas", facts.NewStringFact("three"))
+
+	customer3 := map[string]facts.Facts{
+		"customer3": {"replicas": {Type: facts.FactTypeNumber, Value: "3.0"}, "enabled": {Type: facts.FactTypeBool, Value: "true"}},
+	}
+
+	tests := []struct {
+		selector string
+		expected map[string]facts.Facts
+	}{
+		{
+			selector: "replicas=3",
+			expected: customer3,
+		},
+		{
+			selector: "replicas in (three, 4),enabled!=false",
+			expected: map[string]facts.Facts{
+				"customer4": {"replicas": facts.NewStringFact("three")},
+			},
+		},
+		{
+			selector: "enabled=True,replicas notin (4)",
+			expected: customer3,
+		},
+		{
+			selector: "cluster=us-east-1,tier in (gold, platinum)",
+			expected: map[string]facts.Facts{
+				"customer0": {"cluster": facts.NewStringFact("us-east-1"), "tier": facts.NewStringFact("gold")},
+			},
+		},
+		{
+			selector: "tier,!deprecated,tier!=gold",
+			expected: map[string]facts.Facts{
+				"customer1": {"cluster": facts.NewStringFact("us-east-1"), "tier": facts.NewStringFact("silver")},
+			},
+		},
+		{
+			selector: "cluster notin (us-east-1),tier",
+			expected: map[string]facts.Facts{
+				"customer2": {"tier": facts.NewStringFact("platinum"), "deprecated": facts.NewStringFact("yes")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := facts.ParseSelector(tt.selector)
+			assert.NoError(t, err)
+
+			identities, err := provider.QueryIdentities(ctx, selector)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, identities)
+		})
+	}
+}
+
+func TestPostgresUpdateIdentityFacts(t *testing.T) {
+	ctx := context.Background()
+	container, provider := getPostgresProvider(t, ctx)
+	defer closePostgresContainer(t, ctx, container)
+
+	provider.SetIdentityFact(ctx, "customer0", "fact0", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(ctx, "customer0", "fact1", facts.NewStringFact("bar"))
+
+	err := provider.UpdateIdentityFacts(ctx, "customer0", facts.Facts{
+		"fact0": facts.NewStringFact("baz"),
+		"fact2": {Type: facts.FactTypeJSON, Value: `{"a":1}`},
+	}, []string{"fact1"})
+	if assert.NoError(t, err) {
+		identityFacts, err := provider.GetIdentityFacts(ctx, "customer0")
+		if assert.NoError(t, err) {
+			assert.Equal(t, facts.Facts{
+				"fact0": facts.NewStringFact("baz"),
+				"fact2": {Type: facts.FactTypeJSON, Value: `{"a":1}`},
+			}, identityFacts)
+		}
+	}
+
+	// A failing batch leaves every fact unchanged
+	err = provider.UpdateIdentityFacts(ctx, "customer0", facts.Facts{"fact0": facts.NewStringFact("")}, []string{"fact2"})
+	if assert.Error(t, err) {
+		identityFacts, err := provider.GetIdentityFacts(ctx, "customer0")
+		if assert.NoError(t, err) {
+			assert.Len(t, identityFacts, 2)
+		}
+	}
+}
+
+func TestPostgresGetIdentitiesFacts(t *testing.T) {
+	ctx := context.Background()
+	container, provider := getPostgresProvider(t, ctx)
+	defer closePostgresContainer(t, ctx, container)
+
+	provider.SetIdentityFact(ctx, "customer0", "fact0", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(ctx, "customer0", "fact1", facts.NewStringFact("bar"))
+	provider.SetIdentityFact(ctx, "customer1", "fact0", facts.NewStringFact("baz"))
+	provider.SetIdentityFact(ctx, "customer2", "fact0", facts.NewStringFact("qux"))
+
+	identities, err := provider.GetIdentitiesFacts(ctx, []string{"customer0", "customer1", "missing"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]facts.Facts{
+			"customer0": {"fact0": facts.NewStringFact("foo"), "fact1": facts.NewStringFact("bar")},
+			"customer1": {"fact0": facts.NewStringFact("baz")},
+		}, identities)
+	}
+}
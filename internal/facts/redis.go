@@ -1,16 +1,32 @@
 package facts
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 
-	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
 
-var _ FactProvider = &RedisFactProvider{}
+var (
+	_ FactProvider    = &RedisFactProvider{}
+	_ BatchFactReader = &RedisFactProvider{}
+)
+
+// Keys used by flagops itself rather than the string layout e.g. environment overrides
+const redisReservedPrefix = "flagops:"
+
+// Returned by the string layout for identities whose keys would share the namespace
+// of the keys used by flagops itself
+var ErrReservedIdentity = errors.New("identity is reserved")
+
+// Prefix of the counter bumped by every write to the facts of an identity. The
+// facts are spread over many keys so conditional updates watch the counter instead.
+// Identities are checked with checkRedisIdentity so no fact key can overwrite it.
+const redisVersionPrefix = "flagops:version:"
 
 type RedisFactProvider struct {
 	client redis.UniversalClient
@@ -26,27 +42,51 @@ func (r *RedisFactProvider) getIdentityFactPath(id string, key string) string {
 	return fmt.Sprintf("%s:%s", id, key)
 }
 
-func (r *RedisFactProvider) getLogEntry(ctx *gin.Context) *logrus.Entry {
-	entry := logrus.WithFields(logrus.Fields{
-		"caller_path": ctx.FullPath(),
-		"provider":    "redis",
-		"api":         "facts",
+// Returns the scan pattern matching every fact of the identity. Glob characters in
+// the identity are escaped so the pattern cannot match the keys of other identities.
+func getIdentityFactPattern(id string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(id)
+	return fmt.Sprintf("%s:*", escaped)
+}
+
+// Checks the identity is usable by the string layout. The keys of an identity named
+// flagops, or starting with flagops:, would match the reserved keys.
+func checkRedisIdentity(id string) error {
+	if id == "" {
+		return errors.New("id is blank")
+	}
+
+	if id+":" == redisReservedPrefix || strings.HasPrefix(id, redisReservedPrefix) {
+		return fmt.Errorf("%w: %q", ErrReservedIdentity, id)
+	}
+
+	return nil
+}
+
+func getIdentityVersionKey(id string) string {
+	return fmt.Sprintf("%s%s", redisVersionPrefix, id)
+}
+
+func (r *RedisFactProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "redis",
+		"api":      "facts",
 	})
 
-	if ctx.Param("id") != "" {
-		entry = entry.WithField("id", ctx.Param("id"))
+	if id != "" {
+		entry = entry.WithField("id", id)
 	}
 
-	if ctx.Param("key") != "" {
-		entry = entry.WithField("key", ctx.Param("key"))
+	if key != "" {
+		entry = entry.WithField("key", key)
 	}
 
 	return entry
 }
 
 // GetAllIdentities implements FactProvider.
-func (r *RedisFactProvider) GetAllIdentities(ctx *gin.Context) ([]string, error) {
-	log := r.getLogEntry(ctx)
+func (r *RedisFactProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	log := r.getLogEntry(ctx, "", "")
 	log.Debug("fetching all identities from provider")
 	prefixSet := make(map[string]struct{})
 	cursor := uint64(0)
@@ -60,6 +100,10 @@ func (r *RedisFactProvider) GetAllIdentities(ctx *gin.Context) ([]string, error)
 		log.WithField("identities", len(keys)).Debug("fetched page of identities")
 
 		for _, key := range keys {
+			if strings.HasPrefix(key, redisReservedPrefix) { // Versions and environment overrides
+				continue
+			}
+
 			parts := strings.SplitN(key, ":", 2)
 			if len(parts) > 1 {
 				prefixSet[parts[0]] = struct{}{}
@@ -72,6 +116,16 @@ func (r *RedisFactProvider) GetAllIdentities(ctx *gin.Context) ([]string, error)
 		}
 	}
 
+	// Identities may only have environment overrides
+	overridden, err := r.client.SMembers(ctx, redisEnvironmentIdentitiesKey).Result()
+	if err != nil {
+		log.WithError(err).Error("could not fetch identities with overrides from provider")
+		return nil, err
+	}
+	for _, id := range overridden {
+		prefixSet[id] = struct{}{}
+	}
+
 	prefixes := make([]string, 0, len(prefixSet))
 	for prefix := range prefixSet {
 		prefixes = append(prefixes, prefix)
@@ -81,18 +135,18 @@ func (r *RedisFactProvider) GetAllIdentities(ctx *gin.Context) ([]string, error)
 }
 
 // GetIdentityFacts implements FactProvider.
-func (r *RedisFactProvider) GetIdentityFacts(ctx *gin.Context, id string) (Facts, error) {
-	log := r.getLogEntry(ctx)
-	if id == "" {
-		log.Debug("called with no identity")
-		return nil, errors.New("id is blank")
+func (r *RedisFactProvider) GetIdentityFacts(ctx context.Context, id string) (Facts, error) {
+	log := r.getLogEntry(ctx, id, "")
+	if err := checkRedisIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return nil, err
 	}
 
-	result := map[string]string{}
+	result := Facts{}
 	cursor := uint64(0)
 
 	for {
-		keys, nextCursor, err := r.client.Scan(ctx, cursor, fmt.Sprintf("%s:*", id), 100).Result()
+		keys, nextCursor, err := r.client.Scan(ctx, cursor, getIdentityFactPattern(id), 100).Result()
 		if err != nil {
 			log.WithError(err).Error("could not fetch scan page from provider")
 			return nil, err
@@ -106,7 +160,7 @@ func (r *RedisFactProvider) GetIdentityFacts(ctx *gin.Context, id string) (Facts
 				return nil, err
 			}
 			parts := strings.SplitN(key, ":", 2)
-			result[parts[1]] = val
+			result[parts[1]] = DecodeFact(val)
 		}
 
 		// Move the cursor to the next batch
@@ -125,13 +179,84 @@ func (r *RedisFactProvider) GetIdentityFacts(ctx *gin.Context, id string) (Facts
 	return result, nil
 }
 
+// GetIdentitiesFacts implements BatchFactReader. The keyspace is scanned once for
+// all identities and the values are fetched in a single pipeline.
+func (r *RedisFactProvider) GetIdentitiesFacts(ctx context.Context, ids []string) (map[string]Facts, error) {
+	log := r.getLogEntry(ctx, "", "").WithField("identities", len(ids))
+	log.Debug("fetching facts of identities from provider")
+
+	wanted := map[string]struct{}{}
+	for _, id := range ids {
+		if checkRedisIdentity(id) == nil {
+			wanted[id] = struct{}{}
+		}
+	}
+
+	keys := []string{}
+	cursor := uint64(0)
+	for len(wanted) > 0 {
+		page, nextCursor, err := r.client.Scan(ctx, cursor, "*", 100).Result()
+		if err != nil {
+			log.WithError(err).Error("could not fetch scan page from provider")
+			return nil, err
+		}
+
+		for _, key := range page {
+			if strings.HasPrefix(key, redisReservedPrefix) { // Environment overrides
+				continue
+			}
+
+			parts := strings.SplitN(key, ":", 2)
+			if _, ok := wanted[parts[0]]; ok && len(parts) > 1 {
+				keys = append(keys, key)
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	cmds := map[string]*redis.StringCmd{}
+	if len(keys) > 0 {
+		// Errors are checked per command below
+		r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				cmds[key] = pipe.Get(ctx, key)
+			}
+			return nil
+		})
+	}
+
+	result := map[string]Facts{}
+	for key, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			// Key was removed since it was scanned
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			log.WithError(err).Error("could not fetch key from provider")
+			return nil, err
+		}
+
+		parts := strings.SplitN(key, ":", 2)
+		if _, ok := result[parts[0]]; !ok {
+			result[parts[0]] = Facts{}
+		}
+		result[parts[0]][parts[1]] = DecodeFact(cmd.Val())
+	}
+
+	return result, nil
+}
+
 // SetIdentityFact implements FactProvider.
-func (r *RedisFactProvider) SetIdentityFact(ctx *gin.Context, id string, key string, value string) error {
-	log := r.getLogEntry(ctx)
+func (r *RedisFactProvider) SetIdentityFact(ctx context.Context, id string, key string, value Fact) error {
+	log := r.getLogEntry(ctx, id, key)
 	log.Debug("setting fact for identity")
-	if id == "" {
-		log.Debug("called with no identity")
-		return errors.New("id is blank")
+	if err := checkRedisIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
 	}
 
 	if key == "" {
@@ -139,13 +264,17 @@ func (r *RedisFactProvider) SetIdentityFact(ctx *gin.Context, id string, key str
 		return errors.New("key is blank")
 	}
 
-	if value == "" {
+	if value.Value == "" {
 		log.Debug("called with no value")
 		return errors.New("value is blank")
 	}
 
 	log.Debug("setting identity fact")
-	err := r.client.Set(ctx, r.getIdentityFactPath(id, key), value, 0).Err()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.getIdentityFactPath(id, key), EncodeFact(value), 0)
+		pipe.Incr(ctx, getIdentityVersionKey(id))
+		return nil
+	})
 	if err != nil {
 		log.WithError(err).Error("could not set key in provider")
 		return err
@@ -155,11 +284,11 @@ func (r *RedisFactProvider) SetIdentityFact(ctx *gin.Context, id string, key str
 }
 
 // DeleteIdentityFact implements FactProvider.
-func (r *RedisFactProvider) DeleteIdentityFact(ctx *gin.Context, id string, key string) error {
-	log := r.getLogEntry(ctx)
-	if id == "" {
-		log.Debug("called with no identity")
-		return errors.New("id is blank")
+func (r *RedisFactProvider) DeleteIdentityFact(ctx context.Context, id string, key string) error {
+	log := r.getLogEntry(ctx, id, key)
+	if err := checkRedisIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
 	}
 
 	if key == "" {
@@ -168,7 +297,11 @@ func (r *RedisFactProvider) DeleteIdentityFact(ctx *gin.Context, id string, key
 	}
 
 	log.Debug("deleting identity fact")
-	err := r.client.Del(ctx, r.getIdentityFactPath(id, key)).Err()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.getIdentityFactPath(id, key))
+		pipe.Incr(ctx, getIdentityVersionKey(id))
+		return nil
+	})
 	if err != nil {
 		log.WithError(err).Error("could not delete key in provider")
 		return err
@@ -177,17 +310,148 @@ func (r *RedisFactProvider) DeleteIdentityFact(ctx *gin.Context, id string, key
 	return nil
 }
 
+// UpdateIdentityFacts implements FactProvider.
+func (r *RedisFactProvider) UpdateIdentityFacts(ctx context.Context, id string, set Facts, remove []string) error {
+	log := r.getLogEntry(ctx, id, "")
+	if err := checkUpdate(id, set, remove); err != nil {
+		log.WithError(err).Debug("called with invalid update")
+		return err
+	}
+
+	if err := checkRedisIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"set": len(set), "remove": len(remove)}).Debug("updating identity facts")
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.queueUpdate(ctx, pipe, id, set, remove)
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("could not update identity facts in provider")
+		return err
+	}
+
+	return nil
+}
+
+// UpdateIdentityFactsIfMatch implements FactProvider.
+func (r *RedisFactProvider) UpdateIdentityFactsIfMatch(ctx context.Context, id string, version string, set Facts, remove []string) error {
+	log := r.getLogEntry(ctx, id, "")
+	if err := checkUpdate(id, set, remove); err != nil {
+		log.WithError(err).Debug("called with invalid update")
+		return err
+	}
+
+	if err := checkRedisIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"set": len(set), "remove": len(remove)}).Debug("updating identity facts if version matches")
+	versionKey := getIdentityVersionKey(id)
+
+	// Every write bumps the version key so watching it covers facts added while checking
+	updateFacts := func(tx *redis.Tx) error {
+		current, err := r.GetIdentityFacts(ctx, id)
+		if err != nil && !errors.Is(err, ErrIdentityNotFound) {
+			return err
+		}
+
+		if err := checkVersion(current, version); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueUpdate(ctx, pipe, id, set, remove)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(ctx, updateFacts, versionKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			log.Debug("identity changed while updating facts retrying")
+			continue
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			log.Debug("identity facts version does not match")
+			return err
+		}
+		if err != nil {
+			log.WithError(err).Error("could not update identity facts in provider")
+			return err
+		}
+
+		return nil
+	}
+
+	log.Error("could not update identity facts in provider after max retries")
+	return redis.TxFailedErr
+}
+
+// Queues the commands applying an update to the facts of the identity
+func (r *RedisFactProvider) queueUpdate(ctx context.Context, pipe redis.Pipeliner, id string, set Facts, remove []string) {
+	for key, value := range set {
+		pipe.Set(ctx, r.getIdentityFactPath(id, key), EncodeFact(value), 0)
+	}
+
+	for _, key := range remove {
+		pipe.Del(ctx, r.getIdentityFactPath(id, key))
+	}
+
+	pipe.Incr(ctx, getIdentityVersionKey(id))
+}
+
+// GetIdentityEnvironments implements FactProvider.
+func (r *RedisFactProvider) GetIdentityEnvironments(ctx context.Context, id string) ([]string, error) {
+	if err := checkRedisIdentity(id); err != nil {
+		return nil, err
+	}
+
+	return getRedisEnvironments(ctx, r.client, r.getLogEntry(ctx, id, ""), id)
+}
+
+// GetIdentityEnvironmentFacts implements FactProvider.
+func (r *RedisFactProvider) GetIdentityEnvironmentFacts(ctx context.Context, id string, env string) (Facts, error) {
+	if err := checkRedisIdentity(id); err != nil {
+		return nil, err
+	}
+
+	return getRedisEnvironmentFacts(ctx, r.client, r.getLogEntry(ctx, id, "").WithField("env", env), id, env)
+}
+
+// SetIdentityEnvironmentFact implements FactProvider.
+func (r *RedisFactProvider) SetIdentityEnvironmentFact(ctx context.Context, id string, env string, key string, value Fact) error {
+	if err := checkRedisIdentity(id); err != nil {
+		return err
+	}
+
+	return setRedisEnvironmentFact(ctx, r.client, r.getLogEntry(ctx, id, key).WithField("env", env), id, env, key, value)
+}
+
+// DeleteIdentityEnvironmentFact implements FactProvider.
+func (r *RedisFactProvider) DeleteIdentityEnvironmentFact(ctx context.Context, id string, env string, key string) error {
+	if err := checkRedisIdentity(id); err != nil {
+		return err
+	}
+
+	return deleteRedisEnvironmentFact(ctx, r.client, r.getLogEntry(ctx, id, key).WithField("env", env), id, env, key)
+}
+
 // DeleteIdentity implements FactProvider.
-func (r *RedisFactProvider) DeleteIdentity(ctx *gin.Context, id string) error {
-	log := r.getLogEntry(ctx)
-	if id == "" {
-		log.Debug("called with no identity")
-		return errors.New("id is blank")
+func (r *RedisFactProvider) DeleteIdentity(ctx context.Context, id string) error {
+	log := r.getLogEntry(ctx, id, "")
+	if err := checkRedisIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
 	}
 
 	cursor := uint64(0)
 	for {
-		keys, nextCursor, err := r.client.Scan(ctx, cursor, fmt.Sprintf("%s:*", id), 100).Result()
+		keys, nextCursor, err := r.client.Scan(ctx, cursor, getIdentityFactPattern(id), 100).Result()
 		if err != nil {
 			log.WithError(err).Error("could not fetch scan page from provider")
 			return err
@@ -211,5 +475,12 @@ func (r *RedisFactProvider) DeleteIdentity(ctx *gin.Context, id string) error {
 		}
 	}
 
-	return nil
+	// Deleting the version key still interrupts conditional updates watching it
+	err := r.client.Del(ctx, getIdentityVersionKey(id)).Err()
+	if err != nil {
+		log.WithError(err).Error("could not delete identity version in provider")
+		return err
+	}
+
+	return deleteRedisEnvironments(ctx, r.client, log, id)
 }
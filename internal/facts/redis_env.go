@@ -0,0 +1,191 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// Environment overrides are stored the same way by both redis layouts so they
+// are kept when migrating between layouts.
+const (
+	// Set holding the environments an identity has overrides in
+	redisEnvironmentsPrefix = "flagops:environments:"
+
+	// Prefix of the hash holding the overrides of a single identity in one environment
+	redisEnvironmentHashPrefix = "flagops:environment:"
+
+	// Set holding every identity with overrides in at least one environment
+	redisEnvironmentIdentitiesKey = "flagops:overridden"
+)
+
+func getEnvironmentsKey(id string) string {
+	return fmt.Sprintf("%s%s", redisEnvironmentsPrefix, id)
+}
+
+func getEnvironmentHashKey(id string, env string) string {
+	return fmt.Sprintf("%s%s:%s", redisEnvironmentHashPrefix, env, id)
+}
+
+func getRedisEnvironments(ctx context.Context, client redis.UniversalClient, log *logrus.Entry, id string) ([]string, error) {
+	if id == "" {
+		log.Debug("called with no identity")
+		return nil, errors.New("id is blank")
+	}
+
+	envs, err := client.SMembers(ctx, getEnvironmentsKey(id)).Result()
+	if err != nil {
+		log.WithError(err).Error("could not fetch environments from provider")
+		return nil, err
+	}
+
+	slices.Sort(envs)
+	return envs, nil
+}
+
+func getRedisEnvironmentFacts(ctx context.Context, client redis.UniversalClient, log *logrus.Entry, id string, env string) (Facts, error) {
+	if id == "" {
+		log.Debug("called with no identity")
+		return nil, errors.New("id is blank")
+	}
+
+	if err := CheckEnvironment(env); err != nil {
+		return nil, err
+	}
+
+	values, err := client.HGetAll(ctx, getEnvironmentHashKey(id, env)).Result()
+	if err != nil {
+		log.WithError(err).Error("could not fetch environment facts from provider")
+		return nil, err
+	}
+
+	result := Facts{}
+	for key, value := range values {
+		result[key] = DecodeFact(value)
+	}
+
+	return result, nil
+}
+
+func setRedisEnvironmentFact(ctx context.Context, client redis.UniversalClient, log *logrus.Entry, id string, env string, key string, value Fact) error {
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if err := CheckEnvironment(env); err != nil {
+		return err
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	if value.Value == "" {
+		log.Debug("called with no value")
+		return errors.New("value is blank")
+	}
+
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, getEnvironmentHashKey(id, env), key, EncodeFact(value))
+		pipe.SAdd(ctx, getEnvironmentsKey(id), env)
+		pipe.SAdd(ctx, redisEnvironmentIdentitiesKey, id)
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("could not set environment fact in provider")
+		return err
+	}
+
+	return nil
+}
+
+func deleteRedisEnvironmentFact(ctx context.Context, client redis.UniversalClient, log *logrus.Entry, id string, env string, key string) error {
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if err := CheckEnvironment(env); err != nil {
+		return err
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	hashKey := getEnvironmentHashKey(id, env)
+	envsKey := getEnvironmentsKey(id)
+
+	// Watch the environment hash and set so the environment and identity are only
+	// removed from the sets if no other writer added an override while deleting the last one
+	deleteFact := func(tx *redis.Tx) error {
+		keys, err := tx.HKeys(ctx, hashKey).Result()
+		if err != nil {
+			return err
+		}
+
+		envs, err := tx.SMembers(ctx, envsKey).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, hashKey, key)
+			if len(keys) == 1 && keys[0] == key {
+				pipe.SRem(ctx, envsKey, env)
+				if len(envs) == 1 && envs[0] == env {
+					pipe.SRem(ctx, redisEnvironmentIdentitiesKey, id)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := client.Watch(ctx, deleteFact, hashKey, envsKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			log.Debug("environment changed while deleting fact retrying")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("could not delete environment fact in provider")
+			return err
+		}
+
+		return nil
+	}
+
+	log.Error("could not delete environment fact in provider after max retries")
+	return redis.TxFailedErr
+}
+
+// Removes every environment override of the identity
+func deleteRedisEnvironments(ctx context.Context, client redis.UniversalClient, log *logrus.Entry, id string) error {
+	envs, err := getRedisEnvironments(ctx, client, log, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, env := range envs {
+			pipe.Del(ctx, getEnvironmentHashKey(id, env))
+		}
+		pipe.Del(ctx, getEnvironmentsKey(id))
+		pipe.SRem(ctx, redisEnvironmentIdentitiesKey, id)
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("could not delete environment facts in provider")
+		return err
+	}
+
+	return nil
+}
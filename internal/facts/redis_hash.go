@@ -0,0 +1,504 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	_ FactProvider    = &RedisHashFactProvider{}
+	_ BatchFactReader = &RedisHashFactProvider{}
+)
+
+const (
+	// Set holding the id of every identity stored in the hash layout
+	redisIdentitiesKey = "flagops:identities"
+
+	// Prefix of the hash holding all facts of a single identity
+	redisIdentityHashPrefix = "flagops:facts:"
+
+	// Number of times an optimistic transaction is retried before giving up
+	redisTxRetries = 5
+)
+
+// A facts provider storing each identity as a single redis hash and tracking
+// every identity in an index set so listing and reads do not need to scan the keyspace
+type RedisHashFactProvider struct {
+	client redis.UniversalClient
+}
+
+func NewRedisHashFactProvider(client redis.UniversalClient) *RedisHashFactProvider {
+	return &RedisHashFactProvider{
+		client: client,
+	}
+}
+
+func getIdentityHashKey(id string) string {
+	return fmt.Sprintf("%s%s", redisIdentityHashPrefix, id)
+}
+
+func (r *RedisHashFactProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "redis",
+		"layout":   "hash",
+		"api":      "facts",
+	})
+
+	if id != "" {
+		entry = entry.WithField("id", id)
+	}
+
+	if key != "" {
+		entry = entry.WithField("key", key)
+	}
+
+	return entry
+}
+
+// GetAllIdentities implements FactProvider.
+func (r *RedisHashFactProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	log := r.getLogEntry(ctx, "", "")
+	log.Debug("fetching all identities from provider")
+
+	// Identities may only have environment overrides
+	ids, err := r.client.SUnion(ctx, redisIdentitiesKey, redisEnvironmentIdentitiesKey).Result()
+	if err != nil {
+		log.WithError(err).Error("could not fetch identities from provider")
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetIdentityFacts implements FactProvider.
+func (r *RedisHashFactProvider) GetIdentityFacts(ctx context.Context, id string) (Facts, error) {
+	log := r.getLogEntry(ctx, id, "")
+	if id == "" {
+		log.Debug("called with no identity")
+		return nil, errors.New("id is blank")
+	}
+
+	values, err := r.client.HGetAll(ctx, getIdentityHashKey(id)).Result()
+	if err != nil {
+		log.WithError(err).Error("could not fetch facts from provider")
+		return nil, err
+	}
+	log.WithField("keys", len(values)).Debug("fetched identity facts")
+
+	if len(values) == 0 {
+		return nil, ErrIdentityNotFound
+	}
+
+	result := Facts{}
+	for k, v := range values {
+		result[k] = DecodeFact(v)
+	}
+
+	return result, nil
+}
+
+// GetIdentitiesFacts implements BatchFactReader.
+func (r *RedisHashFactProvider) GetIdentitiesFacts(ctx context.Context, ids []string) (map[string]Facts, error) {
+	log := r.getLogEntry(ctx, "", "").WithField("identities", len(ids))
+	log.Debug("fetching facts of identities from provider")
+
+	cmds := map[string]*redis.MapStringStringCmd{}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			if id == "" {
+				continue
+			}
+			cmds[id] = pipe.HGetAll(ctx, getIdentityHashKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("could not fetch facts from provider")
+		return nil, err
+	}
+
+	result := map[string]Facts{}
+	for id, cmd := range cmds {
+		values := cmd.Val()
+		if len(values) == 0 {
+			continue
+		}
+
+		identityFacts := Facts{}
+		for k, v := range values {
+			identityFacts[k] = DecodeFact(v)
+		}
+		result[id] = identityFacts
+	}
+
+	return result, nil
+}
+
+// SetIdentityFact implements FactProvider.
+func (r *RedisHashFactProvider) SetIdentityFact(ctx context.Context, id string, key string, value Fact) error {
+	log := r.getLogEntry(ctx, id, key)
+	log.Debug("setting fact for identity")
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	if value.Value == "" {
+		log.Debug("called with no value")
+		return errors.New("value is blank")
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, getIdentityHashKey(id), key, EncodeFact(value))
+		pipe.SAdd(ctx, redisIdentitiesKey, id)
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("could not set key in provider")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIdentityFact implements FactProvider.
+func (r *RedisHashFactProvider) DeleteIdentityFact(ctx context.Context, id string, key string) error {
+	log := r.getLogEntry(ctx, id, key)
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	log.Debug("deleting identity fact")
+	hashKey := getIdentityHashKey(id)
+
+	// Watch the identity hash so the index set is only updated if no other
+	// writer touched the identity between checking and deleting its last fact
+	deleteFact := func(tx *redis.Tx) error {
+		keys, err := tx.HKeys(ctx, hashKey).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, hashKey, key)
+			if len(keys) == 1 && keys[0] == key {
+				pipe.SRem(ctx, redisIdentitiesKey, id)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(ctx, deleteFact, hashKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			log.Debug("identity changed while deleting fact retrying")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("could not delete key in provider")
+			return err
+		}
+
+		return nil
+	}
+
+	log.Error("could not delete key in provider after max retries")
+	return redis.TxFailedErr
+}
+
+// UpdateIdentityFacts implements FactProvider.
+func (r *RedisHashFactProvider) UpdateIdentityFacts(ctx context.Context, id string, set Facts, remove []string) error {
+	log := r.getLogEntry(ctx, id, "")
+	if err := checkUpdate(id, set, remove); err != nil {
+		log.WithError(err).Debug("called with invalid update")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"set": len(set), "remove": len(remove)}).Debug("updating identity facts")
+	hashKey := getIdentityHashKey(id)
+
+	// Watch the identity hash so the index set matches whether any facts are left
+	updateFacts := func(tx *redis.Tx) error {
+		keys, err := tx.HKeys(ctx, hashKey).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueUpdate(ctx, pipe, id, keys, set, remove)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(ctx, updateFacts, hashKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			log.Debug("identity changed while updating facts retrying")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("could not update identity facts in provider")
+			return err
+		}
+
+		return nil
+	}
+
+	log.Error("could not update identity facts in provider after max retries")
+	return redis.TxFailedErr
+}
+
+// UpdateIdentityFactsIfMatch implements FactProvider.
+func (r *RedisHashFactProvider) UpdateIdentityFactsIfMatch(ctx context.Context, id string, version string, set Facts, remove []string) error {
+	log := r.getLogEntry(ctx, id, "")
+	if err := checkUpdate(id, set, remove); err != nil {
+		log.WithError(err).Debug("called with invalid update")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"set": len(set), "remove": len(remove)}).Debug("updating identity facts if version matches")
+	hashKey := getIdentityHashKey(id)
+
+	// All facts of the identity live in its hash so watching it covers every write
+	updateFacts := func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(ctx, hashKey).Result()
+		if err != nil {
+			return err
+		}
+
+		current := Facts{}
+		for key, value := range values {
+			current[key] = DecodeFact(value)
+		}
+
+		if err := checkVersion(current, version); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.queueUpdate(ctx, pipe, id, slices.Collect(maps.Keys(values)), set, remove)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := r.client.Watch(ctx, updateFacts, hashKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			log.Debug("identity changed while updating facts retrying")
+			continue
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			log.Debug("identity facts version does not match")
+			return err
+		}
+		if err != nil {
+			log.WithError(err).Error("could not update identity facts in provider")
+			return err
+		}
+
+		return nil
+	}
+
+	log.Error("could not update identity facts in provider after max retries")
+	return redis.TxFailedErr
+}
+
+// Queues the commands applying an update to the identity with the fact keys it
+// currently has so the index set matches whether any facts are left
+func (r *RedisHashFactProvider) queueUpdate(ctx context.Context, pipe redis.Pipeliner, id string, keys []string, set Facts, remove []string) {
+	hashKey := getIdentityHashKey(id)
+
+	remaining := len(set)
+	for _, key := range keys {
+		if _, ok := set[key]; !ok && !slices.Contains(remove, key) {
+			remaining++
+		}
+	}
+
+	if len(set) > 0 {
+		values := map[string]any{}
+		for key, value := range set {
+			values[key] = EncodeFact(value)
+		}
+		pipe.HSet(ctx, hashKey, values)
+	}
+
+	if len(remove) > 0 {
+		pipe.HDel(ctx, hashKey, remove...)
+	}
+
+	if remaining > 0 {
+		pipe.SAdd(ctx, redisIdentitiesKey, id)
+	} else {
+		pipe.SRem(ctx, redisIdentitiesKey, id)
+	}
+}
+
+// GetIdentityEnvironments implements FactProvider.
+func (r *RedisHashFactProvider) GetIdentityEnvironments(ctx context.Context, id string) ([]string, error) {
+	return getRedisEnvironments(ctx, r.client, r.getLogEntry(ctx, id, ""), id)
+}
+
+// GetIdentityEnvironmentFacts implements FactProvider.
+func (r *RedisHashFactProvider) GetIdentityEnvironmentFacts(ctx context.Context, id string, env string) (Facts, error) {
+	return getRedisEnvironmentFacts(ctx, r.client, r.getLogEntry(ctx, id, "").WithField("env", env), id, env)
+}
+
+// SetIdentityEnvironmentFact implements FactProvider.
+func (r *RedisHashFactProvider) SetIdentityEnvironmentFact(ctx context.Context, id string, env string, key string, value Fact) error {
+	return setRedisEnvironmentFact(ctx, r.client, r.getLogEntry(ctx, id, key).WithField("env", env), id, env, key, value)
+}
+
+// DeleteIdentityEnvironmentFact implements FactProvider.
+func (r *RedisHashFactProvider) DeleteIdentityEnvironmentFact(ctx context.Context, id string, env string, key string) error {
+	return deleteRedisEnvironmentFact(ctx, r.client, r.getLogEntry(ctx, id, key).WithField("env", env), id, env, key)
+}
+
+// DeleteIdentity implements FactProvider.
+func (r *RedisHashFactProvider) DeleteIdentity(ctx context.Context, id string) error {
+	log := r.getLogEntry(ctx, id, "")
+	if id == "" {
+		log.Debug("called with no identity")
+		return errors.New("id is blank")
+	}
+
+	log.Debug("deleting identity")
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, getIdentityHashKey(id))
+		pipe.SRem(ctx, redisIdentitiesKey, id)
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("could not delete identity in provider")
+		return err
+	}
+
+	return deleteRedisEnvironments(ctx, r.client, log, id)
+}
+
+// Copies every fact stored in the `id:key` string layout into the hash layout.
+// When removeOld is set the string keys are deleted in the same transaction
+// they are copied in. Returns the number of facts migrated.
+func MigrateRedisStringLayoutToHash(ctx context.Context, client redis.UniversalClient, removeOld bool) (int, error) {
+	log := logrus.WithFields(logrus.Fields{
+		"provider": "redis",
+		"api":      "facts",
+	})
+
+	migrated := 0
+	cursor := uint64(0)
+	for {
+		keys, nextCursor, err := client.Scan(ctx, cursor, "*", 100).Result()
+		if err != nil {
+			log.WithError(err).Error("could not fetch scan page from provider")
+			return migrated, err
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, "flagops:") { // Already part of the hash layout
+				continue
+			}
+
+			parts := strings.SplitN(key, ":", 2)
+			if len(parts) < 2 {
+				continue
+			}
+
+			ok, err := migrateRedisStringKey(ctx, client, log, key, parts[0], parts[1], removeOld)
+			if err != nil {
+				return migrated, err
+			}
+			if !ok {
+				continue
+			}
+
+			migrated++
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	log.WithField("facts", migrated).Info("migrated facts to hash layout")
+	return migrated, nil
+}
+
+// Copies a single fact of the string layout into the hash layout. The copy is only
+// applied while the string key is unchanged so a concurrent write is never lost
+// or overwritten by the value read before it. Returns false when the key was skipped.
+func migrateRedisStringKey(ctx context.Context, client redis.UniversalClient, log *logrus.Entry, key string, id string, field string, removeOld bool) (bool, error) {
+	log = log.WithField("key", key)
+	migrated := false
+
+	migrateKey := func(tx *redis.Tx) error {
+		migrated = false
+
+		val, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) { // Key was removed since the scan page was fetched
+				return nil
+			}
+			if strings.HasPrefix(err.Error(), "WRONGTYPE") { // Not a fact written by the string layout
+				log.Warn("skipping key that is not a string")
+				return nil
+			}
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, getIdentityHashKey(id), field, val)
+			pipe.SAdd(ctx, redisIdentitiesKey, id)
+			if removeOld {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		migrated = true
+		return nil
+	}
+
+	for i := 0; i < redisTxRetries; i++ {
+		err := client.Watch(ctx, migrateKey, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			log.Debug("key changed while migrating retrying")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("could not migrate key")
+			return false, err
+		}
+
+		return migrated, nil
+	}
+
+	log.Error("could not migrate key after max retries")
+	return false, redis.TxFailedErr
+}
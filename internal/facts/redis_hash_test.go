@@ -0,0 +1,377 @@
+package facts_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisHashGetAllIdentities(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	client.SAdd(ctx, "flagops:identities", "customer0", "customer1", "customer2")
+
+	provider := facts.NewRedisHashFactProvider(client)
+
+	ids, err := provider.GetAllIdentities(context.Background())
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"customer0", "customer1", "customer2"}, ids)
+	}
+}
+
+func TestRedisHashSetIdentityFact(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		key           string
+		value         facts.Fact
+		expectError   bool
+		expectedFacts map[string]string
+	}{
+		{
+			name:          "core function",
+			id:            "customer0",
+			key:           "fact0",
+			value:         facts.NewStringFact("foo"),
+			expectError:   false,
+			expectedFacts: map[string]string{"fact0": "foo"},
+		},
+		{
+			name:          "typed value",
+			id:            "customer0",
+			key:           "fact0",
+			value:         facts.Fact{Type: facts.FactTypeBool, Value: "true"},
+			expectError:   false,
+			expectedFacts: map[string]string{"fact0": "\x00flagops:bool:true"},
+		},
+		{
+			name:        "missing id",
+			id:          "",
+			key:         "fact0",
+			value:       facts.NewStringFact("foo"),
+			expectError: true,
+		},
+		{
+			name:        "missing key",
+			id:          "customer0",
+			key:         "",
+			value:       facts.NewStringFact("foo"),
+			expectError: true,
+		},
+		{
+			name:        "missing value",
+			id:          "customer0",
+			key:         "fact0",
+			value:       facts.NewStringFact(""),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, client, err := getRedisContainer(ctx)
+			if err != nil {
+				t.Fatalf("Could not start redis: %s", err)
+			}
+			defer closeRedisContainer(t, ctx, container)
+
+			provider := facts.NewRedisHashFactProvider(client)
+
+			err = provider.SetIdentityFact(context.Background(), tt.id, tt.key, tt.value)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.expectedFacts, client.HGetAll(ctx, "flagops:facts:"+tt.id).Val())
+					assert.True(t, client.SIsMember(ctx, "flagops:identities", tt.id).Val())
+				}
+			}
+		})
+	}
+}
+
+func TestRedisHashGetIdentityFacts(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		setupDB       func(client redis.UniversalClient)
+		expectError   bool
+		expectedFacts facts.Facts
+	}{
+		{
+			name:        "core functionality",
+			id:          "customer0",
+			expectError: false,
+			expectedFacts: facts.Facts{
+				"fact0": facts.NewStringFact("foo"),
+				"fact1": facts.NewStringFact("bar"),
+			},
+			setupDB: func(client redis.UniversalClient) {
+				client.HSet(context.Background(), "flagops:facts:customer0", "fact0", "foo", "fact1", "bar")
+			},
+		},
+		{
+			name:        "identity does not exist",
+			id:          "customer0",
+			expectError: true,
+			setupDB:     func(client redis.UniversalClient) {},
+		},
+		{
+			name:        "missing id",
+			id:          "",
+			expectError: true,
+			setupDB:     func(client redis.UniversalClient) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, client, err := getRedisContainer(ctx)
+			if err != nil {
+				t.Fatalf("Could not start redis: %s", err)
+			}
+			defer closeRedisContainer(t, ctx, container)
+
+			provider := facts.NewRedisHashFactProvider(client)
+
+			tt.setupDB(client)
+
+			identityFacts, err := provider.GetIdentityFacts(context.Background(), tt.id)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.expectedFacts, identityFacts)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisHashDeleteIdentityFact(t *testing.T) {
+	var tests = []struct {
+		name             string
+		id               string
+		key              string
+		setupDB          func(client redis.UniversalClient)
+		expectError      bool
+		expectInIdentity bool
+	}{
+		{
+			name:             "core functionality",
+			id:               "customer0",
+			key:              "fact0",
+			expectError:      false,
+			expectInIdentity: true,
+			setupDB: func(client redis.UniversalClient) {
+				client.HSet(context.Background(), "flagops:facts:customer0", "fact0", "foo", "fact1", "bar")
+				client.SAdd(context.Background(), "flagops:identities", "customer0")
+			},
+		},
+		{
+			name:             "last fact removes identity from index",
+			id:               "customer0",
+			key:              "fact0",
+			expectError:      false,
+			expectInIdentity: false,
+			setupDB: func(client redis.UniversalClient) {
+				client.HSet(context.Background(), "flagops:facts:customer0", "fact0", "foo")
+				client.SAdd(context.Background(), "flagops:identities", "customer0")
+			},
+		},
+		{
+			name:        "key to delete does not exist",
+			id:          "customer0",
+			key:         "fact0",
+			expectError: false,
+			setupDB:     func(client redis.UniversalClient) {},
+		},
+		{
+			name:        "missing id",
+			id:          "",
+			key:         "fact0",
+			expectError: true,
+			setupDB:     func(client redis.UniversalClient) {},
+		},
+		{
+			name:        "missing key",
+			id:          "customer0",
+			key:         "",
+			expectError: true,
+			setupDB:     func(client redis.UniversalClient) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, client, err := getRedisContainer(ctx)
+			if err != nil {
+				t.Fatalf("Could not start redis: %s", err)
+			}
+			defer closeRedisContainer(t, ctx, container)
+
+			provider := facts.NewRedisHashFactProvider(client)
+
+			tt.setupDB(client)
+
+			err = provider.DeleteIdentityFact(context.Background(), tt.id, tt.key)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.False(t, client.HExists(ctx, "flagops:facts:"+tt.id, tt.key).Val())
+				assert.Equal(t, tt.expectInIdentity, client.SIsMember(ctx, "flagops:identities", tt.id).Val())
+			}
+		})
+	}
+}
+
+func TestRedisHashDeleteIdentity(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	client.HSet(ctx, "flagops:facts:customer0", "fact0", "foo")
+	client.SAdd(ctx, "flagops:identities", "customer0")
+
+	provider := facts.NewRedisHashFactProvider(client)
+
+	err = provider.DeleteIdentity(context.Background(), "customer0")
+	if assert.NoError(t, err) {
+		assert.Zero(t, client.Exists(ctx, "flagops:facts:customer0").Val())
+		assert.False(t, client.SIsMember(ctx, "flagops:identities", "customer0").Val())
+	}
+
+	err = provider.DeleteIdentity(context.Background(), "")
+	assert.Error(t, err)
+}
+
+func TestMigrateRedisStringLayoutToHash(t *testing.T) {
+	var tests = []struct {
+		name      string
+		removeOld bool
+	}{
+		{
+			name:      "keep old keys",
+			removeOld: false,
+		},
+		{
+			name:      "remove old keys",
+			removeOld: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			container, client, err := getRedisContainer(ctx)
+			if err != nil {
+				t.Fatalf("Could not start redis: %s", err)
+			}
+			defer closeRedisContainer(t, ctx, container)
+
+			client.Set(ctx, "customer0:fact0", "foo", 0)
+			client.Set(ctx, "customer0:fact1", "bar", 0)
+			client.Set(ctx, "customer1:fact0", "baz", 0)
+			client.LPush(ctx, "customer2:list", "ignored")
+
+			migrated, err := facts.MigrateRedisStringLayoutToHash(ctx, client, tt.removeOld)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, 3, migrated)
+
+			provider := facts.NewRedisHashFactProvider(client)
+
+			ids, err := provider.GetAllIdentities(context.Background())
+			if assert.NoError(t, err) {
+				assert.ElementsMatch(t, []string{"customer0", "customer1"}, ids)
+			}
+
+			identityFacts, err := provider.GetIdentityFacts(context.Background(), "customer0")
+			if assert.NoError(t, err) {
+				assert.Equal(t, facts.Facts{"fact0": facts.NewStringFact("foo"), "fact1": facts.NewStringFact("bar")}, identityFacts)
+			}
+
+			if tt.removeOld {
+				assert.Zero(t, client.Exists(ctx, "customer0:fact0", "customer0:fact1", "customer1:fact0").Val())
+			} else {
+				assert.Equal(t, int64(3), client.Exists(ctx, "customer0:fact0", "customer0:fact1", "customer1:fact0").Val())
+			}
+		})
+	}
+}
+
+func TestRedisHashUpdateIdentityFacts(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	provider := facts.NewRedisHashFactProvider(client)
+
+	err = provider.UpdateIdentityFacts(ctx, "customer0", facts.Facts{
+		"fact0": facts.NewStringFact("foo"),
+		"fact1": {Type: facts.FactTypeBool, Value: "true"},
+	}, nil)
+	if assert.NoError(t, err) {
+		assert.True(t, client.SIsMember(ctx, "flagops:identities", "customer0").Val())
+
+		identityFacts, err := provider.GetIdentityFacts(ctx, "customer0")
+		if assert.NoError(t, err) {
+			assert.Equal(t, facts.Facts{
+				"fact0": facts.NewStringFact("foo"),
+				"fact1": {Type: facts.FactTypeBool, Value: "true"},
+			}, identityFacts)
+		}
+	}
+
+	// Removing every fact removes the identity from the index
+	err = provider.UpdateIdentityFacts(ctx, "customer0", nil, []string{"fact0", "fact1"})
+	if assert.NoError(t, err) {
+		assert.Zero(t, client.Exists(ctx, "flagops:facts:customer0").Val())
+		assert.False(t, client.SIsMember(ctx, "flagops:identities", "customer0").Val())
+	}
+
+	err = provider.UpdateIdentityFacts(ctx, "customer0", facts.Facts{"fact0": facts.NewStringFact("foo")}, []string{"fact0"})
+	assert.Error(t, err)
+}
+
+func TestRedisHashGetIdentitiesFacts(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	provider := facts.NewRedisHashFactProvider(client)
+	provider.SetIdentityFact(ctx, "customer0", "fact0", facts.NewStringFact("foo"))
+	provider.SetIdentityFact(ctx, "customer0", "fact1", facts.Fact{Type: facts.FactTypeNumber, Value: "3"})
+	provider.SetIdentityFact(ctx, "customer1", "fact0", facts.NewStringFact("bar"))
+
+	identities, err := provider.GetIdentitiesFacts(ctx, []string{"customer0", "customer1", "missing"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]facts.Facts{
+			"customer0": {"fact0": facts.NewStringFact("foo"), "fact1": {Type: facts.FactTypeNumber, Value: "3"}},
+			"customer1": {"fact0": facts.NewStringFact("bar")},
+		}, identities)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/facts"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -62,7 +61,7 @@ func TestGetAllIdentities(t *testing.T) {
 
 	provider := facts.NewRedisFactProvider(client)
 
-	ids, err := provider.GetAllIdentities(&gin.Context{})
+	ids, err := provider.GetAllIdentities(context.Background())
 	if assert.NoError(t, err) {
 		assert.ElementsMatch(t, []string{"customer0", "customer1", "customer2"}, ids)
 	}
@@ -73,7 +72,7 @@ func TestSetIdentityFact(t *testing.T) {
 		name          string
 		id            string
 		key           string
-		value         string
+		value         facts.Fact
 		expectError   bool
 		expectedKey   string
 		expectedValue string
@@ -82,7 +81,7 @@ func TestSetIdentityFact(t *testing.T) {
 			name:          "core function",
 			id:            "customer0",
 			key:           "fact0",
-			value:         "foo",
+			value:         facts.NewStringFact("foo"),
 			expectError:   false,
 			expectedKey:   "customer0:fact0",
 			expectedValue: "foo",
@@ -91,21 +90,21 @@ func TestSetIdentityFact(t *testing.T) {
 			name:        "missing id",
 			id:          "",
 			key:         "fact0",
-			value:       "foo",
+			value:       facts.NewStringFact("foo"),
 			expectError: true,
 		},
 		{
 			name:        "missing key",
 			id:          "customer0",
 			key:         "",
-			value:       "foo",
+			value:       facts.NewStringFact("foo"),
 			expectError: true,
 		},
 		{
 			name:        "missing value",
 			id:          "customer0",
 			key:         "fact0",
-			value:       "",
+			value:       facts.NewStringFact(""),
 			expectError: true,
 		},
 	}
@@ -121,7 +120,7 @@ func TestSetIdentityFact(t *testing.T) {
 
 			provider := facts.NewRedisFactProvider(client)
 
-			err = provider.SetIdentityFact(&gin.Context{}, tt.id, tt.key, tt.value)
+			err = provider.SetIdentityFact(context.Background(), tt.id, tt.key, tt.value)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -149,8 +148,8 @@ func TestGetIdentityFacts(t *testing.T) {
 			id:          "customer0",
 			expectError: false,
 			expectedFacts: facts.Facts{
-				"fact0": "foo",
-				"fact1": "bar",
+				"fact0": facts.NewStringFact("foo"),
+				"fact1": facts.NewStringFact("bar"),
 			},
 			setupDB: func(client redis.UniversalClient) {
 				client.Set(context.Background(), "customer0:fact0", "foo", 0)
@@ -178,7 +177,7 @@ func TestGetIdentityFacts(t *testing.T) {
 
 			tt.setupDB(client)
 
-			facts, err := provider.GetIdentityFacts(&gin.Context{}, tt.id)
+			facts, err := provider.GetIdentityFacts(context.Background(), tt.id)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -244,7 +243,7 @@ func TestDeleteIdentityFact(t *testing.T) {
 
 			tt.setupDB(client)
 
-			err = provider.DeleteIdentityFact(&gin.Context{}, tt.id, tt.key)
+			err = provider.DeleteIdentityFact(context.Background(), tt.id, tt.key)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -299,7 +298,7 @@ func TestDeleteIdentity(t *testing.T) {
 
 			tt.setupDB(client)
 
-			err = provider.DeleteIdentity(&gin.Context{}, tt.id)
+			err = provider.DeleteIdentity(context.Background(), tt.id)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -310,3 +309,61 @@ func TestDeleteIdentity(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateIdentityFacts(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	client.Set(ctx, "customer0:fact0", "foo", 0)
+	client.Set(ctx, "customer0:fact1", "bar", 0)
+
+	provider := facts.NewRedisFactProvider(client)
+
+	err = provider.UpdateIdentityFacts(ctx, "customer0", facts.Facts{
+		"fact0": facts.NewStringFact("baz"),
+		"fact2": {Type: facts.FactTypeNumber, Value: "3"},
+	}, []string{"fact1"})
+	if assert.NoError(t, err) {
+		identityFacts, err := provider.GetIdentityFacts(ctx, "customer0")
+		if assert.NoError(t, err) {
+			assert.Equal(t, facts.Facts{
+				"fact0": facts.NewStringFact("baz"),
+				"fact2": {Type: facts.FactTypeNumber, Value: "3"},
+			}, identityFacts)
+		}
+	}
+
+	err = provider.UpdateIdentityFacts(ctx, "", facts.Facts{"fact0": facts.NewStringFact("foo")}, nil)
+	assert.Error(t, err)
+
+	err = provider.UpdateIdentityFacts(ctx, "customer0", facts.Facts{"fact0": facts.NewStringFact("")}, nil)
+	assert.Error(t, err)
+}
+
+func TestRedisGetIdentitiesFacts(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	client.Set(ctx, "customer0:fact0", "foo", 0)
+	client.Set(ctx, "customer0:fact1", "bar", 0)
+	client.Set(ctx, "customer1:fact0", "baz", 0)
+	client.Set(ctx, "customer2:fact0", "qux", 0)
+
+	provider := facts.NewRedisFactProvider(client)
+
+	identities, err := provider.GetIdentitiesFacts(ctx, []string{"customer0", "customer1", "missing"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]facts.Facts{
+			"customer0": {"fact0": facts.NewStringFact("foo"), "fact1": facts.NewStringFact("bar")},
+			"customer1": {"fact0": facts.NewStringFact("baz")},
+		}, identities)
+	}
+}
@@ -0,0 +1,259 @@
+package facts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSelector = errors.New("invalid selector")
+
+type SelectorOperator string
+
+const (
+	SelectorEquals       SelectorOperator = "="
+	SelectorNotEquals    SelectorOperator = "!="
+	SelectorIn           SelectorOperator = "in"
+	SelectorNotIn        SelectorOperator = "notin"
+	SelectorExists       SelectorOperator = "exists"
+	SelectorDoesNotExist SelectorOperator = "!"
+)
+
+// A single condition on a fact of an identity
+type Requirement struct {
+	Key      string
+	Operator SelectorOperator
+	Values   []string
+}
+
+// A label selector style query over identity facts. An identity matches when it
+// meets every requirement. An empty selector matches every identity.
+type Selector []Requirement
+
+// Providers that can evaluate selectors natively implement this to avoid loading every identity
+type SelectorQuerier interface {
+	// Returns the facts of every identity matching the selector
+	QueryIdentities(ctx context.Context, selector Selector) (map[string]Facts, error)
+}
+
+var (
+	setRequirementPattern      = regexp.MustCompile(`^([^\s!=(),]+)\s+(in|notin)\s*\((.*)\)$`)
+	equalityRequirementPattern = regexp.MustCompile(`^([^\s!=(),]+)\s*(==|=|!=)\s*([^\s!=(),]*)$`)
+	keyPattern                 = regexp.MustCompile(`^[^\s!=(),]+$`)
+)
+
+// Splits the selector on commas that are not inside a value list
+func splitRequirements(s string) ([]string, error) {
+	parts := []string{}
+	depth := 0
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("%w: unexpected )", ErrInvalidSelector)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("%w: missing )", ErrInvalidSelector)
+	}
+
+	return append(parts, s[start:]), nil
+}
+
+func parseRequirement(s string) (Requirement, error) {
+	if key, ok := strings.CutPrefix(s, "!"); ok {
+		key = strings.TrimSpace(key)
+		if !keyPattern.MatchString(key) {
+			return Requirement{}, fmt.Errorf("%w: invalid key in %q", ErrInvalidSelector, s)
+		}
+		return Requirement{Key: key, Operator: SelectorDoesNotExist}, nil
+	}
+
+	if match := setRequirementPattern.FindStringSubmatch(s); match != nil {
+		values := []string{}
+		for _, v := range strings.Split(match[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return Requirement{}, fmt.Errorf("%w: empty value list in %q", ErrInvalidSelector, s)
+		}
+		return Requirement{Key: match[1], Operator: SelectorOperator(match[2]), Values: values}, nil
+	}
+
+	if match := equalityRequirementPattern.FindStringSubmatch(s); match != nil {
+		operator := SelectorEquals
+		if match[2] == "!=" {
+			operator = SelectorNotEquals
+		}
+		return Requirement{Key: match[1], Operator: operator, Values: []string{match[3]}}, nil
+	}
+
+	if keyPattern.MatchString(s) {
+		return Requirement{Key: s, Operator: SelectorExists}, nil
+	}
+
+	return Requirement{}, fmt.Errorf("%w: could not parse %q", ErrInvalidSelector, s)
+}
+
+// Parses a selector such as "cluster=us-east-1,tier in (gold, platinum),!deprecated".
+//
+// Supported requirements are key=value, key==value, key!=value, key in (a,b),
+// key notin (a,b), key (fact is set) and !key (fact is not set).
+func ParseSelector(s string) (Selector, error) {
+	selector := Selector{}
+	if strings.TrimSpace(s) == "" {
+		return selector, nil
+	}
+
+	parts, err := splitRequirements(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("%w: empty requirement", ErrInvalidSelector)
+		}
+
+		requirement, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, requirement)
+	}
+
+	return selector, nil
+}
+
+// Returns the values of the requirement written the way bool facts are stored so a
+// bool fact matches any spelling strconv.ParseBool accepts
+func (r Requirement) boolValues() []string {
+	values := []string{}
+	for _, v := range r.Values {
+		if b, err := strconv.ParseBool(v); err == nil {
+			values = append(values, strconv.FormatBool(b))
+		}
+	}
+
+	return values
+}
+
+// Returns the values of the requirement that are numbers so a number fact matches
+// them by value, e.g. 3 matches a fact stored as 3.0
+func (r Requirement) numberValues() []string {
+	values := []string{}
+	for _, v := range r.Values {
+		// Only numbers written the way number facts are stored, as JSON numbers
+		if v != "" && (v[0] == '-' || (v[0] >= '0' && v[0] <= '9')) && json.Valid([]byte(v)) {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// Reports whether the decoded value of the fact equals one of the requirement values
+// compared as the type of the fact
+func (r Requirement) matchesValue(fact Fact) bool {
+	if slices.Contains(r.Values, fact.String()) {
+		return true
+	}
+
+	switch fact.Type {
+	case FactTypeBool:
+		return slices.Contains(r.boolValues(), fact.String())
+	case FactTypeNumber:
+		value, ok := new(big.Rat).SetString(fact.String())
+		if !ok {
+			return false
+		}
+
+		return slices.ContainsFunc(r.numberValues(), func(v string) bool {
+			number, _ := new(big.Rat).SetString(v)
+			return number.Cmp(value) == 0
+		})
+	default:
+		return false
+	}
+}
+
+// Reports whether the facts meet the requirement. Facts are compared by their decoded
+// value as their type, so bools and numbers match however the value is written.
+func (r Requirement) Matches(identityFacts Facts) bool {
+	fact, ok := identityFacts[r.Key]
+
+	switch r.Operator {
+	case SelectorExists:
+		return ok
+	case SelectorDoesNotExist:
+		return !ok
+	case SelectorEquals, SelectorIn:
+		return ok && r.matchesValue(fact)
+	case SelectorNotEquals, SelectorNotIn:
+		return !ok || !r.matchesValue(fact)
+	default:
+		return false
+	}
+}
+
+// Reports whether the facts meet every requirement of the selector
+func (s Selector) Matches(identityFacts Facts) bool {
+	for _, requirement := range s {
+		if !requirement.Matches(identityFacts) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the facts of every identity matching the selector. The query is pushed down
+// to the provider when it implements SelectorQuerier, otherwise every identity is loaded
+// and matched in memory.
+func SelectIdentities(ctx context.Context, provider FactProvider, selector Selector) (map[string]Facts, error) {
+	if querier, ok := provider.(SelectorQuerier); ok {
+		return querier.QueryIdentities(ctx, selector)
+	}
+
+	ids, err := provider.GetAllIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]Facts{}
+	for _, id := range ids {
+		identityFacts, err := provider.GetIdentityFacts(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrIdentityNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		if selector.Matches(identityFacts) {
+			result[id] = identityFacts
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package facts_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected facts.Selector
+		wantErr  bool
+	}{
+		{name: "empty", input: "", expected: facts.Selector{}},
+		{name: "equals", input: "cluster=us-east-1", expected: facts.Selector{{Key: "cluster", Operator: facts.SelectorEquals, Values: []string{"us-east-1"}}}},
+		{name: "double equals", input: "cluster == us-east-1", expected: facts.Selector{{Key: "cluster", Operator: facts.SelectorEquals, Values: []string{"us-east-1"}}}},
+		{name: "not equals", input: "cluster!=us-east-1", expected: facts.Selector{{Key: "cluster", Operator: facts.SelectorNotEquals, Values: []string{"us-east-1"}}}},
+		{
+			name:  "multiple requirements",
+			input: "cluster=us-east-1, tier in (gold, platinum), region notin (eu-west-1), env, !deprecated",
+			expected: facts.Selector{
+				{Key: "cluster", Operator: facts.SelectorEquals, Values: []string{"us-east-1"}},
+				{Key: "tier", Operator: facts.SelectorIn, Values: []string{"gold", "platinum"}},
+				{Key: "region", Operator: facts.SelectorNotIn, Values: []string{"eu-west-1"}},
+				{Key: "env", Operator: facts.SelectorExists},
+				{Key: "deprecated", Operator: facts.SelectorDoesNotExist},
+			},
+		},
+		{name: "unclosed list", input: "tier in (gold", wantErr: true},
+		{name: "empty list", input: "tier in ()", wantErr: true},
+		{name: "empty requirement", input: "cluster=us-east-1,,env", wantErr: true},
+		{name: "garbage", input: "tier gold", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := facts.ParseSelector(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, facts.ErrInvalidSelector)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, selector)
+		})
+	}
+}
+
+func TestSelectIdentities(t *testing.T) {
+	provider := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"cluster": facts.NewStringFact("us-east-1"), "tier": facts.NewStringFact("gold")},
+			"customer1": {"cluster": facts.NewStringFact("us-east-1"), "tier": facts.NewStringFact("silver")},
+			"customer2": {"cluster": facts.NewStringFact("eu-west-1"), "tier": facts.NewStringFact("platinum"), "deprecated": facts.NewStringFact("yes")},
+			"customer3": {"replicas": facts.Fact{Type: facts.FactTypeNumber, Value: "3"}},
+			"customer4": {"replicas": facts.Fact{Type: facts.FactTypeNumber, Value: "3.0"}, "enabled": facts.Fact{Type: facts.FactTypeBool, Value: "true"}},
+			"customer5": {"enabled": facts.Fact{Type: facts.FactTypeBool, Value: "false"}, "tier": facts.NewStringFact("True")},
+		},
+	}
+
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{selector: "", expected: []string{"customer0", "customer1", "customer2", "customer3", "customer4", "customer5"}},
+		{selector: "cluster=us-east-1,tier in (gold, platinum)", expected: []string{"customer0"}},
+		{selector: "tier in (gold, platinum)", expected: []string{"customer0", "customer2"}},
+		{selector: "tier notin (gold)", expected: []string{"customer1", "customer2", "customer3", "customer4", "customer5"}},
+		{selector: "cluster!=us-east-1", expected: []string{"customer2", "customer3", "customer4", "customer5"}},
+		{selector: "tier,!deprecated", expected: []string{"customer0", "customer1", "customer5"}},
+		{selector: "replicas=3", expected: []string{"customer3", "customer4"}},
+		{selector: "replicas in (3.00, 4)", expected: []string{"customer3", "customer4"}},
+		{selector: "replicas!=3", expected: []string{"customer0", "customer1", "customer2", "customer5"}},
+		{selector: "enabled=true", expected: []string{"customer4"}},
+		{selector: "enabled=True", expected: []string{"customer4"}},
+		{selector: "enabled notin (false)", expected: []string{"customer0", "customer1", "customer2", "customer3", "customer4"}},
+		{selector: "tier=true", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := facts.ParseSelector(tt.selector)
+			require.NoError(t, err)
+
+			identities, err := facts.SelectIdentities(context.Background(), provider, selector)
+			require.NoError(t, err)
+
+			ids := []string{}
+			for id := range identities {
+				ids = append(ids, id)
+			}
+			assert.ElementsMatch(t, tt.expected, ids)
+		})
+	}
+}
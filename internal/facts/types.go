@@ -0,0 +1,227 @@
+package facts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type FactType string
+
+const (
+	FactTypeString FactType = "string"
+	FactTypeNumber FactType = "number"
+	FactTypeBool   FactType = "bool"
+	FactTypeJSON   FactType = "json"
+)
+
+// All supported fact types
+var FactTypes = []FactType{FactTypeString, FactTypeNumber, FactTypeBool, FactTypeJSON}
+
+// Marks values written by providers that store facts as plain strings so the
+// type can be recovered. Untyped values are always read back as strings.
+const typedFactMarker = "\x00flagops:"
+
+var ErrInvalidFactType = errors.New("invalid fact type")
+
+// A single fact value along with the type it was written as
+type Fact struct {
+	Type FactType
+
+	// String facts hold the raw string while every other type holds its compact JSON encoding
+	Value string
+}
+
+// Creates a plain string fact
+func NewStringFact(value string) Fact {
+	return Fact{Type: FactTypeString, Value: value}
+}
+
+// Creates a fact of the given type from its string representation
+func ParseFact(factType FactType, value string) (Fact, error) {
+	switch factType {
+	case "", FactTypeString:
+		return NewStringFact(value), nil
+	case FactTypeNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return Fact{}, fmt.Errorf("%s is not a number", value)
+		}
+		return ParseJSONFact(json.RawMessage(value))
+	case FactTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return Fact{}, fmt.Errorf("%s is not a boolean", value)
+		}
+		return Fact{Type: FactTypeBool, Value: strconv.FormatBool(b)}, nil
+	case FactTypeJSON:
+		return ParseJSONFact(json.RawMessage(value))
+	default:
+		return Fact{}, fmt.Errorf("%w %s", ErrInvalidFactType, factType)
+	}
+}
+
+// Creates a fact from a JSON value keeping its native type
+func ParseJSONFact(raw json.RawMessage) (Fact, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 {
+		return Fact{}, errors.New("value is blank")
+	}
+
+	switch trimmed[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return Fact{}, err
+		}
+		return NewStringFact(s), nil
+	case 't', 'f':
+		var b bool
+		if err := json.Unmarshal(trimmed, &b); err != nil {
+			return Fact{}, err
+		}
+		return Fact{Type: FactTypeBool, Value: strconv.FormatBool(b)}, nil
+	case 'n':
+		return Fact{}, errors.New("value must not be null")
+	case '{', '[':
+		compacted := bytes.NewBuffer(nil)
+		if err := json.Compact(compacted, trimmed); err != nil {
+			return Fact{}, err
+		}
+		return Fact{Type: FactTypeJSON, Value: compacted.String()}, nil
+	default:
+		var n json.Number
+		if err := json.Unmarshal(trimmed, &n); err != nil {
+			return Fact{}, err
+		}
+		return Fact{Type: FactTypeNumber, Value: n.String()}, nil
+	}
+}
+
+// Returns the value of the fact as its native go type. Numbers are returned as json.Number.
+func (f Fact) Native() any {
+	if f.Type == "" || f.Type == FactTypeString {
+		return f.Value
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(f.Value))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return f.Value
+	}
+
+	return v
+}
+
+// Returns the fact formatted for display
+func (f Fact) String() string {
+	return f.Value
+}
+
+// Encodes the fact as its native JSON type
+func (f Fact) MarshalJSON() ([]byte, error) {
+	if f.Type == "" || f.Type == FactTypeString {
+		return json.Marshal(f.Value)
+	}
+
+	return []byte(f.Value), nil
+}
+
+// Decodes a fact from any JSON value other than null
+func (f *Fact) UnmarshalJSON(data []byte) error {
+	fact, err := ParseJSONFact(data)
+	if err != nil {
+		return err
+	}
+
+	*f = fact
+	return nil
+}
+
+// Converts JSON numbers so they are encoded as YAML numbers instead of strings
+func yamlValue(v any) any {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value.String()
+	case map[string]any:
+		for k, item := range value {
+			value[k] = yamlValue(item)
+		}
+		return value
+	case []any:
+		for i, item := range value {
+			value[i] = yamlValue(item)
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+// Encodes the fact as its native YAML type
+func (f Fact) MarshalYAML() (any, error) {
+	return yamlValue(f.Native()), nil
+}
+
+// Decodes a fact from any YAML value other than null
+func (f *Fact) UnmarshalYAML(node *yaml.Node) error {
+	var v any
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	fact, err := ParseJSONFact(raw)
+	if err != nil {
+		return err
+	}
+
+	*f = fact
+	return nil
+}
+
+// Encodes the fact for providers that can only store plain strings. String
+// facts are stored as is so existing values keep working unchanged.
+func EncodeFact(f Fact) string {
+	if (f.Type == "" || f.Type == FactTypeString) && !strings.HasPrefix(f.Value, typedFactMarker) {
+		return f.Value
+	}
+
+	factType := f.Type
+	if factType == "" {
+		factType = FactTypeString
+	}
+
+	return fmt.Sprintf("%s%s:%s", typedFactMarker, factType, f.Value)
+}
+
+// Decodes a fact written by EncodeFact
+func DecodeFact(raw string) Fact {
+	encoded, ok := strings.CutPrefix(raw, typedFactMarker)
+	if !ok {
+		return NewStringFact(raw)
+	}
+
+	factType, value, ok := strings.Cut(encoded, ":")
+	if !ok {
+		return NewStringFact(raw)
+	}
+
+	return Fact{Type: FactType(factType), Value: value}
+}
@@ -0,0 +1,127 @@
+package facts_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFact(t *testing.T) {
+	var tests = []struct {
+		name         string
+		factType     facts.FactType
+		value        string
+		expectError  bool
+		expectedFact facts.Fact
+	}{
+		{
+			name:         "default type is string",
+			factType:     "",
+			value:        "foo",
+			expectedFact: facts.NewStringFact("foo"),
+		},
+		{
+			name:         "number",
+			factType:     facts.FactTypeNumber,
+			value:        "1.5",
+			expectedFact: facts.Fact{Type: facts.FactTypeNumber, Value: "1.5"},
+		},
+		{
+			name:        "invalid number",
+			factType:    facts.FactTypeNumber,
+			value:       "foo",
+			expectError: true,
+		},
+		{
+			name:         "bool",
+			factType:     facts.FactTypeBool,
+			value:        "TRUE",
+			expectedFact: facts.Fact{Type: facts.FactTypeBool, Value: "true"},
+		},
+		{
+			name:         "json is compacted",
+			factType:     facts.FactTypeJSON,
+			value:        `{ "foo": [1, 2] }`,
+			expectedFact: facts.Fact{Type: facts.FactTypeJSON, Value: `{"foo":[1,2]}`},
+		},
+		{
+			name:        "invalid json",
+			factType:    facts.FactTypeJSON,
+			value:       `{"foo"`,
+			expectError: true,
+		},
+		{
+			name:        "unknown type",
+			factType:    "date",
+			value:       "foo",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fact, err := facts.ParseFact(tt.factType, tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.expectedFact, fact)
+				}
+			}
+		})
+	}
+}
+
+func TestFactJSONRoundTrip(t *testing.T) {
+	input := `{"a":"foo","b":42,"c":false,"d":{"e":[1,"2"]}}`
+
+	var parsed facts.Facts
+	if assert.NoError(t, json.Unmarshal([]byte(input), &parsed)) {
+		assert.Equal(t, facts.FactTypeString, parsed["a"].Type)
+		assert.Equal(t, facts.FactTypeNumber, parsed["b"].Type)
+		assert.Equal(t, facts.FactTypeBool, parsed["c"].Type)
+		assert.Equal(t, facts.FactTypeJSON, parsed["d"].Type)
+
+		output, err := json.Marshal(parsed)
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, input, string(output))
+		}
+	}
+
+	var fact facts.Fact
+	assert.Error(t, json.Unmarshal([]byte("null"), &fact))
+}
+
+func TestEncodeDecodeFact(t *testing.T) {
+	var tests = []struct {
+		name            string
+		fact            facts.Fact
+		expectedEncoded string
+	}{
+		{
+			name:            "strings are stored as is",
+			fact:            facts.NewStringFact("foo"),
+			expectedEncoded: "foo",
+		},
+		{
+			name:            "typed values are marked",
+			fact:            facts.Fact{Type: facts.FactTypeNumber, Value: "42"},
+			expectedEncoded: "\x00flagops:number:42",
+		},
+		{
+			name:            "strings that look encoded are escaped",
+			fact:            facts.NewStringFact("\x00flagops:number:42"),
+			expectedEncoded: "\x00flagops:string:\x00flagops:number:42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := facts.EncodeFact(tt.fact)
+			assert.Equal(t, tt.expectedEncoded, encoded)
+			assert.Equal(t, tt.fact, facts.DecodeFact(encoded))
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package facts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Returns the version of the facts of an identity. The version is derived from
+// the facts themselves so every replica and provider agrees on it.
+func Version(f Facts) string {
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(f)) {
+		// Length prefixed so entries cannot run together
+		value := EncodeFact(f[key])
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(key), key, len(value), value)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// Checks the facts are still at the version the caller read them at
+func checkVersion(current Facts, version string) error {
+	if Version(current) != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package facts_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	foo := facts.NewStringFact("foo")
+
+	// Missing identities have the same version as identities without facts
+	assert.Equal(t, facts.Version(nil), facts.Version(facts.Facts{}))
+
+	assert.Equal(t, facts.Version(facts.Facts{"a": foo, "b": foo}), facts.Version(facts.Facts{"b": foo, "a": foo}))
+	assert.NotEqual(t, facts.Version(facts.Facts{"a": foo}), facts.Version(facts.Facts{"a": facts.NewStringFact("bar")}))
+
+	// The type of a fact is part of its version
+	assert.NotEqual(t, facts.Version(facts.Facts{"a": facts.NewStringFact("1")}), facts.Version(facts.Facts{"a": {Type: facts.FactTypeNumber, Value: "1"}}))
+
+	// Keys and values cannot be shifted into each other
+	assert.NotEqual(t, facts.Version(facts.Facts{"ab": facts.NewStringFact("c")}), facts.Version(facts.Facts{"a": facts.NewStringFact("bc")}))
+}
+
+// Checks conditional updates behave the same for every provider
+func testProviderUpdateIfMatch(t *testing.T, ctx context.Context, provider facts.FactProvider) {
+	foo := facts.NewStringFact("foo")
+	bar := facts.NewStringFact("bar")
+
+	// Identities are created when the caller saw them missing
+	assert.NoError(t, provider.UpdateIdentityFactsIfMatch(ctx, "customer1", facts.Version(nil), facts.Facts{"fact0": foo}, nil))
+	assert.ErrorIs(t, provider.UpdateIdentityFactsIfMatch(ctx, "customer1", facts.Version(nil), facts.Facts{"fact0": bar}, nil), facts.ErrVersionMismatch)
+
+	current, err := provider.GetIdentityFacts(ctx, "customer1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, facts.Facts{"fact0": foo}, current)
+	version := facts.Version(current)
+
+	assert.NoError(t, provider.UpdateIdentityFactsIfMatch(ctx, "customer1", version, facts.Facts{"fact1": bar}, nil))
+
+	// The version read before the last update is stale
+	assert.ErrorIs(t, provider.UpdateIdentityFactsIfMatch(ctx, "customer1", version, nil, []string{"fact0"}), facts.ErrVersionMismatch)
+
+	// Plain writes change the version too
+	current, _ = provider.GetIdentityFacts(ctx, "customer1")
+	version = facts.Version(current)
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer1", "fact0", bar))
+	assert.ErrorIs(t, provider.UpdateIdentityFactsIfMatch(ctx, "customer1", version, nil, []string{"fact0"}), facts.ErrVersionMismatch)
+
+	current, err = provider.GetIdentityFacts(ctx, "customer1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, facts.Facts{"fact0": bar, "fact1": bar}, current)
+	}
+
+	assert.NoError(t, provider.UpdateIdentityFactsIfMatch(ctx, "customer1", facts.Version(current), nil, []string{"fact0", "fact1"}))
+	_, err = provider.GetIdentityFacts(ctx, "customer1")
+	assert.ErrorIs(t, err, facts.ErrIdentityNotFound)
+}
+
+func TestMockUpdateIfMatch(t *testing.T) {
+	testProviderUpdateIfMatch(t, context.Background(), &facts.MockFactsProvider{FactsDB: map[string]facts.Facts{}})
+}
+
+func TestRedisUpdateIfMatch(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	provider := facts.NewRedisFactProvider(client)
+	testProviderUpdateIfMatch(t, ctx, provider)
+
+	// The version key is not mistaken for an identity of the string layout
+	ids, err := provider.GetAllIdentities(ctx)
+	if assert.NoError(t, err) {
+		assert.Empty(t, ids)
+	}
+
+	// Facts of an identity named flagops would overwrite the version keys
+	assert.NoError(t, provider.SetIdentityFact(ctx, "customer1", "fact0", facts.NewStringFact("foo")))
+	version := client.Get(ctx, "flagops:version:customer1").Val()
+	assert.NotEmpty(t, version)
+
+	assert.ErrorIs(t, provider.SetIdentityFact(ctx, "flagops", "version:customer1", facts.NewStringFact("foo")), facts.ErrReservedIdentity)
+	assert.ErrorIs(t, provider.UpdateIdentityFacts(ctx, "flagops", nil, []string{"version:customer1"}), facts.ErrReservedIdentity)
+	assert.Equal(t, version, client.Get(ctx, "flagops:version:customer1").Val())
+}
+
+func TestRedisHashUpdateIfMatch(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getRedisContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start redis: %s", err)
+	}
+	defer closeRedisContainer(t, ctx, container)
+
+	testProviderUpdateIfMatch(t, ctx, facts.NewRedisHashFactProvider(client))
+}
+
+func TestPostgresUpdateIfMatch(t *testing.T) {
+	ctx := context.Background()
+	container, provider := getPostgresProvider(t, ctx)
+	defer closePostgresContainer(t, ctx, container)
+
+	testProviderUpdateIfMatch(t, ctx, provider)
+}
@@ -0,0 +1,136 @@
+package flags
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diegoholiveira/jsonlogic/v3"
+)
+
+const (
+	ReasonStatic         = "STATIC"
+	ReasonDefault        = "DEFAULT"
+	ReasonTargetingMatch = "TARGETING_MATCH"
+)
+
+const (
+	ErrorCodeFlagNotFound = "FLAG_NOT_FOUND"
+	ErrorCodeParseError   = "PARSE_ERROR"
+	ErrorCodeGeneral      = "GENERAL"
+)
+
+// An error reported to OFREP clients with one of the OpenFeature error codes
+type EvaluationError struct {
+	Code    string
+	Details string
+}
+
+func (e *EvaluationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Details)
+}
+
+// The resolved value of a flag
+type Result struct {
+	Key      string         `json:"key"`
+	Value    any            `json:"value"`
+	Reason   string         `json:"reason"`
+	Variant  string         `json:"variant"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+func init() {
+	// Custom operators supported by flagd targeting rules
+	jsonlogic.AddOperator("starts_with", stringOperator(strings.HasPrefix))
+	jsonlogic.AddOperator("ends_with", stringOperator(strings.HasSuffix))
+}
+
+func stringOperator(compare func(s string, affix string) bool) func(values, data any) any {
+	return func(values, data any) any {
+		args, ok := values.([]any)
+		if !ok || len(args) != 2 {
+			return false
+		}
+
+		s, ok := args[0].(string)
+		if !ok {
+			return false
+		}
+
+		affix, ok := args[1].(string)
+		if !ok {
+			return false
+		}
+
+		return compare(s, affix)
+	}
+}
+
+func resolveVariant(key string, flag Flag, variant string, reason string) (*Result, error) {
+	value, ok := flag.Variants[variant]
+	if !ok {
+		return nil, &EvaluationError{Code: ErrorCodeGeneral, Details: fmt.Sprintf("targeting returned unknown variant %s", variant)}
+	}
+
+	return &Result{
+		Key:      key,
+		Value:    value,
+		Reason:   reason,
+		Variant:  variant,
+		Metadata: flag.Metadata,
+	}, nil
+}
+
+// Evaluates the flag against the evaluation context
+func Evaluate(key string, flag Flag, evalCtx map[string]any) (*Result, error) {
+	if flag.State == StateDisabled {
+		return nil, &EvaluationError{Code: ErrorCodeFlagNotFound, Details: fmt.Sprintf("flag %s is disabled", key)}
+	}
+
+	if len(flag.Targeting) == 0 {
+		return resolveVariant(key, flag, flag.DefaultVariant, ReasonStatic)
+	}
+
+	data := maps.Clone(evalCtx)
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["$flagd"] = map[string]any{
+		"flagKey":   key,
+		"timestamp": time.Now().Unix(),
+	}
+
+	rule, err := json.Marshal(flag.Targeting)
+	if err != nil {
+		return nil, &EvaluationError{Code: ErrorCodeParseError, Details: err.Error()}
+	}
+
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return nil, &EvaluationError{Code: ErrorCodeParseError, Details: err.Error()}
+	}
+
+	rawResult, err := jsonlogic.ApplyRaw(rule, rawData)
+	if err != nil {
+		return nil, &EvaluationError{Code: ErrorCodeGeneral, Details: err.Error()}
+	}
+
+	var result any
+	if err := json.Unmarshal(rawResult, &result); err != nil {
+		return nil, &EvaluationError{Code: ErrorCodeGeneral, Details: err.Error()}
+	}
+
+	switch variant := result.(type) {
+	case nil:
+		return resolveVariant(key, flag, flag.DefaultVariant, ReasonDefault)
+	case string:
+		return resolveVariant(key, flag, variant, ReasonTargetingMatch)
+	case bool:
+		return resolveVariant(key, flag, strconv.FormatBool(variant), ReasonTargetingMatch)
+	default:
+		return nil, &EvaluationError{Code: ErrorCodeGeneral, Details: fmt.Sprintf("targeting returned %v which is not a variant name", result)}
+	}
+}
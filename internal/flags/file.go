@@ -0,0 +1,159 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var _ FlagSource = &FileFlagSource{}
+
+type flagDefinitions struct {
+	Flags      map[string]Flag `json:"flags"`
+	Evaluators map[string]any  `json:"$evaluators"`
+}
+
+// Loads flags from a flagd flag definitions file. The file is reloaded when it changes.
+type FileFlagSource struct {
+	path string
+
+	lock    sync.RWMutex
+	modTime time.Time
+	flags   map[string]Flag
+}
+
+func NewFileFlagSource(path string) (*FileFlagSource, error) {
+	source := &FileFlagSource{path: path}
+	if err := source.reload(); err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// Replaces {"$ref": "name"} with the shared evaluator of the same name
+func resolveRefs(value any, evaluators map[string]any, depth int) (any, error) {
+	if depth > 32 {
+		return nil, fmt.Errorf("evaluator references nested too deep")
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok && len(v) == 1 {
+			evaluator, ok := evaluators[ref]
+			if !ok {
+				return nil, fmt.Errorf("unknown evaluator %s", ref)
+			}
+			return resolveRefs(evaluator, evaluators, depth+1)
+		}
+
+		resolved := map[string]any{}
+		for k, item := range v {
+			r, err := resolveRefs(item, evaluators, depth)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = r
+		}
+		return resolved, nil
+	case []any:
+		resolved := []any{}
+		for _, item := range v {
+			r, err := resolveRefs(item, evaluators, depth)
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, r)
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+func parseFlagDefinitions(data []byte) (map[string]Flag, error) {
+	definitions := flagDefinitions{}
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, err
+	}
+
+	for key, flag := range definitions.Flags {
+		if _, ok := flag.Variants[flag.DefaultVariant]; !ok {
+			return nil, fmt.Errorf("flag %s: default variant %s does not exist", key, flag.DefaultVariant)
+		}
+
+		if flag.Targeting != nil {
+			targeting, err := resolveRefs(flag.Targeting, definitions.Evaluators, 0)
+			if err != nil {
+				return nil, fmt.Errorf("flag %s: %w", key, err)
+			}
+			flag.Targeting = targeting.(map[string]any)
+		}
+
+		definitions.Flags[key] = flag
+	}
+
+	return definitions.Flags, nil
+}
+
+func (f *FileFlagSource) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.lock.RLock()
+	unchanged := info.ModTime().Equal(f.modTime) && f.flags != nil
+	f.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	flags, err := parseFlagDefinitions(data)
+	if err != nil {
+		return fmt.Errorf("could not parse flag definitions %s: %w", f.path, err)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.flags = flags
+	f.modTime = info.ModTime()
+	logrus.WithFields(logrus.Fields{"path": f.path, "flags": len(flags)}).Info("loaded flag definitions")
+	return nil
+}
+
+// Returns the current flags, keeping the last good definitions if the file can not be reloaded
+func (f *FileFlagSource) current() map[string]Flag {
+	if err := f.reload(); err != nil {
+		logrus.WithField("path", f.path).WithError(err).Error("could not reload flag definitions")
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.flags
+}
+
+// GetFlag implements FlagSource.
+func (f *FileFlagSource) GetFlag(ctx context.Context, key string) (*Flag, error) {
+	flag, ok := f.current()[key]
+	if !ok {
+		return nil, ErrFlagNotFound
+	}
+
+	return &flag, nil
+}
+
+// GetFlags implements FlagSource.
+func (f *FileFlagSource) GetFlags(ctx context.Context) (map[string]Flag, error) {
+	return f.current(), nil
+}
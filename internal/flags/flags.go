@@ -0,0 +1,46 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/graytonio/flagops-data-store/internal/config"
+)
+
+var ErrFlagNotFound = errors.New("flag not found")
+
+const (
+	StateEnabled  = "ENABLED"
+	StateDisabled = "DISABLED"
+)
+
+// A flag definition in the flagd flag definition format
+type Flag struct {
+	State          string         `json:"state"`
+	Variants       map[string]any `json:"variants"`
+	DefaultVariant string         `json:"defaultVariant"`
+	Targeting      map[string]any `json:"targeting,omitempty"`
+	Metadata       map[string]any `json:"metadata,omitempty"`
+}
+
+// Provides the flag definitions evaluated by the OFREP endpoints
+type FlagSource interface {
+	// Returns the flag with the given key or ErrFlagNotFound
+	GetFlag(ctx context.Context, key string) (*Flag, error)
+
+	// Returns every flag by key
+	GetFlags(ctx context.Context) (map[string]Flag, error)
+}
+
+// Returns the configured flag source or nil if flag evaluation is disabled
+func GetFlagSource(conf config.FlagsOptions) (FlagSource, error) {
+	switch conf.Source {
+	case "":
+		return nil, nil
+	case "file":
+		return NewFileFlagSource(conf.FilePath)
+	default:
+		return nil, fmt.Errorf("no such flag source %s", conf.Source)
+	}
+}
@@ -0,0 +1,106 @@
+package flags_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDefinitions = `{
+  "flags": {
+    "new-dashboard": {
+      "state": "ENABLED",
+      "variants": {"on": true, "off": false},
+      "defaultVariant": "off",
+      "targeting": {"if": [{"$ref": "enterprise"}, "on", null]}
+    },
+    "banner-color": {
+      "state": "ENABLED",
+      "variants": {"red": "#ff0000", "blue": "#0000ff"},
+      "defaultVariant": "red",
+      "targeting": {"if": [{"starts_with": [{"var": "region"}, "eu-"]}, "blue", null]}
+    },
+    "max-items": {
+      "state": "ENABLED",
+      "variants": {"small": 10, "large": 100},
+      "defaultVariant": "small"
+    },
+    "retired": {
+      "state": "DISABLED",
+      "variants": {"on": true},
+      "defaultVariant": "on"
+    }
+  },
+  "$evaluators": {
+    "enterprise": {"==": [{"var": "tier"}, "enterprise"]}
+  }
+}`
+
+func newTestSource(t *testing.T, definitions string) *flags.FileFlagSource {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	require.NoError(t, os.WriteFile(path, []byte(definitions), 0o644))
+
+	source, err := flags.NewFileFlagSource(path)
+	require.NoError(t, err)
+	return source
+}
+
+func TestEvaluate(t *testing.T) {
+	source := newTestSource(t, testDefinitions)
+
+	tests := []struct {
+		name    string
+		key     string
+		context map[string]any
+		value   any
+		variant string
+		reason  string
+		errCode string
+	}{
+		{name: "ref match", key: "new-dashboard", context: map[string]any{"tier": "enterprise"}, value: true, variant: "on", reason: flags.ReasonTargetingMatch},
+		{name: "ref no match", key: "new-dashboard", context: map[string]any{"tier": "free"}, value: false, variant: "off", reason: flags.ReasonDefault},
+		{name: "starts with", key: "banner-color", context: map[string]any{"region": "eu-west-1"}, value: "#0000ff", variant: "blue", reason: flags.ReasonTargetingMatch},
+		{name: "static", key: "max-items", value: float64(10), variant: "small", reason: flags.ReasonStatic},
+		{name: "disabled", key: "retired", errCode: flags.ErrorCodeFlagNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag, err := source.GetFlag(context.Background(), tt.key)
+			require.NoError(t, err)
+
+			result, err := flags.Evaluate(tt.key, *flag, tt.context)
+			if tt.errCode != "" {
+				evalErr := &flags.EvaluationError{}
+				require.ErrorAs(t, err, &evalErr)
+				assert.Equal(t, tt.errCode, evalErr.Code)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.value, result.Value)
+			assert.Equal(t, tt.variant, result.Variant)
+			assert.Equal(t, tt.reason, result.Reason)
+		})
+	}
+}
+
+func TestFileFlagSourceMissingFlag(t *testing.T) {
+	source := newTestSource(t, testDefinitions)
+
+	_, err := source.GetFlag(context.Background(), "missing")
+	assert.ErrorIs(t, err, flags.ErrFlagNotFound)
+}
+
+func TestFileFlagSourceInvalidDefaultVariant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"flags": {"broken": {"state": "ENABLED", "variants": {"on": true}, "defaultVariant": "off"}}}`), 0o644))
+
+	_, err := flags.NewFileFlagSource(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,462 @@
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type Options struct {
+	// Number of identities copied at once
+	Concurrency int
+
+	// File recording every identity that has been copied and verified so an
+	// interrupted migration can be resumed. Checkpointing is disabled when empty.
+	CheckpointPath string
+
+	// Report what would be copied without writing anything
+	DryRun bool
+
+	Facts   bool
+	Secrets bool
+}
+
+// Copies identities between providers. Either pair of providers may be nil when
+// Options does not migrate that kind of data.
+type Migrator struct {
+	SourceFacts      facts.FactProvider
+	DestinationFacts facts.FactProvider
+
+	SourceSecrets      secrets.SecretProvider
+	DestinationSecrets secrets.SecretProvider
+
+	Options Options
+}
+
+type Failure struct {
+	Identity string
+	Error    error
+}
+
+type Report struct {
+	// Identities found in the source
+	Identities int
+
+	// Identities skipped because the checkpoint records them as done
+	Skipped int
+
+	// Identities that were copied, or would be copied in a dry run
+	Copied int
+
+	// Identities that already matched the destination
+	Unchanged int
+
+	FactsWritten   int
+	FactsRemoved   int
+	SecretsWritten int
+	SecretsRemoved int
+
+	// Environment overrides written and removed across every environment
+	OverridesWritten int
+	OverridesRemoved int
+
+	// Number of source identities found in the destination after the migration
+	DestinationIdentities int
+
+	Failures []Failure
+}
+
+// Checksum of the facts or secrets of an identity that does not depend on key order
+func checksum[V any](values map[string]V, format func(V) string) string {
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(hash, "%s=%s\n", key, format(values[key]))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func factsChecksum(identityFacts facts.Facts) string {
+	return checksum(identityFacts, func(f facts.Fact) string {
+		return facts.EncodeFact(f)
+	})
+}
+
+func secretsChecksum(identitySecrets secrets.Secrets) string {
+	return checksum(identitySecrets, func(s string) string {
+		return s
+	})
+}
+
+// Returns the keys to write and remove so dest matches source
+func diff[V comparable](source map[string]V, dest map[string]V) ([]string, []string) {
+	write := []string{}
+	for _, key := range slices.Sorted(maps.Keys(source)) {
+		if existing, ok := dest[key]; !ok || existing != source[key] {
+			write = append(write, key)
+		}
+	}
+
+	remove := []string{}
+	for _, key := range slices.Sorted(maps.Keys(dest)) {
+		if _, ok := source[key]; !ok {
+			remove = append(remove, key)
+		}
+	}
+
+	return write, remove
+}
+
+func readCheckpoint(path string) (map[string]bool, error) {
+	done := map[string]bool{}
+	if path == "" {
+		return done, nil
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			done[id] = true
+		}
+	}
+
+	return done, scanner.Err()
+}
+
+func (m *Migrator) listIdentities(ctx context.Context) ([]string, error) {
+	ids := []string{}
+
+	if m.Options.Facts {
+		factIds, err := m.SourceFacts.GetAllIdentities(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, factIds...)
+	}
+
+	if m.Options.Secrets {
+		secretIds, err := m.SourceSecrets.GetAllIdentities(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, secretIds...)
+	}
+
+	ids = utils.RemoveDuplicate(ids)
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func getFacts(ctx context.Context, provider facts.FactProvider, id string) (facts.Facts, error) {
+	identityFacts, err := provider.GetIdentityFacts(ctx, id)
+	if errors.Is(err, facts.ErrIdentityNotFound) {
+		return facts.Facts{}, nil
+	}
+
+	return identityFacts, err
+}
+
+func getSecrets(ctx context.Context, provider secrets.SecretProvider, id string) (secrets.Secrets, error) {
+	identitySecrets, err := provider.GetIdentitySecrets(ctx, id)
+	if errors.Is(err, secrets.ErrIdentityNotFound) {
+		return secrets.Secrets{}, nil
+	}
+
+	return identitySecrets, err
+}
+
+// The changes made to a single identity
+type identityResult struct {
+	factsWritten     int
+	factsRemoved     int
+	overridesWritten int
+	overridesRemoved int
+	secretsWritten   int
+	secretsRemoved   int
+}
+
+func (r identityResult) changed() bool {
+	return r.factsWritten+r.factsRemoved+r.overridesWritten+r.overridesRemoved+r.secretsWritten+r.secretsRemoved > 0
+}
+
+// Makes the destination match the source for a single identity then reads it back to verify it
+func (m *Migrator) migrateIdentity(ctx context.Context, id string) (identityResult, error) {
+	result := identityResult{}
+
+	if m.Options.Facts {
+		sourceFacts, err := getFacts(ctx, m.SourceFacts, id)
+		if err != nil {
+			return result, fmt.Errorf("could not read source facts: %w", err)
+		}
+
+		destFacts, err := getFacts(ctx, m.DestinationFacts, id)
+		if err != nil {
+			return result, fmt.Errorf("could not read destination facts: %w", err)
+		}
+
+		write, remove := diff(sourceFacts, destFacts)
+		result.factsWritten, result.factsRemoved = len(write), len(remove)
+
+		if !m.Options.DryRun && len(write)+len(remove) > 0 {
+			set := facts.Facts{}
+			for _, key := range write {
+				set[key] = sourceFacts[key]
+			}
+
+			err = m.DestinationFacts.UpdateIdentityFacts(ctx, id, set, remove)
+			if err != nil {
+				return result, fmt.Errorf("could not write facts: %w", err)
+			}
+
+			destFacts, err = getFacts(ctx, m.DestinationFacts, id)
+			if err != nil {
+				return result, fmt.Errorf("could not verify facts: %w", err)
+			}
+
+			if factsChecksum(sourceFacts) != factsChecksum(destFacts) {
+				return result, errors.New("facts checksum does not match after copying")
+			}
+		}
+
+		result.overridesWritten, result.overridesRemoved, err = m.migrateOverrides(ctx, id)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if m.Options.Secrets {
+		sourceSecrets, err := getSecrets(ctx, m.SourceSecrets, id)
+		if err != nil {
+			return result, fmt.Errorf("could not read source secrets: %w", err)
+		}
+
+		destSecrets, err := getSecrets(ctx, m.DestinationSecrets, id)
+		if err != nil {
+			return result, fmt.Errorf("could not read destination secrets: %w", err)
+		}
+
+		write, remove := diff(sourceSecrets, destSecrets)
+		result.secretsWritten, result.secretsRemoved = len(write), len(remove)
+
+		if !m.Options.DryRun && len(write)+len(remove) > 0 {
+			for _, key := range write {
+				if err := m.DestinationSecrets.SetIdentitySecret(ctx, id, key, sourceSecrets[key]); err != nil {
+					return result, fmt.Errorf("could not write secret %s: %w", key, err)
+				}
+			}
+
+			for _, key := range remove {
+				if err := m.DestinationSecrets.DeleteIdentitySecret(ctx, id, key); err != nil {
+					return result, fmt.Errorf("could not remove secret %s: %w", key, err)
+				}
+			}
+
+			destSecrets, err = getSecrets(ctx, m.DestinationSecrets, id)
+			if err != nil {
+				return result, fmt.Errorf("could not verify secrets: %w", err)
+			}
+
+			if secretsChecksum(sourceSecrets) != secretsChecksum(destSecrets) {
+				return result, errors.New("secrets checksum does not match after copying")
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Makes the environment overrides of the identity in the destination match the source
+func (m *Migrator) migrateOverrides(ctx context.Context, id string) (int, int, error) {
+	sourceOverrides, err := facts.GetIdentityOverridesByKey(ctx, m.SourceFacts, id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not read source overrides: %w", err)
+	}
+
+	destOverrides, err := facts.GetIdentityOverridesByKey(ctx, m.DestinationFacts, id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not read destination overrides: %w", err)
+	}
+
+	write, remove := diff(sourceOverrides, destOverrides)
+	if m.Options.DryRun || len(write)+len(remove) == 0 {
+		return len(write), len(remove), nil
+	}
+
+	for _, override := range write {
+		env, key := facts.SplitOverrideKey(override)
+		if err := m.DestinationFacts.SetIdentityEnvironmentFact(ctx, id, env, key, sourceOverrides[override]); err != nil {
+			return 0, 0, fmt.Errorf("could not write override %s: %w", override, err)
+		}
+	}
+
+	for _, override := range remove {
+		env, key := facts.SplitOverrideKey(override)
+		if err := m.DestinationFacts.DeleteIdentityEnvironmentFact(ctx, id, env, key); err != nil {
+			return 0, 0, fmt.Errorf("could not remove override %s: %w", override, err)
+		}
+	}
+
+	destOverrides, err = facts.GetIdentityOverridesByKey(ctx, m.DestinationFacts, id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not verify overrides: %w", err)
+	}
+
+	if factsChecksum(sourceOverrides) != factsChecksum(destOverrides) {
+		return 0, 0, errors.New("overrides checksum does not match after copying")
+	}
+
+	return len(write), len(remove), nil
+}
+
+// Counts the source identities that exist in the destination
+func (m *Migrator) countDestination(ctx context.Context, ids []string) (int, error) {
+	found := map[string]bool{}
+
+	if m.Options.Facts {
+		destIds, err := m.DestinationFacts.GetAllIdentities(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range destIds {
+			found[id] = true
+		}
+	}
+
+	if m.Options.Secrets {
+		destIds, err := m.DestinationSecrets.GetAllIdentities(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range destIds {
+			found[id] = true
+		}
+	}
+
+	count := 0
+	for _, id := range ids {
+		if found[id] {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Copies every identity from the source providers to the destination providers.
+// Identities are verified after copying and only then recorded in the checkpoint.
+func (m *Migrator) Run(ctx context.Context) (*Report, error) {
+	if !m.Options.Facts && !m.Options.Secrets {
+		return nil, errors.New("nothing to migrate")
+	}
+
+	ids, err := m.listIdentities(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list source identities: %w", err)
+	}
+
+	done, err := readCheckpoint(m.Options.CheckpointPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint: %w", err)
+	}
+
+	var checkpoint *os.File
+	if m.Options.CheckpointPath != "" && !m.Options.DryRun {
+		checkpoint, err = os.OpenFile(m.Options.CheckpointPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open checkpoint: %w", err)
+		}
+		defer checkpoint.Close()
+	}
+
+	report := &Report{Identities: len(ids), Failures: []Failure{}}
+	lock := sync.Mutex{}
+
+	queue := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < max(m.Options.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				result, err := m.migrateIdentity(ctx, id)
+
+				lock.Lock()
+				switch {
+				case err != nil:
+					logrus.WithField("id", id).WithError(err).Error("could not migrate identity")
+					report.Failures = append(report.Failures, Failure{Identity: id, Error: err})
+				case result.changed():
+					report.Copied++
+				default:
+					report.Unchanged++
+				}
+				report.FactsWritten += result.factsWritten
+				report.FactsRemoved += result.factsRemoved
+				report.OverridesWritten += result.overridesWritten
+				report.OverridesRemoved += result.overridesRemoved
+				report.SecretsWritten += result.secretsWritten
+				report.SecretsRemoved += result.secretsRemoved
+
+				if err == nil && checkpoint != nil {
+					if _, err := fmt.Fprintln(checkpoint, id); err != nil {
+						logrus.WithField("id", id).WithError(err).Error("could not write checkpoint")
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		if done[id] {
+			report.Skipped++
+			continue
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+
+	slices.SortFunc(report.Failures, func(a, b Failure) int {
+		return strings.Compare(a.Identity, b.Identity)
+	})
+
+	if !m.Options.DryRun {
+		report.DestinationIdentities, err = m.countDestination(ctx, ids)
+		if err != nil {
+			return report, fmt.Errorf("could not count destination identities: %w", err)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,130 @@
+package migrate_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/migrate"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMigrator(checkpoint string, dryRun bool) (*migrate.Migrator, *facts.MockFactsProvider, *secrets.MockSecretsProvider) {
+	destFacts := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer1": {"fact0": facts.NewStringFact("stale"), "extra": facts.NewStringFact("foo")},
+		},
+	}
+
+	// The mock secrets provider can not create identities
+	destSecrets := &secrets.MockSecretsProvider{
+		SecretsDB: map[string]map[string]string{
+			"customer0": {},
+			"customer1": {},
+		},
+	}
+
+	return &migrate.Migrator{
+		SourceFacts: &facts.MockFactsProvider{
+			FactsDB: map[string]facts.Facts{
+				"customer0": {"fact0": facts.NewStringFact("foo"), "fact1": {Type: facts.FactTypeNumber, Value: "3"}},
+				"customer1": {"fact0": facts.NewStringFact("bar")},
+			},
+		},
+		DestinationFacts: destFacts,
+		SourceSecrets: &secrets.MockSecretsProvider{
+			SecretsDB: map[string]map[string]string{
+				"customer0": {"password": "hunter2"},
+			},
+		},
+		DestinationSecrets: destSecrets,
+		Options: migrate.Options{
+			Concurrency:    1,
+			CheckpointPath: checkpoint,
+			DryRun:         dryRun,
+			Facts:          true,
+			Secrets:        true,
+		},
+	}, destFacts, destSecrets
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	migrator, destFacts, _ := newTestMigrator("", true)
+
+	report, err := migrator.Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Identities)
+	assert.Equal(t, 2, report.Copied)
+	assert.Equal(t, 3, report.FactsWritten)
+	assert.Equal(t, 1, report.FactsRemoved)
+	assert.Equal(t, 1, report.SecretsWritten)
+	assert.Empty(t, report.Failures)
+
+	assert.NotContains(t, destFacts.FactsDB, "customer0")
+}
+
+func TestMigrateResume(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	migrator, destFacts, destSecrets := newTestMigrator(checkpoint, false)
+
+	report, err := migrator.Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Copied)
+	assert.Equal(t, 2, report.DestinationIdentities)
+	assert.Empty(t, report.Failures)
+	assert.Equal(t, migrator.SourceFacts.(*facts.MockFactsProvider).FactsDB, destFacts.FactsDB)
+	assert.Equal(t, "hunter2", destSecrets.SecretsDB["customer0"]["password"])
+
+	report, err = migrator.Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Skipped)
+	assert.Zero(t, report.Copied)
+}
+
+func TestMigrateOverrides(t *testing.T) {
+	baz := facts.NewStringFact("baz")
+
+	source := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{
+			"customer0": {"fact0": facts.NewStringFact("foo")},
+		},
+		EnvironmentsDB: map[string]map[string]facts.Facts{
+			"customer0": {"prod": {"fact0": baz}},
+			// Identities that only have overrides are migrated too
+			"customer1": {"staging": {"fact1": baz}},
+		},
+	}
+	dest := &facts.MockFactsProvider{
+		FactsDB: map[string]facts.Facts{},
+		EnvironmentsDB: map[string]map[string]facts.Facts{
+			"customer0": {"dev": {"fact0": facts.NewStringFact("stale")}},
+		},
+	}
+
+	migrator := &migrate.Migrator{
+		SourceFacts:      source,
+		DestinationFacts: dest,
+		Options:          migrate.Options{Concurrency: 1, Facts: true},
+	}
+
+	report, err := migrator.Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Identities)
+	assert.Equal(t, 2, report.Copied)
+	assert.Equal(t, 2, report.OverridesWritten)
+	assert.Equal(t, 1, report.OverridesRemoved)
+	assert.Equal(t, 2, report.DestinationIdentities)
+	assert.Empty(t, report.Failures)
+	assert.Equal(t, source.EnvironmentsDB, dest.EnvironmentsDB)
+
+	report, err = migrator.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Unchanged)
+}
@@ -0,0 +1,51 @@
+package requestmeta
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const (
+	callerKey contextKey = iota
+	requestIDKey
+)
+
+// Returns a copy of the context carrying the caller responsible for the request
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey, caller)
+}
+
+// Returns the caller responsible for the request or an empty string if it is not known
+func Caller(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey).(string)
+	return caller
+}
+
+// Returns a copy of the context carrying the id of the request
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// Returns the id of the request or an empty string if it is not known
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// Returns the request metadata carried by the context as log fields
+func LogFields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+
+	if caller := Caller(ctx); caller != "" {
+		fields["caller"] = caller
+	}
+
+	if requestID := RequestID(ctx); requestID != "" {
+		fields["request_id"] = requestID
+	}
+
+	return fields
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+)
+
+// Request sent by the ArgoCD ApplicationSet plugin generator
+type getParamsRequest struct {
+	ApplicationSetName string `json:"applicationSetName"`
+	Input              struct {
+		Parameters getParamsParameters `json:"parameters"`
+	} `json:"input"`
+}
+
+// Parameters set on the plugin generator in the ApplicationSet
+type getParamsParameters struct {
+	// Only identities starting with this prefix are returned
+	IdentityPrefix string `json:"identity_prefix"`
+
+	// Only identities with every one of these facts set to the given value are returned
+	FactSelector map[string]string `json:"fact_selector"`
+}
+
+type getParamsResponse struct {
+	Output struct {
+		Parameters []map[string]any `json:"parameters"`
+	} `json:"output"`
+}
+
+func matchesFactSelector(identityFacts facts.Facts, selector map[string]string) bool {
+	for key, value := range selector {
+		fact, ok := identityFacts[key]
+		if !ok || fact.String() != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Implements the ArgoCD ApplicationSet plugin generator contract returning one
+// parameter set per identity with its facts flattened into the set.
+func (r *APIRoutes) ArgoCDGetParams(ctx *gin.Context) {
+	req := getParamsRequest{}
+	err := ctx.BindJSON(&req)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	params := req.Input.Parameters
+
+	ids, err := r.FactProvider.GetAllIdentities(ctx.Request.Context())
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	slices.Sort(ids)
+
+	resp := getParamsResponse{}
+	resp.Output.Parameters = []map[string]any{}
+	for _, id := range ids {
+		if !strings.HasPrefix(id, params.IdentityPrefix) {
+			continue
+		}
+
+		identityFacts, err := r.FactProvider.GetIdentityFacts(ctx.Request.Context(), id)
+		if err != nil {
+			// The identity was deleted after it was listed
+			if errors.Is(err, facts.ErrIdentityNotFound) {
+				continue
+			}
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if !matchesFactSelector(identityFacts, params.FactSelector) {
+			continue
+		}
+
+		set := map[string]any{}
+		for key, fact := range identityFacts {
+			set[key] = fact.Native()
+		}
+		set["identity"] = id
+
+		resp.Output.Parameters = append(resp.Output.Parameters, set)
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
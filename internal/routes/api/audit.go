@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+)
+
+func (r *APIRoutes) GetAuditEvents(ctx *gin.Context) {
+	var filter audit.Filter
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := r.AuditService.GetEvents(ctx.Request.Context(), filter)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+)
+
+// Maximum number of identities read by a single batch request
+const maxBatchIdentities = 1000
+
+type batchReadRequest struct {
+	IDs []string `json:"ids"`
+
+	// Only return these keys of each identity. All keys are returned when empty.
+	Keys []string `json:"keys"`
+}
+
+type batchSecretsResponse struct {
+	Identities map[string]secrets.Secrets `json:"identities"`
+}
+
+func bindBatchReadRequest(ctx *gin.Context) (*batchReadRequest, bool) {
+	var body batchReadRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	if len(body.IDs) == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("ids must not be empty"))
+		return nil, false
+	}
+
+	if len(body.IDs) > maxBatchIdentities {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("at most %d ids can be read at once", maxBatchIdentities))
+		return nil, false
+	}
+
+	return &body, true
+}
+
+// Removes every key not in keys from each identity. Nothing is removed when keys is empty.
+func filterBatchKeys[M ~map[string]V, V any](identities map[string]M, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, values := range identities {
+		maps.DeleteFunc(values, func(key string, _ V) bool {
+			return !slices.Contains(keys, key)
+		})
+	}
+}
+
+// Returns the facts of many identities at once. Identities that do not exist are left out.
+func (r *APIRoutes) BatchGetIdentityFacts(ctx *gin.Context) {
+	body, ok := bindBatchReadRequest(ctx)
+	if !ok {
+		return
+	}
+
+	identities, err := facts.GetIdentitiesFacts(ctx.Request.Context(), r.FactProvider, body.IDs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	filterBatchKeys(identities, body.Keys)
+
+	ctx.JSON(http.StatusOK, selectIdentitiesResponse{
+		Identities: identities,
+	})
+}
+
+// Returns the secrets of many identities at once. Identities that do not exist are left out.
+func (r *APIRoutes) BatchGetIdentitySecrets(ctx *gin.Context) {
+	body, ok := bindBatchReadRequest(ctx)
+	if !ok {
+		return
+	}
+
+	// Record who read which secrets even if the read fails
+	ctx.Set(audit.DetailsKey, map[string]any{"ids": body.IDs, "keys": body.Keys})
+
+	identities, err := secrets.GetIdentitiesSecrets(ctx.Request.Context(), r.SecretProvider, body.IDs)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	filterBatchKeys(identities, body.Keys)
+
+	ctx.JSON(http.StatusOK, batchSecretsResponse{
+		Identities: identities,
+	})
+}
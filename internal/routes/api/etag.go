@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errIfMatchWithEnv = errors.New("If-Match is not supported with env")
+
+// Returns the ETag header value of a version
+func etag(version string) string {
+	return `"` + version + `"`
+}
+
+// The entity tags listed in the If-Match header of a request
+type ifMatchTags []string
+
+// Returns the strong entity tags in the If-Match header without quotes. Weak tags are
+// left out since If-Match uses the strong comparison which never matches them.
+// Nil when the header is missing.
+func ifMatch(ctx *gin.Context) ifMatchTags {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" {
+		return nil
+	}
+
+	tags := ifMatchTags{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			tags = append(tags, tag)
+			continue
+		}
+
+		if strings.HasPrefix(tag, "W/") || len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		tags = append(tags, strings.Trim(tag, `"`))
+	}
+
+	return tags
+}
+
+// Returns true if the current version is listed. * matches any version of a resource that exists.
+func (t ifMatchTags) matches(current string, exists bool) bool {
+	if slices.Contains(t, "*") {
+		return exists
+	}
+
+	return slices.Contains(t, current)
+}
+
+// Responds with 412 when the request was sent with If-Match and the current version does not match it.
+// Writes following a successful check must still be applied conditionally on the current version.
+func checkIfMatch(ctx *gin.Context, tags ifMatchTags, current string, exists bool, mismatch error) bool {
+	if tags != nil && !tags.matches(current, exists) {
+		ctx.AbortWithError(http.StatusPreconditionFailed, mismatch)
+		return false
+	}
+	return true
+}
@@ -1,13 +1,45 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/services/schedule"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
 )
 
+// Number of times a bulk update is computed again when the facts change while it is applied
+const bulkUpdateRetries = 5
+
+var errBulkUpdateContention = errors.New("facts changed on every attempt to update them")
+
+type schemaViolationsResponse struct {
+	Violations []schema.Violation `json:"violations"`
+}
+
+// Returns the environment requested with the env query parameter. An empty
+// environment means the base facts.
+func environmentQuery(ctx *gin.Context) (string, bool) {
+	env := ctx.Query("env")
+	if env == "" {
+		return "", true
+	}
+
+	if err := facts.CheckEnvironment(env); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return "", false
+	}
+
+	return env, true
+}
 
 func (r *APIRoutes) GetIdentityFacts(ctx *gin.Context) {
 	identity := ctx.Param("id")
@@ -16,7 +48,45 @@ func (r *APIRoutes) GetIdentityFacts(ctx *gin.Context) {
 		return
 	}
 
-	identityFacts, err := r.FactProvider.GetIdentityFacts(ctx, identity)
+	env, ok := environmentQuery(ctx)
+	if !ok {
+		return
+	}
+
+	var identityFacts facts.Facts
+	var err error
+	rawAt := ctx.Query("at")
+	if rawAt != "" {
+		if env != "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("at cannot be combined with env"))
+			return
+		}
+
+		at, parseErr := time.Parse(time.RFC3339, rawAt)
+		if parseErr != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("at must be an RFC3339 timestamp"))
+			return
+		}
+
+		identityFacts, err = r.HistoryService.GetIdentityFactsAt(ctx.Request.Context(), r.FactProvider, identity, at)
+	} else {
+		identityFacts, err = facts.GetIdentityFactsInEnvironment(ctx.Request.Context(), r.FactProvider, identity, env)
+	}
+
+	resolved, _ := strconv.ParseBool(ctx.Query("resolved"))
+	if resolved && errors.Is(err, facts.ErrIdentityNotFound) {
+		// Identities without facts of their own can still inherit from groups
+		hasGroups, groupsErr := r.GroupService.HasGroups(identity)
+		if groupsErr != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, groupsErr)
+			return
+		}
+
+		if hasGroups {
+			identityFacts, err = facts.Facts{}, nil
+		}
+	}
+
 	if err != nil {
 		if errors.Is(err, facts.ErrIdentityNotFound) {
 			ctx.AbortWithError(http.StatusNotFound, err)
@@ -26,7 +96,35 @@ func (r *APIRoutes) GetIdentityFacts(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, identityFacts)
+	if !resolved {
+		// Only the stored base facts have a version that can be sent back with If-Match
+		if env == "" && rawAt == "" {
+			ctx.Header("ETag", etag(facts.Version(identityFacts)))
+		}
+
+		ctx.JSON(http.StatusOK, identityFacts)
+		return
+	}
+
+	resolvedFacts, err := r.GroupService.Resolve(ctx.Request.Context(), identity, identityFacts)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if env != "" {
+		overrides, err := r.FactProvider.GetIdentityEnvironmentFacts(ctx.Request.Context(), identity, env)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		for key := range overrides {
+			resolvedFacts.Sources[key] = "environment:" + env
+		}
+	}
+
+	ctx.JSON(http.StatusOK, resolvedFacts)
 }
 
 func (r *APIRoutes) GetIdentityFact(ctx *gin.Context) {
@@ -42,25 +140,44 @@ func (r *APIRoutes) GetIdentityFact(ctx *gin.Context) {
 		return
 	}
 
-	facts, err := r.FactProvider.GetIdentityFacts(ctx, identity)
+	env, ok := environmentQuery(ctx)
+	if !ok {
+		return
+	}
+
+	identityFacts, err := facts.GetIdentityFactsInEnvironment(ctx.Request.Context(), r.FactProvider, identity, env)
 	if err != nil {
+		if errors.Is(err, facts.ErrIdentityNotFound) {
+			ctx.AbortWithError(http.StatusNotFound, err)
+			return
+		}
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	v, ok := facts[key]
+	v, ok := identityFacts[key]
 	if !ok {
 		ctx.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, map[string]string{
+	// The version covers every fact of the identity not just this one
+	if env == "" {
+		ctx.Header("ETag", etag(facts.Version(identityFacts)))
+	}
+
+	ctx.JSON(http.StatusOK, facts.Facts{
 		key: v,
 	})
 }
 
 type setIdentityFactRequest struct {
-	Value string `json:"value"`
+	// Any JSON value other than null. The type of the value is kept when read back.
+	Value facts.Fact `json:"value"`
+
+	// Deletes the fact after the duration e.g. 1h30m or at the time. At most one can be set.
+	TTL       string     `json:"ttl"`
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 func (r *APIRoutes) SetIdentityFact(ctx *gin.Context) {
@@ -76,17 +193,71 @@ func (r *APIRoutes) SetIdentityFact(ctx *gin.Context) {
 		return
 	}
 
+	env, ok := environmentQuery(ctx)
+	if !ok {
+		return
+	}
+
+	tags := ifMatch(ctx)
+	if tags != nil && env != "" {
+		ctx.AbortWithError(http.StatusBadRequest, errIfMatchWithEnv)
+		return
+	}
+
 	var body setIdentityFactRequest
 	if err := ctx.ShouldBindJSON(&body); err != nil {
 		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	err := r.FactProvider.SetIdentityFact(ctx, identity, key, body.Value)
+	// A missing value is left empty and no provider stores empty values
+	if body.Value.Value == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("value must not be empty"))
+		return
+	}
+
+	expiresAt, err := schedule.ExpiresAt(time.Now(), body.TTL, body.ExpiresAt)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	violations, err := r.SchemaService.ValidateFact(identity, key, body.Value)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+
+	if len(violations) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, schemaViolationsResponse{Violations: violations})
+		return
+	}
+
+	// The expiration replaces the previous one as part of recording the write
+	writeCtx := ctx.Request.Context()
+	if expiresAt != nil {
+		writeCtx = schedule.WithExpiration(writeCtx, identity, env, key, *expiresAt)
+	}
+
+	if env != "" {
+		err = r.FactProvider.SetIdentityEnvironmentFact(writeCtx, identity, env, key, body.Value)
+	} else if tags != nil {
+		version, ok := r.checkFactsIfMatch(ctx, identity, tags)
+		if !ok {
+			return
+		}
+		err = r.FactProvider.UpdateIdentityFactsIfMatch(writeCtx, identity, version, facts.Facts{key: body.Value}, nil)
+	} else {
+		err = r.FactProvider.SetIdentityFact(writeCtx, identity, key, body.Value)
+	}
+	if err != nil {
+		if errors.Is(err, facts.ErrVersionMismatch) {
+			ctx.AbortWithError(http.StatusPreconditionFailed, err)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 }
 
 func (r *APIRoutes) DeleteIdentityFact(ctx *gin.Context) {
@@ -102,9 +273,260 @@ func (r *APIRoutes) DeleteIdentityFact(ctx *gin.Context) {
 		return
 	}
 
-	err := r.FactProvider.DeleteIdentityFact(ctx, identity, key)
+	env, ok := environmentQuery(ctx)
+	if !ok {
+		return
+	}
+
+	tags := ifMatch(ctx)
+	if tags != nil && env != "" {
+		ctx.AbortWithError(http.StatusBadRequest, errIfMatchWithEnv)
+		return
+	}
+
+	// Deleting an override falls back to the base fact so required facts stay set
+	if env != "" {
+		err := r.FactProvider.DeleteIdentityEnvironmentFact(ctx.Request.Context(), identity, env, key)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		return
+	}
+
+	violations, err := r.SchemaService.ValidateDelete(identity, key)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(violations) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, schemaViolationsResponse{Violations: violations})
+		return
+	}
+
+	if tags != nil {
+		version, ok := r.checkFactsIfMatch(ctx, identity, tags)
+		if !ok {
+			return
+		}
+		err = r.FactProvider.UpdateIdentityFactsIfMatch(ctx.Request.Context(), identity, version, nil, []string{key})
+	} else {
+		err = r.FactProvider.DeleteIdentityFact(ctx.Request.Context(), identity, key)
+	}
+	if err != nil {
+		if errors.Is(err, facts.ErrVersionMismatch) {
+			ctx.AbortWithError(http.StatusPreconditionFailed, err)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Returns the environments the identity has overrides in
+func (r *APIRoutes) GetIdentityEnvironments(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	envs, err := r.FactProvider.GetIdentityEnvironments(ctx.Request.Context(), identity)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
-}
\ No newline at end of file
+
+	ctx.JSON(http.StatusOK, envs)
+}
+
+// Computes a batch of changes from the current facts of the identity, validates and applies
+// them then responds with the resulting facts. The changes are only applied while the facts are
+// still the ones they were computed from and are computed again when another writer changed them
+// first. When tags is set the facts must also still match one of them.
+func (r *APIRoutes) updateIdentityFacts(ctx *gin.Context, identity string, tags ifMatchTags, changes func(current facts.Facts) (facts.Facts, []string, error)) {
+	for range bulkUpdateRetries {
+		current, ok := r.currentIdentityFacts(ctx, identity)
+		if !ok {
+			return
+		}
+
+		// Fail before validating when the facts have already changed
+		currentVersion := facts.Version(current)
+		if !checkIfMatch(ctx, tags, currentVersion, len(current) > 0, facts.ErrVersionMismatch) {
+			return
+		}
+
+		set, remove, err := changes(current)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(set)) {
+			if set[key].Value == "" {
+				ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("value of %s must not be empty", key))
+				return
+			}
+		}
+
+		violations, err := r.SchemaService.ValidateUpdate(identity, set, remove)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if len(violations) > 0 {
+			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, schemaViolationsResponse{Violations: violations})
+			return
+		}
+
+		err = r.FactProvider.UpdateIdentityFactsIfMatch(ctx.Request.Context(), identity, currentVersion, set, remove)
+		if errors.Is(err, facts.ErrVersionMismatch) {
+			if tags != nil {
+				ctx.AbortWithError(http.StatusPreconditionFailed, err)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		// The version matched so current is exactly what the changes were applied to
+		result := maps.Clone(current)
+		maps.Copy(result, set)
+		for _, key := range remove {
+			delete(result, key)
+		}
+
+		ctx.Header("ETag", etag(facts.Version(result)))
+		ctx.JSON(http.StatusOK, result)
+		return
+	}
+
+	ctx.AbortWithError(http.StatusConflict, errBulkUpdateContention)
+}
+
+// Returns the current facts of the identity treating a missing identity as having no facts
+func (r *APIRoutes) currentIdentityFacts(ctx *gin.Context, identity string) (facts.Facts, bool) {
+	current, err := r.FactProvider.GetIdentityFacts(ctx.Request.Context(), identity)
+	if err != nil && !errors.Is(err, facts.ErrIdentityNotFound) {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	if current == nil {
+		current = facts.Facts{}
+	}
+
+	return current, true
+}
+
+// Checks the If-Match tags against the current facts of the identity and returns their version.
+// Writes made after the check must be conditional on the returned version.
+func (r *APIRoutes) checkFactsIfMatch(ctx *gin.Context, identity string, tags ifMatchTags) (string, bool) {
+	current, ok := r.currentIdentityFacts(ctx, identity)
+	if !ok {
+		return "", false
+	}
+
+	version := facts.Version(current)
+	if !checkIfMatch(ctx, tags, version, len(current) > 0, facts.ErrVersionMismatch) {
+		return "", false
+	}
+
+	return version, true
+}
+
+// Replaces all facts of the identity with the facts in the body
+func (r *APIRoutes) ReplaceIdentityFacts(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	if ctx.Query("env") != "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("env is only supported when setting or deleting a single fact"))
+		return
+	}
+
+	var body facts.Facts
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	r.updateIdentityFacts(ctx, identity, ifMatch(ctx), func(current facts.Facts) (facts.Facts, []string, error) {
+		remove := []string{}
+		for key := range current {
+			if _, ok := body[key]; !ok {
+				remove = append(remove, key)
+			}
+		}
+		slices.Sort(remove)
+
+		return body, remove, nil
+	})
+}
+
+// Applies an RFC 7396 merge patch to the facts of the identity. Null deletes a fact.
+func (r *APIRoutes) PatchIdentityFacts(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	if ctx.Query("env") != "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("env is only supported when setting or deleting a single fact"))
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&patch); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("body must be a JSON object"))
+		return
+	}
+
+	r.updateIdentityFacts(ctx, identity, ifMatch(ctx), func(current facts.Facts) (facts.Facts, []string, error) {
+		set, remove, err := facts.MergePatch(current, patch)
+		if err != nil {
+			return nil, nil, err
+		}
+		slices.Sort(remove)
+
+		return set, remove, nil
+	})
+}
+
+// Returns the recorded changes of the identity newest first
+func (r *APIRoutes) GetIdentityFactHistory(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit < 0 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("limit must be a positive number"))
+		return
+	}
+
+	env, ok := environmentQuery(ctx)
+	if !ok {
+		return
+	}
+
+	changes, err := r.HistoryService.GetIdentityHistory(ctx.Request.Context(), identity, env, ctx.Query("key"), limit)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, changes)
+}
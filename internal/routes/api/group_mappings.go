@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"gorm.io/gorm"
+)
+
+type createGroupMappingRequest struct {
+	Provider   string `json:"provider"`
+	Group      string `json:"group"`
+	Permission string `json:"permission"`
+}
+
+func (r *APIRoutes) GetGroupMappings(ctx *gin.Context) {
+	mappings, err := r.UserDataService.GetGroupMappings()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, mappings)
+}
+
+func (r *APIRoutes) CreateGroupMapping(ctx *gin.Context) {
+	var body createGroupMappingRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.Set(audit.DetailsKey, map[string]any{"provider": body.Provider, "group": body.Group, "permission": body.Permission})
+
+	created, err := r.UserDataService.CreateGroupMapping(db.GroupMapping{
+		Provider:     body.Provider,
+		Group:        body.Group,
+		PermissionID: body.Permission,
+	})
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidGroupMapping) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, created)
+}
+
+func (r *APIRoutes) DeleteGroupMapping(ctx *gin.Context) {
+	mappingID, err := strconv.ParseUint(ctx.Param("id"), 10, 0)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid group mapping id"))
+		return
+	}
+
+	err = r.UserDataService.DeleteGroupMapping(uint(mappingID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
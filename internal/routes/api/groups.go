@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/services/groups"
+	"gorm.io/gorm"
+)
+
+type upsertGroupRequest struct {
+	Description string      `json:"description"`
+	Parents     []string    `json:"parents"`
+	Facts       facts.Facts `json:"facts"`
+}
+
+type identityGroupsRequest struct {
+	Groups []string `json:"groups"`
+}
+
+func (r *APIRoutes) GetGroups(ctx *gin.Context) {
+	identityGroups, err := r.GroupService.GetGroups()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identityGroups)
+}
+
+func (r *APIRoutes) GetGroup(ctx *gin.Context) {
+	group, err := r.GroupService.GetGroup(ctx.Param("name"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, group)
+}
+
+func (r *APIRoutes) UpsertGroup(ctx *gin.Context) {
+	var body upsertGroupRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	group, err := r.GroupService.UpsertGroup(db.IdentityGroup{
+		Name:        ctx.Param("name"),
+		Description: body.Description,
+		Parents:     body.Parents,
+		Facts:       body.Facts,
+	})
+	if err != nil {
+		if errors.Is(err, groups.ErrInvalidGroup) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, group)
+}
+
+func (r *APIRoutes) DeleteGroup(ctx *gin.Context) {
+	err := r.GroupService.DeleteGroup(ctx.Param("name"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, groups.ErrGroupInUse) {
+			ctx.AbortWithError(http.StatusConflict, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (r *APIRoutes) GetIdentityGroups(ctx *gin.Context) {
+	names, err := r.GroupService.GetIdentityGroups(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identityGroupsRequest{Groups: names})
+}
+
+func (r *APIRoutes) SetIdentityGroups(ctx *gin.Context) {
+	var body identityGroupsRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if body.Groups == nil {
+		body.Groups = []string{}
+	}
+
+	err := r.GroupService.SetIdentityGroups(ctx.Param("id"), body.Groups)
+	if err != nil {
+		if errors.Is(err, groups.ErrInvalidGroup) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, body)
+}
@@ -2,34 +2,73 @@ package api
 
 import (
 	"errors"
+	"maps"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/graytonio/flagops-data-store/internal/services/registry"
+	"github.com/graytonio/flagops-data-store/internal/services/webhook"
+	"gorm.io/gorm"
 )
 
 
 type identieiesSupportedProviders struct{
 	Facts bool `json:"facts"`
 	Secrets bool `json:"secrets"`
+	Registered bool `json:"registered"`
 }
 type getAllIdentitiesResponse struct {
 	Identities map[string]identieiesSupportedProviders `json:"identities"`
+
+	// Identities with provider data that are not registered
+	Orphaned []string `json:"orphaned"`
+}
+
+type createIdentityRequest struct {
+	ID          string            `json:"id"`
+	Description string            `json:"description"`
+	Owner       string            `json:"owner"`
+	Labels      map[string]string `json:"labels"`
+}
+
+type selectIdentitiesResponse struct {
+	Identities map[string]facts.Facts `json:"identities"`
 }
 
 func (r *APIRoutes) GetAllIdentities(ctx *gin.Context) {
-	factsIds, err := r.FactProvider.GetAllIdentities(ctx)
+	if rawSelector, ok := ctx.GetQuery("selector"); ok {
+		r.selectIdentities(ctx, rawSelector)
+		return
+	}
+
+	factsIds, err := r.FactProvider.GetAllIdentities(ctx.Request.Context())
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	secretsIds, err := r.SecretProvider.GetAllIdentities(ctx)
+	secretsIds, err := r.SecretProvider.GetAllIdentities(ctx.Request.Context())
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	registered, err := r.RegistryService.GetIdentities(ctx.Request.Context())
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
 	ids := map[string]identieiesSupportedProviders{}
+	for _, identity := range registered {
+		ids[identity.ID] = identieiesSupportedProviders{Registered: true}
+	}
+
 	for _, f := range factsIds {
 		tmp := ids[f]
 		tmp.Facts = true
@@ -44,6 +83,93 @@ func (r *APIRoutes) GetAllIdentities(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, getAllIdentitiesResponse{
 		Identities: ids,
+		Orphaned:   registry.Orphaned(registered, append(factsIds, secretsIds...)),
+	})
+}
+
+func (r *APIRoutes) CreateIdentity(ctx *gin.Context) {
+	var body createIdentityRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	identity, err := r.RegistryService.CreateIdentity(ctx.Request.Context(), db.Identity{
+		ID:          body.ID,
+		Description: body.Description,
+		Owner:       body.Owner,
+		Labels:      body.Labels,
+	})
+	if err != nil {
+		if errors.Is(err, registry.ErrInvalidIdentity) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if errors.Is(err, registry.ErrIdentityExists) {
+			ctx.AbortWithError(http.StatusConflict, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, identity)
+}
+
+func (r *APIRoutes) GetIdentity(ctx *gin.Context) {
+	identity, err := r.RegistryService.GetIdentity(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identity)
+}
+
+func (r *APIRoutes) UpdateIdentity(ctx *gin.Context) {
+	var body registry.IdentityUpdate
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	identity, err := r.RegistryService.UpdateIdentity(ctx.Request.Context(), ctx.Param("id"), body)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identity)
+}
+
+// Returns the identities matching the selector along with their facts
+func (r *APIRoutes) selectIdentities(ctx *gin.Context, rawSelector string) {
+	selector, err := facts.ParseSelector(rawSelector)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	identities, err := facts.SelectIdentities(ctx.Request.Context(), r.FactProvider, selector)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, selectIdentitiesResponse{
+		Identities: identities,
 	})
 }
 
@@ -54,15 +180,70 @@ func (r *APIRoutes) DeleteIdentity(ctx *gin.Context) {
 		return
 	}
 
-	err := r.FactProvider.DeleteIdentity(ctx, identity)
+	// The base facts are removed only while they still match so a concurrent
+	// write to the identity rejects the delete before anything is removed
+	if tags := ifMatch(ctx); tags != nil {
+		current, ok := r.currentIdentityFacts(ctx, identity)
+		if !ok {
+			return
+		}
+
+		version := facts.Version(current)
+		if !checkIfMatch(ctx, tags, version, len(current) > 0, facts.ErrVersionMismatch) {
+			return
+		}
+
+		if len(current) > 0 {
+			err := r.FactProvider.UpdateIdentityFactsIfMatch(ctx.Request.Context(), identity, version, nil, slices.Sorted(maps.Keys(current)))
+			if err != nil {
+				if errors.Is(err, facts.ErrVersionMismatch) {
+					ctx.AbortWithError(http.StatusPreconditionFailed, err)
+					return
+				}
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+
+	err := r.FactProvider.DeleteIdentity(ctx.Request.Context(), identity)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = r.SecretProvider.DeleteIdentity(ctx.Request.Context(), identity)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = r.GroupService.SetIdentityGroups(identity, nil)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = r.ScheduleService.DeleteIdentitySchedules(ctx.Request.Context(), identity)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	err = r.SecretProvider.DeleteIdentity(ctx, identity)
+	err = r.RegistryService.DeleteIdentity(ctx.Request.Context(), identity)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+
+	err = r.WebhookService.Enqueue(ctx.Request.Context(), webhook.Payload{
+		Event:    webhook.EventIdentityDelete,
+		Identity: identity,
+		Actor:    requestmeta.Caller(ctx.Request.Context()),
+		Time:     time.Now(),
+	})
+	if err != nil {
+		// The identity is already deleted so only log the failure
+		ctx.Error(err)
+	}
 }
\ No newline at end of file
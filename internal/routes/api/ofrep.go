@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/flags"
+)
+
+type ofrepRequest struct {
+	Context map[string]any `json:"context"`
+}
+
+type ofrepError struct {
+	Key          string `json:"key,omitempty"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorDetails string `json:"errorDetails,omitempty"`
+}
+
+type ofrepBulkResponse struct {
+	Flags []any `json:"flags"`
+}
+
+// Merges the facts of the identity named by the targeting key into the evaluation context.
+// Stored facts take precedence over values sent by the client.
+func (r *APIRoutes) ofrepContext(ctx context.Context, evalCtx map[string]any) (map[string]any, error) {
+	merged := maps.Clone(evalCtx)
+	if merged == nil {
+		merged = map[string]any{}
+	}
+
+	targetingKey, ok := merged["targetingKey"].(string)
+	if !ok || targetingKey == "" {
+		return merged, nil
+	}
+
+	identityFacts, err := r.FactProvider.GetIdentityFacts(ctx, targetingKey)
+	if err != nil && !errors.Is(err, facts.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	for key, fact := range identityFacts {
+		merged[key] = fact.Native()
+	}
+
+	return merged, nil
+}
+
+func ofrepErrorStatus(code string) int {
+	if code == flags.ErrorCodeFlagNotFound {
+		return http.StatusNotFound
+	}
+
+	return http.StatusBadRequest
+}
+
+func (r *APIRoutes) bindOFREPContext(ctx *gin.Context, key string) (map[string]any, bool) {
+	req := ofrepRequest{}
+	if ctx.Request.ContentLength != 0 {
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, ofrepError{Key: key, ErrorCode: "INVALID_CONTEXT", ErrorDetails: err.Error()})
+			return nil, false
+		}
+	}
+
+	evalCtx, err := r.ofrepContext(ctx.Request.Context(), req.Context)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return evalCtx, true
+}
+
+// Evaluates a single flag following the OpenFeature Remote Evaluation Protocol
+func (r *APIRoutes) OFREPEvaluateFlag(ctx *gin.Context) {
+	key := ctx.Param("key")
+
+	evalCtx, ok := r.bindOFREPContext(ctx, key)
+	if !ok {
+		return
+	}
+
+	flag, err := r.FlagSource.GetFlag(ctx.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, flags.ErrFlagNotFound) {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, ofrepError{Key: key, ErrorCode: flags.ErrorCodeFlagNotFound, ErrorDetails: err.Error()})
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := flags.Evaluate(key, *flag, evalCtx)
+	if err != nil {
+		evalErr := &flags.EvaluationError{}
+		if errors.As(err, &evalErr) {
+			ctx.AbortWithStatusJSON(ofrepErrorStatus(evalErr.Code), ofrepError{Key: key, ErrorCode: evalErr.Code, ErrorDetails: evalErr.Details})
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// Evaluates every flag following the OpenFeature Remote Evaluation Protocol.
+// Responses carry an ETag so clients can poll with If-None-Match.
+func (r *APIRoutes) OFREPEvaluateFlags(ctx *gin.Context) {
+	evalCtx, ok := r.bindOFREPContext(ctx, "")
+	if !ok {
+		return
+	}
+
+	allFlags, err := r.FlagSource.GetFlags(ctx.Request.Context())
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := ofrepBulkResponse{Flags: []any{}}
+	for _, key := range slices.Sorted(maps.Keys(allFlags)) {
+		result, err := flags.Evaluate(key, allFlags[key], evalCtx)
+		if err != nil {
+			evalErr := &flags.EvaluationError{}
+			if !errors.As(err, &evalErr) {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			// Disabled flags are left out of bulk evaluations
+			if evalErr.Code == flags.ErrorCodeFlagNotFound {
+				continue
+			}
+
+			resp.Flags = append(resp.Flags, ofrepError{Key: key, ErrorCode: evalErr.Code, ErrorDetails: evalErr.Details})
+			continue
+		}
+
+		resp.Flags = append(resp.Flags, result)
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	ctx.Header("ETag", etag)
+
+	if ctx.GetHeader("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/json", body)
+}
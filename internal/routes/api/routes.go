@@ -2,9 +2,19 @@ package api
 
 import (
 	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/events"
 	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/flags"
 	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+	"github.com/graytonio/flagops-data-store/internal/services/groups"
+	"github.com/graytonio/flagops-data-store/internal/services/history"
 	"github.com/graytonio/flagops-data-store/internal/services/jwt"
+	"github.com/graytonio/flagops-data-store/internal/services/registry"
+	"github.com/graytonio/flagops-data-store/internal/services/schedule"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"github.com/graytonio/flagops-data-store/internal/services/transfer"
+	"github.com/graytonio/flagops-data-store/internal/services/webhook"
 	"github.com/graytonio/flagops-data-store/internal/services/user"
 )
 
@@ -18,4 +28,15 @@ type APIRoutes struct {
 
 	UserDataService *user.UserDataService
 	JWTService *jwt.JWTService
+	SchemaService *schema.SchemaService
+	HistoryService *history.HistoryService
+	AuditService *audit.AuditService
+	WebhookService *webhook.WebhookService
+	TransferService *transfer.TransferService
+	GroupService *groups.GroupService
+	ScheduleService *schedule.ScheduleService
+	RegistryService *registry.RegistryService
+
+	EventBus events.Bus
+	FlagSource flags.FlagSource
 }
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/services/schedule"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"gorm.io/gorm"
+)
+
+type createFactScheduleRequest struct {
+	Key         string    `json:"key"`
+	Environment string    `json:"env"`
+	At          time.Time `json:"at"`
+
+	// The value the fact is set to. Null deletes the fact.
+	Value *facts.Fact `json:"value"`
+}
+
+// Returns the changes of the identity that have not been applied yet
+func (r *APIRoutes) GetFactSchedules(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	schedules, err := r.ScheduleService.GetSchedules(ctx.Request.Context(), identity)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedules)
+}
+
+// Schedules a fact of the identity to be set or deleted at a later time
+func (r *APIRoutes) CreateFactSchedule(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	var body createFactScheduleRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// Checked now so mistakes are reported to the caller instead of failing when applied
+	var violations []schema.Violation
+	var err error
+	if body.Value != nil {
+		violations, err = r.SchemaService.ValidateFact(identity, body.Key, *body.Value)
+	} else if body.Environment == "" {
+		violations, err = r.SchemaService.ValidateDelete(identity, body.Key)
+	}
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(violations) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, schemaViolationsResponse{Violations: violations})
+		return
+	}
+
+	created, err := r.ScheduleService.CreateSchedule(ctx.Request.Context(), identity, body.Environment, body.Key, body.Value, body.At)
+	if err != nil {
+		if errors.Is(err, schedule.ErrInvalidSchedule) || errors.Is(err, facts.ErrInvalidEnvironment) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, created)
+}
+
+// Cancels a scheduled change of the identity
+func (r *APIRoutes) DeleteFactSchedule(ctx *gin.Context) {
+	identity := ctx.Param("id")
+	if identity == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	scheduleID, err := strconv.ParseUint(ctx.Param("schedule"), 10, 0)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid schedule id"))
+		return
+	}
+
+	err = r.ScheduleService.DeleteSchedule(ctx.Request.Context(), identity, uint(scheduleID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"gorm.io/gorm"
+)
+
+func (r *APIRoutes) GetSchemas(ctx *gin.Context) {
+	schemas, err := r.SchemaService.GetSchemas()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas)
+}
+
+type upsertSchemaRequest struct {
+	Key            string   `json:"key"`
+	IdentityPrefix string   `json:"identity_prefix"`
+	Type           string   `json:"type"`
+	Enum           []string `json:"enum"`
+	Pattern        string   `json:"pattern"`
+	Required       bool     `json:"required"`
+	Description    string   `json:"description"`
+}
+
+func (r *APIRoutes) UpsertSchema(ctx *gin.Context) {
+	var body upsertSchemaRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	factSchema, err := r.SchemaService.UpsertSchema(db.FactSchema{
+		Key:            body.Key,
+		IdentityPrefix: body.IdentityPrefix,
+		Type:           body.Type,
+		Enum:           body.Enum,
+		Pattern:        body.Pattern,
+		Required:       body.Required,
+		Description:    body.Description,
+	})
+	if err != nil {
+		if errors.Is(err, schema.ErrInvalidSchema) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, factSchema)
+}
+
+func (r *APIRoutes) DeleteSchema(ctx *gin.Context) {
+	schemaID, err := strconv.ParseUint(ctx.Param("id"), 10, 0)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid schema id"))
+		return
+	}
+
+	err = r.SchemaService.DeleteSchema(uint(schemaID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Validates the facts of every identity against the current schemas
+func (r *APIRoutes) GetSchemaReport(ctx *gin.Context) {
+	report, err := r.SchemaService.ValidateAllIdentities(ctx.Request.Context(), r.FactProvider)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
@@ -16,7 +16,7 @@ func (r *APIRoutes) GetIdentitySecrets(ctx *gin.Context) {
 		return
 	}
 
-	facts, err := r.SecretProvider.GetIdentitySecrets(ctx, identity)
+	facts, err := r.SecretProvider.GetIdentitySecrets(ctx.Request.Context(), identity)
 	if err != nil {
 		if errors.Is(err, secrets.ErrIdentityNotFound) {
 			ctx.AbortWithError(http.StatusNotFound, err)
@@ -27,6 +27,7 @@ func (r *APIRoutes) GetIdentitySecrets(ctx *gin.Context) {
 		return
 	}
 
+	ctx.Header("ETag", etag(secrets.Version(facts)))
 	ctx.JSON(http.StatusOK, facts)
 }
 
@@ -43,7 +44,7 @@ func (r *APIRoutes) GetIdentitySecret(ctx *gin.Context) {
 		return
 	}
 
-	identitySecrets, err := r.SecretProvider.GetIdentitySecrets(ctx, identity)
+	identitySecrets, err := r.SecretProvider.GetIdentitySecrets(ctx.Request.Context(), identity)
 	if err != nil {
 		if errors.Is(err, secrets.ErrIdentityNotFound) {
 			ctx.AbortWithError(http.StatusNotFound, err)
@@ -60,11 +61,30 @@ func (r *APIRoutes) GetIdentitySecret(ctx *gin.Context) {
 		return
 	}
 
+	// The version covers every secret of the identity not just this one
+	ctx.Header("ETag", etag(secrets.Version(identitySecrets)))
 	ctx.JSON(http.StatusOK, map[string]string{
 		key: v,
 	})
 }
 
+// Checks the If-Match tags against the current secrets of the identity and returns their version.
+// Writes made after the check must be conditional on the returned version.
+func (r *APIRoutes) checkSecretsIfMatch(ctx *gin.Context, identity string, tags ifMatchTags) (string, bool) {
+	current, err := r.SecretProvider.GetIdentitySecrets(ctx.Request.Context(), identity)
+	if err != nil && !errors.Is(err, secrets.ErrIdentityNotFound) {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return "", false
+	}
+
+	version := secrets.Version(current)
+	if !checkIfMatch(ctx, tags, version, err == nil, secrets.ErrVersionMismatch) {
+		return "", false
+	}
+
+	return version, true
+}
+
 type setIdentitySecretRequest struct {
 	Value string `json:"value"`
 }
@@ -88,8 +108,21 @@ func (r *APIRoutes) SetIdentitySecret(ctx *gin.Context) {
 		return
 	}
 
-	err := r.SecretProvider.SetIdentitySecret(ctx, identity, key, body.Value)
+	var err error
+	if tags := ifMatch(ctx); tags != nil {
+		version, ok := r.checkSecretsIfMatch(ctx, identity, tags)
+		if !ok {
+			return
+		}
+		err = r.SecretProvider.UpdateIdentitySecretsIfMatch(ctx.Request.Context(), identity, version, secrets.Secrets{key: body.Value}, nil)
+	} else {
+		err = r.SecretProvider.SetIdentitySecret(ctx.Request.Context(), identity, key, body.Value)
+	}
 	if err != nil {
+		if errors.Is(err, secrets.ErrVersionMismatch) {
+			ctx.AbortWithError(http.StatusPreconditionFailed, err)
+			return
+		}
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -108,8 +141,21 @@ func (r *APIRoutes) DeleteIdentitySecret(ctx *gin.Context) {
 		return
 	}
 
-	err := r.SecretProvider.DeleteIdentitySecret(ctx, identity, key)
+	var err error
+	if tags := ifMatch(ctx); tags != nil {
+		version, ok := r.checkSecretsIfMatch(ctx, identity, tags)
+		if !ok {
+			return
+		}
+		err = r.SecretProvider.UpdateIdentitySecretsIfMatch(ctx.Request.Context(), identity, version, nil, []string{key})
+	} else {
+		err = r.SecretProvider.DeleteIdentitySecret(ctx.Request.Context(), identity, key)
+	}
 	if err != nil {
+		if errors.Is(err, secrets.ErrVersionMismatch) {
+			ctx.AbortWithError(http.StatusPreconditionFailed, err)
+			return
+		}
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"gorm.io/gorm"
+)
+
+type createAPITokenRequest struct {
+	ServiceAccount string   `json:"service_account"`
+	Name           string   `json:"name"`
+	Permissions    []string `json:"permissions"`
+	ExpiresInDays  int      `json:"expires_in_days"`
+}
+
+type createAPITokenResponse struct {
+	Token    string      `json:"token"`
+	APIToken db.APIToken `json:"details"`
+}
+
+func (r *APIRoutes) CreateAPIToken(ctx *gin.Context) {
+	var body createAPITokenRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if body.ServiceAccount == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("service_account must not be empty"))
+		return
+	}
+
+	if body.ExpiresInDays < 0 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("expires_in_days must not be negative"))
+		return
+	}
+
+	ctx.Set(audit.DetailsKey, map[string]any{"service_account": body.ServiceAccount, "name": body.Name, "permissions": body.Permissions})
+
+	var expiresAt *time.Time
+	if body.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		expiresAt = &expires
+	}
+
+	apiToken, token, err := r.UserDataService.CreateAPIToken(body.ServiceAccount, body.Name, body.Permissions, expiresAt)
+	if err != nil {
+		if errors.Is(err, user.ErrUnknownPermissions) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, createAPITokenResponse{
+		Token:    token,
+		APIToken: *apiToken,
+	})
+}
+
+func (r *APIRoutes) GetAPITokens(ctx *gin.Context) {
+	tokens, err := r.UserDataService.GetAPITokens()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+func (r *APIRoutes) RevokeAPIToken(ctx *gin.Context) {
+	rawTokenID := ctx.Param("id")
+	if rawTokenID == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("id parameter must not be empty"))
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(rawTokenID, 10, 0)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid token id"))
+		return
+	}
+
+	err = r.UserDataService.RevokeAPIToken(uint(tokenID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
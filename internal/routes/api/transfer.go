@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+	"github.com/graytonio/flagops-data-store/internal/services/jwt"
+	"github.com/graytonio/flagops-data-store/internal/services/transfer"
+)
+
+// Returns true if the caller holds the permission. Always true when authentication is disabled.
+func (r *APIRoutes) hasPermission(ctx *gin.Context, permission string) bool {
+	if !r.Config.UserDatabaseOptions.RequireAuth {
+		return true
+	}
+
+	user, ok := ctx.Get("user")
+	if !ok {
+		return false
+	}
+
+	claims, ok := user.(*jwt.UserClaims)
+	return ok && claims.HasPermission(permission)
+}
+
+func transferFormat(ctx *gin.Context) (string, bool) {
+	format := ctx.DefaultQuery("format", transfer.FormatJSONL)
+	if !slices.Contains(transfer.Formats, format) {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("format must be one of %v", transfer.Formats))
+		return "", false
+	}
+
+	return format, true
+}
+
+// Streams every identity in the requested format. Secrets are only included
+// when asked for and the caller can read secrets.
+func (r *APIRoutes) ExportIdentities(ctx *gin.Context) {
+	format, ok := transferFormat(ctx)
+	if !ok {
+		return
+	}
+
+	includeSecrets, err := strconv.ParseBool(ctx.DefaultQuery("secrets", "false"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("secrets must be a boolean"))
+		return
+	}
+
+	if includeSecrets && !r.hasPermission(ctx, db.SecretsRead) {
+		ctx.AbortWithError(http.StatusForbidden, errors.New("exporting secrets requires the secrets-read permission"))
+		return
+	}
+
+	ctx.Header("Content-Type", transfer.ContentType(format))
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="flagops-export.%s"`, format))
+	ctx.Status(http.StatusOK)
+
+	w, _ := transfer.NewRecordWriter(format, ctx.Writer)
+	exportedSecrets, err := r.TransferService.Export(ctx.Request.Context(), w, transfer.ExportOptions{
+		Prefix:  ctx.Query("prefix"),
+		Secrets: includeSecrets,
+	})
+
+	// Record whose secrets were read, including exports cut short
+	if includeSecrets {
+		ctx.Set(audit.DetailsKey, map[string]any{"prefix": ctx.Query("prefix"), "secret_identities": exportedSecrets})
+	}
+
+	if err != nil {
+		// The response has already started so the export is cut short
+		ctx.Error(err)
+	}
+}
+
+// Imports identities from the body and reports what changed for each identity
+func (r *APIRoutes) ImportIdentities(ctx *gin.Context) {
+	format, ok := transferFormat(ctx)
+	if !ok {
+		return
+	}
+
+	mode := ctx.DefaultQuery("mode", transfer.ModeMerge)
+	if !slices.Contains(transfer.Modes, mode) {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("mode must be one of %v", transfer.Modes))
+		return
+	}
+
+	records, err := transfer.ReadRecords(format, ctx.Request.Body)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	hasSecrets := slices.ContainsFunc(records, func(record transfer.Record) bool {
+		return record.Secrets != nil
+	})
+	if hasSecrets && !r.hasPermission(ctx, db.SecretsWrite) {
+		ctx.AbortWithError(http.StatusForbidden, errors.New("importing secrets requires the secrets-write permission"))
+		return
+	}
+
+	report, err := r.TransferService.Import(ctx.Request.Context(), records, mode)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
 	"gorm.io/gorm"
 )
 
@@ -70,6 +71,8 @@ func (r *APIRoutes) AddUserPermissions(ctx *gin.Context) {
 		return
 	}
 
+	ctx.Set(audit.DetailsKey, map[string]any{"user_id": userId, "permissions": body.Permissions})
+
 	err = r.UserDataService.AddUserPermissions(uint(userId), body.Permissions)
 	if err != nil {
 	  ctx.AbortWithError(http.StatusInternalServerError, err)
@@ -96,6 +99,8 @@ func (r *APIRoutes) RemoveUserPermissions(ctx *gin.Context) {
 		return
 	}
 
+	ctx.Set(audit.DetailsKey, map[string]any{"user_id": userId, "permissions": body.Permissions})
+
 	err = r.UserDataService.RemoveUserPermissions(uint(userId), body.Permissions)
 	if err != nil {
 	  ctx.AbortWithError(http.StatusInternalServerError, err)
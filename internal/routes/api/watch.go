@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/events"
+)
+
+// Interval comments are sent at to keep idle connections from being closed by proxies
+const watchKeepAliveInterval = 30 * time.Second
+
+func watchFilter(identity string, prefix string) func(events.Event) bool {
+	return func(event events.Event) bool {
+		if identity != "" && event.Identity != identity {
+			return false
+		}
+
+		return strings.HasPrefix(event.Identity, prefix)
+	}
+}
+
+// Streams fact changes as server sent events. Clients resume after reconnecting
+// by sending the id of the last event they received in the Last-Event-ID header.
+func (r *APIRoutes) WatchFacts(ctx *gin.Context) {
+	rawLastID := ctx.GetHeader("Last-Event-ID")
+	if rawLastID == "" {
+		rawLastID = ctx.Query("last_event_id")
+	}
+
+	var lastID uint64
+	if rawLastID != "" {
+		var err error
+		lastID, err = strconv.ParseUint(rawLastID, 10, 64)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid last event id"))
+			return
+		}
+	}
+
+	matches := watchFilter(ctx.Query("identity"), ctx.Query("prefix"))
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	sub := r.EventBus.Subscribe(ctx.Request.Context(), lastID)
+
+	keepAlive := time.NewTicker(watchKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	fmt.Fprint(ctx.Writer, "retry: 3000\n\n")
+	ctx.Writer.Flush()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(ctx.Writer, ": keep-alive\n\n")
+			ctx.Writer.Flush()
+		case event, ok := <-sub:
+			if !ok {
+				// The subscriber fell behind, the client reconnects and resumes from its last event
+				return
+			}
+
+			// Resets apply to every identity since the missed events are unknown
+			if event.Operation != events.OperationReset && !matches(event) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				ctx.Error(err)
+				return
+			}
+
+			fmt.Fprintf(ctx.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Operation, data)
+			ctx.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/webhook"
+	"gorm.io/gorm"
+)
+
+type createWebhookRequest struct {
+	URL            string   `json:"url"`
+	Events         []string `json:"events"`
+	IdentityPrefix string   `json:"identity_prefix"`
+	Secret         string   `json:"secret"`
+	Description    string   `json:"description"`
+}
+
+type createWebhookResponse struct {
+	Secret  string     `json:"secret"`
+	Webhook db.Webhook `json:"details"`
+}
+
+func (r *APIRoutes) CreateWebhook(ctx *gin.Context) {
+	var body createWebhookRequest
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	created, err := r.WebhookService.CreateWebhook(db.Webhook{
+		URL:            body.URL,
+		Events:         body.Events,
+		IdentityPrefix: body.IdentityPrefix,
+		Secret:         body.Secret,
+		Description:    body.Description,
+	})
+	if err != nil {
+		if errors.Is(err, webhook.ErrInvalidWebhook) {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, createWebhookResponse{
+		Secret:  created.Secret,
+		Webhook: *created,
+	})
+}
+
+func (r *APIRoutes) GetWebhooks(ctx *gin.Context) {
+	webhooks, err := r.WebhookService.GetWebhooks()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, webhooks)
+}
+
+func (r *APIRoutes) DeleteWebhook(ctx *gin.Context) {
+	webhookID, err := strconv.ParseUint(ctx.Param("id"), 10, 0)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid webhook id"))
+		return
+	}
+
+	err = r.WebhookService.DeleteWebhook(uint(webhookID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (r *APIRoutes) GetWebhookDeliveries(ctx *gin.Context) {
+	webhookID, err := strconv.ParseUint(ctx.Param("id"), 10, 0)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid webhook id"))
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("limit must be a positive number"))
+		return
+	}
+
+	deliveries, err := r.WebhookService.GetDeliveries(uint(webhookID), limit)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/jwt"
+	"github.com/graytonio/flagops-data-store/internal/services/oidc"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
+	"github.com/sirupsen/logrus"
+)
+
+// Returns the groups of a user who just logged in
+func (r *Routes) loginGroups(ctx context.Context, user goth.User) ([]string, error) {
+	if user.Provider == "github" {
+		if !r.Config.OAuthOptions.GithubGroups {
+			return []string{}, nil
+		}
+		return githubGroups(ctx, user.AccessToken)
+	}
+
+	provider, err := goth.GetProvider(user.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	oidcProvider, ok := provider.(*oidc.Provider)
+	if !ok {
+		return []string{}, nil
+	}
+
+	return oidcProvider.Groups(user.RawData), nil
+}
+
+// CurrentGroups implements jwt.GroupSource. Groups are looked up at the identity
+// provider with the tokens stored on login. When that is not possible the groups
+// from the last login are kept so changed group mappings still apply.
+func (r *Routes) CurrentGroups(ctx context.Context, user *db.User) ([]string, error) {
+	accessToken, storedRefreshToken, err := r.UserDataService.SSOTokens(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.SSOProvider == "github" {
+		if !r.Config.OAuthOptions.GithubGroups || accessToken == "" {
+			return user.Groups, nil
+		}
+		return githubGroups(ctx, accessToken)
+	}
+
+	provider, err := goth.GetProvider(user.SSOProvider)
+	if err != nil {
+		// The provider is no longer enabled
+		return user.Groups, nil
+	}
+
+	oidcProvider, ok := provider.(*oidc.Provider)
+	if !ok || storedRefreshToken == "" {
+		return user.Groups, nil
+	}
+
+	claims, refreshToken, err := oidcProvider.RefreshClaims(ctx, storedRefreshToken)
+	if err != nil && !errors.Is(err, oidc.ErrMissingIDToken) {
+		if oidc.IsRevoked(err) {
+			return nil, fmt.Errorf("%w: user %d: %w", jwt.ErrAccessRevoked, user.ID, err)
+		}
+		return nil, fmt.Errorf("could not refresh groups of user %d: %w", user.ID, err)
+	}
+
+	if refreshToken != storedRefreshToken {
+		err := r.UserDataService.SetSSOTokens(user, accessToken, refreshToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if claims == nil {
+		logrus.WithField("oauth_provider", user.SSOProvider).Debug("issuer did not return an id token on refresh keeping groups from last login")
+		return user.Groups, nil
+	}
+
+	return oidcProvider.Groups(claims), nil
+}
+
+// Requests to the GitHub API are made while refreshing access tokens so they must not hang
+var githubClient = &http.Client{Timeout: 10 * time.Second}
+
+type githubTeam struct {
+	Slug         string `json:"slug"`
+	Organization struct {
+		Login string `json:"login"`
+	} `json:"organization"`
+}
+
+type githubOrganization struct {
+	Login string `json:"login"`
+}
+
+// Returns the organizations of a GitHub user and their teams as <org>/<team>
+func githubGroups(ctx context.Context, accessToken string) ([]string, error) {
+	apiURL := strings.TrimSuffix(github.ProfileURL, "/user")
+
+	orgs := []githubOrganization{}
+	err := githubList(ctx, apiURL+"/user/orgs", accessToken, &orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := []githubTeam{}
+	err = githubList(ctx, apiURL+"/user/teams", accessToken, &teams)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, org := range orgs {
+		groups = append(groups, org.Login)
+	}
+
+	for _, team := range teams {
+		groups = append(groups, team.Organization.Login+"/"+team.Slug)
+	}
+
+	return groups, nil
+}
+
+// Fetches every page of a GitHub list endpoint
+func githubList[T any](ctx context.Context, url string, accessToken string, result *[]T) error {
+	for page := 1; ; page++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?per_page=100&page=%d", url, page), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		res, err := githubClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			err := fmt.Errorf("unexpected status %d from %s", res.StatusCode, url)
+
+			// GitHub also answers 403 when the rate limit is exceeded
+			rateLimited := res.Header.Get("X-RateLimit-Remaining") == "0" || res.Header.Get("Retry-After") != ""
+			if res.StatusCode == http.StatusUnauthorized || (res.StatusCode == http.StatusForbidden && !rateLimited) {
+				return fmt.Errorf("%w: %w", jwt.ErrAccessRevoked, err)
+			}
+			return err
+		}
+
+		items := []T{}
+		err = json.NewDecoder(res.Body).Decode(&items)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		*result = append(*result, items...)
+		if len(items) < 100 {
+			return nil
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+	"github.com/graytonio/flagops-data-store/internal/services/jwt"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,6 +26,131 @@ func ErrorLogger() gin.HandlerFunc {
 	}
 }
 
+// Attaches a request id to the request context so it can be traced through the providers.
+// An id passed in the X-Request-ID header is reused otherwise a new one is generated.
+func RequestMetadata() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetHeader("X-Request-ID")
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+
+		ctx.Header("X-Request-ID", requestID)
+		ctx.Request = ctx.Request.WithContext(requestmeta.WithRequestID(ctx.Request.Context(), requestID))
+		ctx.Next()
+	}
+}
+
+// Returns true if the request writes data or reads secrets
+func shouldAudit(ctx *gin.Context) bool {
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if ctx.FullPath() == "/api/export" {
+			includeSecrets, _ := strconv.ParseBool(ctx.Query("secrets"))
+			return includeSecrets
+		}
+		return strings.HasPrefix(ctx.FullPath(), "/api/secret")
+	default:
+		// Batch fact reads are sent as POST but do not change anything
+		return ctx.FullPath() != "/api/fact/_batch"
+	}
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return audit.OutcomeDenied
+	case status >= http.StatusBadRequest:
+		return audit.OutcomeFailure
+	default:
+		return audit.OutcomeSuccess
+	}
+}
+
+// Records an audit event for every request that writes data or reads secrets.
+// Must be registered before the auth middleware so denied requests are recorded too.
+func (r *Routes) AuditLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		if !shouldAudit(ctx) {
+			return
+		}
+
+		key := ctx.Param("fact")
+		if key == "" {
+			key = ctx.Param("secret")
+		}
+
+		identity := ""
+		if strings.HasPrefix(ctx.FullPath(), "/api/fact/") || strings.HasPrefix(ctx.FullPath(), "/api/secret/") || strings.HasPrefix(ctx.FullPath(), "/api/identity/") {
+			identity = ctx.Param("id")
+		}
+
+		details := ctx.GetStringMap(audit.DetailsKey)
+		if len(details) == 0 && identity == "" {
+			// Keep the target of calls on other resources such as users or tokens
+			if id := ctx.Param("id"); id != "" {
+				details = map[string]any{"id": id}
+			}
+		}
+
+		// The auth middleware replaces the request so the caller is read after the handlers ran
+		requestCtx := ctx.Request.Context()
+		err := r.AuditService.Record(requestCtx, db.AuditEvent{
+			Actor:     requestmeta.Caller(requestCtx),
+			Action:    ctx.Request.Method + " " + ctx.FullPath(),
+			Identity:  identity,
+			Key:       key,
+			Outcome:   auditOutcome(ctx.Writer.Status()),
+			Status:    ctx.Writer.Status(),
+			SourceIP:  ctx.ClientIP(),
+			RequestID: requestmeta.RequestID(requestCtx),
+			Details:   details,
+		})
+		if err != nil {
+			logrus.WithFields(requestmeta.LogFields(requestCtx)).WithError(err).Error("could not record audit event")
+		}
+	}
+}
+
+// Stores the authenticated user on the gin context and as the caller on the request context
+func setRequestUser(ctx *gin.Context, claims *jwt.UserClaims) {
+	ctx.Set("user", claims)
+	ctx.Request = ctx.Request.WithContext(requestmeta.WithCaller(ctx.Request.Context(), claims.Caller()))
+}
+
+// Validates the api token sent in the Authorization header if there is one otherwise
+// the session cookies. If the access token needs to be refreshed it will be returned.
+func (r *Routes) authenticate(ctx *gin.Context) (*jwt.UserClaims, string, error) {
+	if header := ctx.GetHeader("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, "", errors.New("unsupported authorization scheme")
+		}
+
+		apiToken, err := r.UserDataService.ValidateAPIToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+
+		permissions := []string{}
+		for _, p := range apiToken.Permissions {
+			permissions = append(permissions, p.ID)
+		}
+
+		return &jwt.UserClaims{
+			ServiceAccount: apiToken.ServiceAccount.Name,
+			Permissions:    permissions,
+		}, "", nil
+	}
+
+	accessToken, _ := ctx.Cookie("access-token")
+	refreshToken, _ := ctx.Cookie("refresh-token")
+
+	return r.JWTService.ValidateUserTokens(accessToken, refreshToken)
+}
+
 func (r *Routes) RequiresAuth(permissions ...string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		if !r.Config.UserDatabaseOptions.RequireAuth {
@@ -26,18 +158,17 @@ func (r *Routes) RequiresAuth(permissions ...string) gin.HandlerFunc {
 			return
 		}
 		
-		accessToken, _ := ctx.Cookie("access-token")
-		refreshToken, _ := ctx.Cookie("refresh-token")
-
-		claims, newAccessToken, err := r.JWTService.ValidateUserTokens(accessToken, refreshToken)
+		claims, newAccessToken, err := r.authenticate(ctx)
 		if err != nil {
 			ctx.AbortWithError(http.StatusForbidden, err)
 			return
 		}
 
+		// Set before checking permissions so requests denied below are audited with their caller
+		setRequestUser(ctx, claims)
+
 		// Only requires authentication no permissions
 		if len(permissions) == 0 {
-			ctx.Set("user", claims)
 			if newAccessToken != "" {
 				ctx.SetCookie("access-token", newAccessToken, int(r.JWTService.AccessExpires.Seconds()), "/", "", true, true)
 			}
@@ -48,7 +179,6 @@ func (r *Routes) RequiresAuth(permissions ...string) gin.HandlerFunc {
 		// Check if user has any of the permissions listed
 		for _, p := range claims.Permissions {
 			if slices.Contains(permissions, p) || p == db.AdminPermission {
-				ctx.Set("user", claims)
 				if newAccessToken != "" {
 					ctx.SetCookie("access-token", newAccessToken, int(r.JWTService.AccessExpires.Seconds()), "/", "", true, true)
 				}
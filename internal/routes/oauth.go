@@ -1,35 +1,110 @@
 package routes
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/db"
 	"github.com/graytonio/flagops-data-store/internal/services/jwt"
+	"github.com/graytonio/flagops-data-store/internal/services/oidc"
+	"github.com/graytonio/flagops-data-store/templates/pages"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/providers/github"
 	"github.com/oov/gothic"
 	"github.com/sirupsen/logrus"
 )
 
+// Registers every enabled login provider with goth. Providers that fail to
+// initialize are logged and left out of the login page.
 func (r *Routes) InitOauthProvider() {
-	var provider goth.Provider
-	switch r.Config.OAuthOptions.Provider {
-	case "github":
-		provider = github.New(
-			r.Config.OAuthOptions.GithubClientKey,
-			r.Config.OAuthOptions.GithubClientSecret,
-			r.Config.OAuthOptions.Hostname + "/auth/github/callback",
-		)
-	default:
-		logrus.WithField("oauth_provider", r.Config.OAuthOptions.Provider).Debug("unsupported oauth provider")
-		return
+	providers := []goth.Provider{}
+	for _, name := range r.Config.OAuthOptions.EnabledProviders() {
+		callbackURL := r.Config.OAuthOptions.Hostname + "/auth/" + name + "/callback"
+
+		if name == "github" {
+			scopes := []string{}
+			if r.Config.OAuthOptions.GithubGroups {
+				scopes = append(scopes, "read:org")
+			}
+
+			providers = append(providers, github.New(
+				r.Config.OAuthOptions.GithubClientKey,
+				r.Config.OAuthOptions.GithubClientSecret,
+				callbackURL,
+				scopes...,
+			))
+			continue
+		}
+
+		opts := r.Config.OAuthOptions.OIDC[name]
+		if opts.IssuerURL == "" {
+			logrus.WithField("oauth_provider", name).Error("oidc provider has no issuer url configured")
+			continue
+		}
+
+		provider, err := oidc.NewProvider(context.Background(), name, oidc.Options{
+			IssuerURL:     opts.IssuerURL,
+			ClientID:      opts.ClientID,
+			ClientSecret:  opts.ClientSecret,
+			CallbackURL:   callbackURL,
+			Scopes:        opts.Scopes,
+			UsernameClaim: opts.UsernameClaim,
+			EmailClaim:    opts.EmailClaim,
+			GroupsClaim:   opts.GroupsClaim,
+		})
+		if err != nil {
+			logrus.WithField("oauth_provider", name).WithError(err).Error("could not initialize oidc provider")
+			continue
+		}
+		providers = append(providers, provider)
+	}
+
+	for _, provider := range providers {
+		r.loginProviders = append(r.loginProviders, pages.LoginProvider{
+			Name:        provider.Name(),
+			DisplayName: r.providerDisplayName(provider.Name()),
+		})
+	}
+	goth.UseProviders(providers...)
+}
+
+func (r *Routes) providerDisplayName(name string) string {
+	if name == "github" {
+		return "GitHub"
+	}
+
+	if displayName := r.Config.OAuthOptions.OIDC[name].DisplayName; displayName != "" {
+		return displayName
 	}
-	goth.UseProviders(provider)
+	return name
+}
+
+// Returns the providers shown on the login page
+func (r *Routes) LoginProviders() []pages.LoginProvider {
+	return r.loginProviders
+}
+
+// Returns the provider named in the route. The login route without a provider
+// uses the first enabled provider.
+func (r *Routes) requestedProvider(ctx *gin.Context) (string, bool) {
+	name := ctx.Param("provider")
+	for _, provider := range r.loginProviders {
+		if name == "" || provider.Name == name {
+			return provider.Name, true
+		}
+	}
+	return "", false
 }
 
 func (r *Routes) OauthLogin(ctx *gin.Context) {
-	err := gothic.BeginAuth(r.Config.OAuthOptions.Provider, ctx.Writer, ctx.Request)
+	provider, ok := r.requestedProvider(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	err := gothic.BeginAuth(provider, ctx.Writer, ctx.Request)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -37,7 +112,13 @@ func (r *Routes) OauthLogin(ctx *gin.Context) {
 }
 
 func (r *Routes) OauthCallback(ctx *gin.Context) {
-	user, err := gothic.CompleteAuth(r.Config.OAuthOptions.Provider, ctx.Writer, ctx.Request)
+	provider, ok := r.requestedProvider(ctx)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	user, err := gothic.CompleteAuth(provider, ctx.Writer, ctx.Request)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -54,14 +135,27 @@ func (r *Routes) OauthCallback(ctx *gin.Context) {
 		return
 	}
 
-	permissions := []string{}
-	for _, p := range dbUser.Permissions {
-		permissions = append(permissions, p.ID)
+	groups, err := r.loginGroups(ctx.Request.Context(), user)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = r.UserDataService.SyncUserGroups(dbUser, groups)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = r.UserDataService.SetSSOTokens(dbUser, user.AccessToken, user.RefreshToken)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 
 	accessToken, err := r.JWTService.NewUserAccessToken(&jwt.UserClaims{
 		ID: dbUser.ID,
-		Permissions: permissions,
+		Permissions: dbUser.PermissionIDs(),
 	})
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
@@ -4,8 +4,10 @@ import (
 	"github.com/graytonio/flagops-data-store/internal/config"
 	"github.com/graytonio/flagops-data-store/internal/facts"
 	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
 	"github.com/graytonio/flagops-data-store/internal/services/jwt"
 	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"github.com/graytonio/flagops-data-store/templates/pages"
 )
 
 // TODO Test routes
@@ -18,4 +20,8 @@ type Routes struct {
 
 	UserDataService *user.UserDataService
 	JWTService *jwt.JWTService
+	AuditService *audit.AuditService
+
+	// Providers registered by InitOauthProvider
+	loginProviders []pages.LoginProvider
 }
\ No newline at end of file
@@ -3,12 +3,14 @@ package ui
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
 	"github.com/graytonio/flagops-data-store/internal/utils"
 	"github.com/graytonio/flagops-data-store/templates/components"
 	"github.com/graytonio/flagops-data-store/templates/pages"
@@ -20,7 +22,15 @@ func SendHTMXError(ctx *gin.Context, code int, message string) {
 }
 
 func (r *UIRoutes) IdentityFactsTable(ctx *gin.Context) {
-	identityFacts, err := r.FactProvider.GetIdentityFacts(ctx, ctx.Param("id"))
+	env := ctx.Query("env")
+	if env != "" {
+		if err := facts.CheckEnvironment(env); err != nil {
+			SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	identityFacts, err := facts.GetIdentityFactsInEnvironment(ctx.Request.Context(), r.FactProvider, ctx.Param("id"), env)
 	if err != nil {
 		if errors.Is(err, facts.ErrIdentityNotFound) {
 			SendHTMXError(ctx, http.StatusNotFound, fmt.Sprintf("%s not found", ctx.Param("id")))
@@ -30,9 +40,20 @@ func (r *UIRoutes) IdentityFactsTable(ctx *gin.Context) {
 		return
 	}
 
+	overrides := facts.Facts{}
+	if env != "" {
+		overrides, err = r.FactProvider.GetIdentityEnvironmentFacts(ctx.Request.Context(), ctx.Param("id"), env)
+		if err != nil {
+			SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	ctx.HTML(http.StatusOK, "", pages.IdentityFacts(pages.IdentityFactsViewData{
-		Identity: ctx.Param("id"),
-		Facts:    identityFacts,
+		Identity:    ctx.Param("id"),
+		Facts:       identityFacts,
+		Environment: env,
+		Overrides:   overrides,
 	}))
 }
 
@@ -40,22 +61,33 @@ func (r *UIRoutes) EditIdentityFactRowForm(ctx *gin.Context) {
 	id := ctx.Param("id")
 	fact := ctx.Param("fact")
 	
-	facts, err := r.FactProvider.GetIdentityFacts(ctx, id)
-	if err != nil {
+	env := ctx.Query("env")
+	if env != "" {
+		if err := facts.CheckEnvironment(env); err != nil {
+			SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Identities that only have overrides have no base facts
+	current, err := facts.GetIdentityFactsInEnvironment(ctx.Request.Context(), r.FactProvider, id, env)
+	if err != nil && !errors.Is(err, facts.ErrIdentityNotFound) {
 		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	ctx.HTML(http.StatusOK, "", pages.EditRow(id, fact, facts[fact]))
+	ctx.HTML(http.StatusOK, "", pages.EditRow(id, env, fact, current[fact]))
 }
 
 type identityFactEdit struct {
-	NewValue string `form:"value"`
+	NewValue string         `form:"value"`
+	Type     facts.FactType `form:"type"`
 }
 
 func (r *UIRoutes) EditIdentityFactRow(ctx *gin.Context) {
 	id := ctx.Param("id")
 	fact := ctx.Param("fact")
+	env := ctx.Query("env")
 
 	var data identityFactEdit
 
@@ -64,16 +96,86 @@ func (r *UIRoutes) EditIdentityFactRow(ctx *gin.Context) {
 		return
 	}
 
-	if err := r.FactProvider.SetIdentityFact(ctx, id, fact, data.NewValue); err != nil {
+	value, err := facts.ParseFact(data.Type, data.NewValue)
+	if err != nil {
+		SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if value.Value == "" {
+		SendHTMXError(ctx, http.StatusBadRequest, "value must not be empty")
+		return
+	}
+
+	violations, err := r.SchemaService.ValidateFact(id, fact, value)
+	if err != nil {
+		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(violations) > 0 {
+		messages := []string{}
+		for _, v := range violations {
+			messages = append(messages, v.String())
+		}
+		SendHTMXError(ctx, http.StatusUnprocessableEntity, strings.Join(messages, "; "))
+		return
+	}
+
+	if env != "" {
+		err = r.FactProvider.SetIdentityEnvironmentFact(ctx.Request.Context(), id, env, fact, value)
+	} else {
+		err = r.FactProvider.SetIdentityFact(ctx.Request.Context(), id, fact, value)
+	}
+	if err != nil {
+		if errors.Is(err, facts.ErrInvalidEnvironment) {
+			SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
 		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	ctx.HTML(http.StatusOK, "", pages.FactRow(id, fact, data.NewValue))
+	ctx.HTML(http.StatusOK, "", pages.FactRow(id, env, fact, value, env != ""))
+}
+
+// Removes the override of the fact in the environment and shows the base value again
+func (r *UIRoutes) ResetIdentityFactRow(ctx *gin.Context) {
+	id := ctx.Param("id")
+	fact := ctx.Param("fact")
+	env := ctx.Query("env")
+
+	err := r.FactProvider.DeleteIdentityEnvironmentFact(ctx.Request.Context(), id, env, fact)
+	if err != nil {
+		if errors.Is(err, facts.ErrInvalidEnvironment) {
+			SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	base, err := r.FactProvider.GetIdentityFacts(ctx.Request.Context(), id)
+	if err != nil && !errors.Is(err, facts.ErrIdentityNotFound) {
+		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	value, ok := base[fact]
+	if !ok {
+		// The fact only existed in the environment
+		ctx.String(http.StatusOK, "")
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "", pages.FactRow(id, env, fact, value, false))
 }
 
 type identitySearchRequest struct {
 	SearchData string `form:"search"`
+
+	// Runs the search as a fact selector instead of a name filter
+	Selector bool `form:"selector"`
 }
 
 func (r *UIRoutes) IdentitySearch(ctx *gin.Context) {
@@ -84,13 +186,31 @@ func (r *UIRoutes) IdentitySearch(ctx *gin.Context) {
 		return
 	}
 
-	factsIds, err := r.FactProvider.GetAllIdentities(ctx)
+	if searchData.Selector {
+		selector, err := facts.ParseSelector(searchData.SearchData)
+		if err != nil {
+			SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		identities, err := facts.SelectIdentities(ctx.Request.Context(), r.FactProvider, selector)
+		if err != nil {
+			SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		searchResults := slices.Sorted(maps.Keys(identities))
+		ctx.HTML(http.StatusOK, "", pages.IdentitiesSearchResults(searchResults))
+		return
+	}
+
+	factsIds, err := r.FactProvider.GetAllIdentities(ctx.Request.Context())
 	if err != nil {
 		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	secretsIds, err := r.SecretProvider.GetAllIdentities(ctx)
+	secretsIds, err := r.SecretProvider.GetAllIdentities(ctx.Request.Context())
 	if err != nil {
 		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
 		return
@@ -99,8 +219,27 @@ func (r *UIRoutes) IdentitySearch(ctx *gin.Context) {
 	identities := utils.RemoveDuplicate(append(factsIds, secretsIds...))
 
 	searchResults := slices.DeleteFunc(identities, func(id string) bool {
-		return !strings.Contains(id, searchData.SearchData) // TODO Better searching
+		return !strings.Contains(id, searchData.SearchData)
 	})
 
 	ctx.HTML(http.StatusOK, "", pages.IdentitiesSearchResults(searchResults))
 }
+
+func (r *UIRoutes) AuditEventsTable(ctx *gin.Context) {
+	var filter audit.Filter
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		SendHTMXError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := r.AuditService.GetEvents(ctx.Request.Context(), filter)
+	if err != nil {
+		SendHTMXError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "", pages.AuditEvents(pages.AuditViewData{
+		Filter: filter,
+		Page:   *page,
+	}))
+}
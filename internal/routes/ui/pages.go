@@ -15,7 +15,19 @@ func (r *UIRoutes) HomeDashboard(ctx *gin.Context) {
 }
 
 func (r *UIRoutes) IdentityFactsDashboard(ctx *gin.Context) {
+	environments, err := r.FactProvider.GetIdentityEnvironments(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "", layout.Layout(layout.DashboardLayout(
+		pages.IdentityDetailsPage(ctx.Param("id"), environments),
+	)))
+}
+
+func (r *UIRoutes) AuditDashboard(ctx *gin.Context) {
 	ctx.HTML(http.StatusOK, "", layout.Layout(layout.DashboardLayout(
-		pages.IdentityDetailsPage(ctx.Param("id")),
+		pages.AuditPage(),
 	)))
 }
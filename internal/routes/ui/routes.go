@@ -4,7 +4,9 @@ import (
 	"github.com/graytonio/flagops-data-store/internal/config"
 	"github.com/graytonio/flagops-data-store/internal/facts"
 	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
 	"github.com/graytonio/flagops-data-store/internal/services/jwt"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
 	"github.com/graytonio/flagops-data-store/internal/services/user"
 )
 
@@ -18,4 +20,6 @@ type UIRoutes struct {
 
 	UserDataService *user.UserDataService
 	JWTService *jwt.JWTService
+	SchemaService *schema.SchemaService
+	AuditService *audit.AuditService
 }
\ No newline at end of file
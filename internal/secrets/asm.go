@@ -2,6 +2,7 @@ package secrets
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,8 +11,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,6 +20,9 @@ var _ SecretProvider = &ASMSecretProvider{}
 
 const secretPrefix = "flagops-secret-"
 
+// Stage new versions are written with before AWSCURRENT is moved to them
+const asmPendingStage = "flagops-pending"
+
 // A secrets provider based on AWS Secrets Manager
 type ASMSecretProvider struct {
 	config config.SecretsProviderOptions
@@ -37,101 +41,153 @@ func (a *ASMSecretProvider) getIdentitySecretKey(id string) string {
 	return fmt.Sprintf("%s%s", secretPrefix, id)
 }
 
-func (a *ASMSecretProvider) getLogEntry(ctx *gin.Context) *logrus.Entry {
-	entry := logrus.WithFields(logrus.Fields{
-		"caller_path": ctx.FullPath(),
-		"provider":    "asm",
-		"api":         "secrets",
+func (a *ASMSecretProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "asm",
+		"api":      "secrets",
 	})
 
-	if ctx.Param("id") != "" {
-		entry = entry.WithField("id", ctx.Param("id"))
+	if id != "" {
+		entry = entry.WithField("id", id)
 	}
 
-	if ctx.Param("key") != "" {
-		entry = entry.WithField("key", ctx.Param("key"))
+	if key != "" {
+		entry = entry.WithField("key", key)
 	}
 
 	return entry
 }
 
 // GetIdentitySecrets implements SecretProvider.
-func (a *ASMSecretProvider) GetIdentitySecrets(ctx *gin.Context, id string) (Secrets, error) {
-	log := a.getLogEntry(ctx)
+func (a *ASMSecretProvider) GetIdentitySecrets(ctx context.Context, id string) (Secrets, error) {
+	log := a.getLogEntry(ctx, id, "")
 	log.Debug("fetching identity secrets from provider")
+	results, _, err := a.readIdentitySecrets(ctx, log, id)
+	return results, err
+}
+
+// Returns the current secrets of the identity with the id of the version they were read from
+func (a *ASMSecretProvider) readIdentitySecrets(ctx context.Context, log *logrus.Entry, id string) (Secrets, string, error) {
 	res, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
 		SecretId: aws.String(a.getIdentitySecretKey(id)),
 	})
 	if err != nil {
 		var missingAerr *types.ResourceNotFoundException
 		if errors.As(err, &missingAerr) { // Secret does not exist
-			return nil, ErrIdentityNotFound
+			return nil, "", ErrIdentityNotFound
 		}
 
 		var deletedAerr *types.InvalidRequestException
 		if errors.As(err, &deletedAerr) {
-			return nil, ErrIdentityNotFound
+			return nil, "", ErrIdentityNotFound
 		}
 
 		log.WithError(err).Error("could not fetch identity from provider")
-		return nil, err
+		return nil, "", err
 	}
 
 	results := Secrets{}
 	err = json.NewDecoder(bytes.NewBufferString(*res.SecretString)).Decode(&results)
 	if err != nil {
 		log.WithError(err).Error("identity not found in provider")
-		return nil, err
+		return nil, "", err
 	}
 
-	return results, nil
+	return results, aws.StringValue(res.VersionId), nil
 }
 
-// SetIdentitySecret implements SecretProvider.
-func (a *ASMSecretProvider) SetIdentitySecret(ctx *gin.Context, id string, key string, value string) error {
-	log := a.getLogEntry(ctx)
-	log.Debug("setting secret for identity")
-	currentValues, err := a.GetIdentitySecrets(ctx, id)
-	if err != nil {
-		if !errors.Is(err, ErrIdentityNotFound) {
+// Applies the update as a compare and swap on the AWSCURRENT stage. The new version
+// is written under a pending stage and AWSCURRENT is only moved to it while it still
+// points at the version that was read, so concurrent writers cannot drop each
+// other's keys. When version is set the update is only applied while the secrets
+// still match it.
+func (a *ASMSecretProvider) updateIdentitySecrets(ctx context.Context, log *logrus.Entry, id string, version *string, set Secrets, remove []string) error {
+	for i := 0; i < updateRetries; i++ {
+		current, currentVersionID, err := a.readIdentitySecrets(ctx, log, id)
+		if err != nil && !errors.Is(err, ErrIdentityNotFound) {
+			return err
+		}
+
+		if err := checkVersion(current, version); err != nil {
+			log.Debug("identity secrets version does not match")
 			return err
 		}
 
-		_, err := a.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(a.getIdentitySecretKey(id)),
-			SecretString: aws.String(fmt.Sprintf("{\"%s\":\"%s\"}\n", key, value)),
+		// Only deleting keys does not create the identity and there is nothing to delete
+		if current == nil && len(set) == 0 {
+			return nil
+		}
+
+		payload := bytes.NewBuffer([]byte{})
+		err = json.NewEncoder(payload).Encode(applyUpdate(current, set, remove))
+		if err != nil {
+			log.WithError(err).Error("malformed secret data")
+			return err
+		}
+
+		if current == nil {
+			_, err := a.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+				Name:         aws.String(a.getIdentitySecretKey(id)),
+				SecretString: aws.String(payload.String()),
+			})
+
+			var existsAerr *types.ResourceExistsException
+			if errors.As(err, &existsAerr) { // Created by another writer since it was read
+				log.Debug("identity created while updating secrets retrying")
+				continue
+			}
+			if err != nil {
+				log.WithError(err).Error("could not create new identity")
+				return err
+			}
+
+			return nil
+		}
+
+		res, err := a.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:      aws.String(a.getIdentitySecretKey(id)),
+			SecretString:  aws.String(payload.String()),
+			VersionStages: []string{asmPendingStage},
 		})
 		if err != nil {
-			log.WithError(err).Error("could not create new identity")
+			log.WithError(err).Error("could not update identity secret")
 			return err
 		}
 
-		return nil
-	}
-	currentValues[key] = value
+		_, err = a.client.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:            aws.String(a.getIdentitySecretKey(id)),
+			VersionStage:        aws.String("AWSCURRENT"),
+			MoveToVersionId:     res.VersionId,
+			RemoveFromVersionId: aws.String(currentVersionID),
+		})
 
-	payload := bytes.NewBuffer([]byte{})
-	err = json.NewEncoder(payload).Encode(currentValues)
-	if err != nil {
-		log.WithError(err).Error("malformed secret data")
-		return err
-	}
+		var movedAerr *types.InvalidParameterException
+		if errors.As(err, &movedAerr) { // AWSCURRENT was moved by another writer since it was read
+			log.Debug("identity changed while updating secrets retrying")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("could not update identity secret")
+			return err
+		}
 
-	_, err = a.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
-		SecretId:     aws.String(a.getIdentitySecretKey(id)),
-		SecretString: aws.String(payload.String()),
-	})
-	if err != nil {
-		log.WithError(err).Error("could not update identity secret")
-		return err
+		return nil
 	}
 
-	return nil
+	log.Error("could not update identity secret after max retries")
+	return errors.New("identity secrets changed too many times while updating")
+}
+
+// SetIdentitySecret implements SecretProvider.
+func (a *ASMSecretProvider) SetIdentitySecret(ctx context.Context, id string, key string, value string) error {
+	log := a.getLogEntry(ctx, id, key)
+	log.Debug("setting secret for identity")
+	return a.updateIdentitySecrets(ctx, log, id, nil, Secrets{key: value}, nil)
 }
 
 // GetAllIdentities implements SecretProvider.
-func (a *ASMSecretProvider) GetAllIdentities(ctx *gin.Context) ([]string, error) {
-	log := a.getLogEntry(ctx)
+func (a *ASMSecretProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	log := a.getLogEntry(ctx, "", "")
 	log.Debug("fetching all identities from provider")
 	ids := []string{}
 	token := ""
@@ -174,8 +230,8 @@ func (a *ASMSecretProvider) GetAllIdentities(ctx *gin.Context) ([]string, error)
 }
 
 // DeleteIdentity implements SecretProvider.
-func (a *ASMSecretProvider) DeleteIdentity(ctx *gin.Context, id string) error {
-	log := a.getLogEntry(ctx)
+func (a *ASMSecretProvider) DeleteIdentity(ctx context.Context, id string) error {
+	log := a.getLogEntry(ctx, id, "")
 	log.Debug("deleting identity")
 	_, err := a.client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
 		SecretId:             aws.String(a.getIdentitySecretKey(id)),
@@ -194,30 +250,15 @@ func (a *ASMSecretProvider) DeleteIdentity(ctx *gin.Context, id string) error {
 }
 
 // DeleteIdentitySecret implements SecretProvider.
-func (a *ASMSecretProvider) DeleteIdentitySecret(ctx *gin.Context, id string, key string) error {
-	log := a.getLogEntry(ctx)
+func (a *ASMSecretProvider) DeleteIdentitySecret(ctx context.Context, id string, key string) error {
+	log := a.getLogEntry(ctx, id, key)
 	log.Debug("deleting identity secret")
-	currentValues, err := a.GetIdentitySecrets(ctx, id)
-	if err != nil {
-		return err
-	}
-	delete(currentValues, key)
-
-	payload := bytes.NewBuffer([]byte{})
-	err = json.NewEncoder(payload).Encode(currentValues)
-	if err != nil {
-		log.WithError(err).Error("malformed secret data")
-		return err
-	}
-
-	_, err = a.client.UpdateSecret(ctx, &secretsmanager.UpdateSecretInput{
-		SecretId:     aws.String(a.getIdentitySecretKey(id)),
-		SecretString: aws.String(payload.String()),
-	})
-	if err != nil {
-		log.WithError(err).Error("could not update identity secret")
-		return err
-	}
+	return a.updateIdentitySecrets(ctx, log, id, nil, nil, []string{key})
+}
 
-	return nil
+// UpdateIdentitySecretsIfMatch implements SecretProvider.
+func (a *ASMSecretProvider) UpdateIdentitySecretsIfMatch(ctx context.Context, id string, version string, set Secrets, remove []string) error {
+	log := a.getLogEntry(ctx, id, "")
+	log.Debug("updating identity secrets if version matches")
+	return a.updateIdentitySecrets(ctx, log, id, &version, set, remove)
 }
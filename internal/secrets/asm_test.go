@@ -11,7 +11,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/docker/go-connections/nat"
-	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/config"
 	"github.com/graytonio/flagops-data-store/internal/secrets"
 	"github.com/stretchr/testify/assert"
@@ -79,7 +78,7 @@ func TestGetIdentitySecrets(t *testing.T) {
 
 	provider := secrets.NewASMSecretProvider(client, config.SecretsProviderOptions{})
 
-	secretsOutput, err := provider.GetIdentitySecrets(&gin.Context{}, "test-identity")
+	secretsOutput, err := provider.GetIdentitySecrets(context.Background(), "test-identity")
 	if assert.NoError(t, err) {
 		assert.Equal(t, secrets.Secrets{"foo": "bar", "boo": "baz"}, secretsOutput)
 	}
@@ -95,7 +94,7 @@ func TestSetIdentitySecretNoExistingSecret(t *testing.T) {
 
 	provider := secrets.NewASMSecretProvider(client, config.SecretsProviderOptions{})
 
-	err = provider.SetIdentitySecret(&gin.Context{}, "test-identity", "foo", "bar")
+	err = provider.SetIdentitySecret(context.Background(), "test-identity", "foo", "bar")
 	if assert.NoError(t, err) {
 		res, _ := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
 			SecretId: aws.String(fmt.Sprintf("flagops-secret-%s", "test-identity")),
@@ -120,7 +119,7 @@ func TestSetIdentitySecretExistingSecret(t *testing.T) {
 
 	provider := secrets.NewASMSecretProvider(client, config.SecretsProviderOptions{})
 
-	err = provider.SetIdentitySecret(&gin.Context{}, "test-identity", "foo", "bar")
+	err = provider.SetIdentitySecret(context.Background(), "test-identity", "foo", "bar")
 	if assert.NoError(t, err) {
 		res, _ := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
 			SecretId: aws.String(fmt.Sprintf("flagops-secret-%s", "test-identity")),
@@ -155,7 +154,7 @@ func TestGetAllIdenties(t *testing.T) {
 
 	provider := secrets.NewASMSecretProvider(client, config.SecretsProviderOptions{})
 
-	ids, err := provider.GetAllIdentities(&gin.Context{})
+	ids, err := provider.GetAllIdentities(context.Background())
 	if assert.NoError(t, err) {
 		assert.ElementsMatch(t, []string{"test-identity-0", "test-identity-1", "test-identity-2"}, ids)
 	}
@@ -176,7 +175,7 @@ func TestDeleteIdentitySecret(t *testing.T) {
 
 	provider := secrets.NewASMSecretProvider(client, config.SecretsProviderOptions{})
 
-	err = provider.DeleteIdentitySecret(&gin.Context{}, "test-identity", "boo")
+	err = provider.DeleteIdentitySecret(context.Background(), "test-identity", "boo")
 	if assert.NoError(t, err) {
 		res, _ := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
 			SecretId: aws.String(fmt.Sprintf("flagops-secret-%s", "test-identity")),
@@ -184,6 +183,9 @@ func TestDeleteIdentitySecret(t *testing.T) {
 
 		assert.Equal(t, "{\"foo\":\"baz\"}\n", *res.SecretString)
 	}
+
+	// Deleting from a missing identity does nothing
+	assert.NoError(t, provider.DeleteIdentitySecret(context.Background(), "missing-identity", "boo"))
 }
 
 func TestDeleteIdentity(t *testing.T) {
@@ -203,7 +205,7 @@ func TestDeleteIdentity(t *testing.T) {
 		ASMDeletionRecoveryDays: 7,
 	})
 
-	err = provider.DeleteIdentity(&gin.Context{}, "test-identity")
+	err = provider.DeleteIdentity(context.Background(), "test-identity")
 	if assert.NoError(t, err) {
 		_, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
 			SecretId: aws.String(fmt.Sprintf("flagops-secret-%s", "test-identity")),
@@ -213,3 +215,31 @@ func TestDeleteIdentity(t *testing.T) {
 		assert.ErrorAs(t, err, &aerr)
 	}
 }
+
+func TestUpdateIdentitySecretsIfMatch(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getLocalStackContainer(ctx, "us-east-1")
+	if err != nil {
+		t.Fatalf("Could not start local stack: %s", err)
+	}
+	defer closeLocalStackContainer(t, ctx, container)
+
+	client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(fmt.Sprintf("flagops-secret-%s", "test-identity")),
+		SecretString: aws.String(`{"foo": "bar"}`),
+	})
+
+	provider := secrets.NewASMSecretProvider(client, config.SecretsProviderOptions{})
+	version := secrets.Version(secrets.Secrets{"foo": "bar"})
+
+	err = provider.UpdateIdentitySecretsIfMatch(ctx, "test-identity", version, secrets.Secrets{"boo": "baz"}, nil)
+	if assert.NoError(t, err) {
+		res, err := provider.GetIdentitySecrets(ctx, "test-identity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, secrets.Secrets{"foo": "bar", "boo": "baz"}, res)
+		}
+	}
+
+	err = provider.UpdateIdentitySecretsIfMatch(ctx, "test-identity", version, nil, []string{"foo"})
+	assert.ErrorIs(t, err, secrets.ErrVersionMismatch)
+}
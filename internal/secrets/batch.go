@@ -0,0 +1,35 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+)
+
+// Providers that can read the secrets of many identities in one round trip implement this
+type BatchSecretReader interface {
+	// Returns the secrets of every identity that exists by id. Missing identities are left out.
+	GetIdentitiesSecrets(ctx context.Context, ids []string) (map[string]Secrets, error)
+}
+
+// Returns the secrets of every identity that exists by id using a single batch read
+// when the provider supports it, otherwise reading each identity in turn
+func GetIdentitiesSecrets(ctx context.Context, provider SecretProvider, ids []string) (map[string]Secrets, error) {
+	if reader, ok := provider.(BatchSecretReader); ok {
+		return reader.GetIdentitiesSecrets(ctx, ids)
+	}
+
+	result := map[string]Secrets{}
+	for _, id := range ids {
+		identitySecrets, err := provider.GetIdentitySecrets(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrIdentityNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		result[id] = identitySecrets
+	}
+
+	return result, nil
+}
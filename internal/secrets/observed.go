@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	_ SecretProvider    = &ObservedSecretProvider{}
+	_ BatchSecretReader = &ObservedSecretProvider{}
+)
+
+type ChangeOperation string
+
+const (
+	ChangeOperationSet            ChangeOperation = "set"
+	ChangeOperationDelete         ChangeOperation = "delete"
+	ChangeOperationDeleteIdentity ChangeOperation = "delete_identity"
+)
+
+// A single change to the secrets of an identity. Secret values are never included.
+type SecretChange struct {
+	Identity string
+
+	// Empty when the whole identity was deleted
+	Key       string
+	Operation ChangeOperation
+
+	// The caller responsible for the change taken from the request context
+	Actor string
+	Time  time.Time
+}
+
+// Receives every change made through an ObservedSecretProvider
+type SecretObserver interface {
+	ObserveSecretChange(ctx context.Context, change SecretChange) error
+}
+
+// Wraps a secret provider and notifies observers of every successful change
+type ObservedSecretProvider struct {
+	provider  SecretProvider
+	observers []SecretObserver
+}
+
+func NewObservedSecretProvider(provider SecretProvider, observers ...SecretObserver) *ObservedSecretProvider {
+	return &ObservedSecretProvider{
+		provider:  provider,
+		observers: observers,
+	}
+}
+
+func (o *ObservedSecretProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "observed",
+		"api":      "secrets",
+	})
+
+	if id != "" {
+		entry = entry.WithField("id", id)
+	}
+
+	if key != "" {
+		entry = entry.WithField("key", key)
+	}
+
+	return entry
+}
+
+// The change has already been stored so observer failures are logged instead of failing the request
+func (o *ObservedSecretProvider) notify(ctx context.Context, change SecretChange) {
+	change.Actor = requestmeta.Caller(ctx)
+	change.Time = time.Now()
+
+	for _, observer := range o.observers {
+		if err := observer.ObserveSecretChange(ctx, change); err != nil {
+			o.getLogEntry(ctx, change.Identity, change.Key).WithError(err).Error("could not notify observer of secret change")
+		}
+	}
+}
+
+// GetAllIdentities implements SecretProvider.
+func (o *ObservedSecretProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	return o.provider.GetAllIdentities(ctx)
+}
+
+// GetIdentitySecrets implements SecretProvider.
+func (o *ObservedSecretProvider) GetIdentitySecrets(ctx context.Context, id string) (Secrets, error) {
+	return o.provider.GetIdentitySecrets(ctx, id)
+}
+
+// GetIdentitiesSecrets implements BatchSecretReader so batch reads still reach the wrapped provider.
+func (o *ObservedSecretProvider) GetIdentitiesSecrets(ctx context.Context, ids []string) (map[string]Secrets, error) {
+	return GetIdentitiesSecrets(ctx, o.provider, ids)
+}
+
+// SetIdentitySecret implements SecretProvider.
+func (o *ObservedSecretProvider) SetIdentitySecret(ctx context.Context, id string, key string, value string) error {
+	err := o.provider.SetIdentitySecret(ctx, id, key, value)
+	if err != nil {
+		return err
+	}
+
+	o.notify(ctx, SecretChange{Identity: id, Key: key, Operation: ChangeOperationSet})
+	return nil
+}
+
+// DeleteIdentitySecret implements SecretProvider.
+func (o *ObservedSecretProvider) DeleteIdentitySecret(ctx context.Context, id string, key string) error {
+	err := o.provider.DeleteIdentitySecret(ctx, id, key)
+	if err != nil {
+		return err
+	}
+
+	o.notify(ctx, SecretChange{Identity: id, Key: key, Operation: ChangeOperationDelete})
+	return nil
+}
+
+// UpdateIdentitySecretsIfMatch implements SecretProvider.
+func (o *ObservedSecretProvider) UpdateIdentitySecretsIfMatch(ctx context.Context, id string, version string, set Secrets, remove []string) error {
+	err := o.provider.UpdateIdentitySecretsIfMatch(ctx, id, version, set, remove)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(set)) {
+		o.notify(ctx, SecretChange{Identity: id, Key: key, Operation: ChangeOperationSet})
+	}
+
+	for _, key := range remove {
+		o.notify(ctx, SecretChange{Identity: id, Key: key, Operation: ChangeOperationDelete})
+	}
+	return nil
+}
+
+// DeleteIdentity implements SecretProvider.
+func (o *ObservedSecretProvider) DeleteIdentity(ctx context.Context, id string) error {
+	err := o.provider.DeleteIdentity(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	o.notify(ctx, SecretChange{Identity: id, Operation: ChangeOperationDeleteIdentity})
+	return nil
+}
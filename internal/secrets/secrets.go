@@ -7,7 +7,6 @@ import (
 
 	awsconf "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
-	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/config"
 )
 
@@ -16,23 +15,30 @@ type Secrets map[string]string
 var (
 	ErrIdentityNotFound = errors.New("identity not found")
 	ErrSecretNotFound   = errors.New("secret not found")
+
+	// Returned by conditional updates when the secrets changed since they were read
+	ErrVersionMismatch = errors.New("secrets version does not match")
 )
 
 type SecretProvider interface {
 	// Returns a list of all available identities in the provider
-	GetAllIdentities(ctx *gin.Context) ([]string, error)
+	GetAllIdentities(ctx context.Context) ([]string, error)
 
 	// Deletes all records belonging to identity
-	DeleteIdentity(ctx *gin.Context, id string) error
+	DeleteIdentity(ctx context.Context, id string) error
 
 	// Returns all Secrets belonging to the identity
-	GetIdentitySecrets(ctx *gin.Context, id string) (Secrets, error)
+	GetIdentitySecrets(ctx context.Context, id string) (Secrets, error)
 
 	// Set the key for the given identity to the value
-	SetIdentitySecret(ctx *gin.Context, id string, key string, value string) error
+	SetIdentitySecret(ctx context.Context, id string, key string, value string) error
 
 	// Deletes the key for the given identity
-	DeleteIdentitySecret(ctx *gin.Context, id string, key string) error
+	DeleteIdentitySecret(ctx context.Context, id string, key string) error
+
+	// Sets and deletes keys for the given identity only while its secrets are still at
+	// the version returned by Version. Returns ErrVersionMismatch otherwise.
+	UpdateIdentitySecretsIfMatch(ctx context.Context, id string, version string, set Secrets, remove []string) error
 }
 
 func GetSecretsProvider(conf config.SecretsProviderOptions) (SecretProvider, error) {
@@ -44,6 +50,13 @@ func GetSecretsProvider(conf config.SecretsProviderOptions) (SecretProvider, err
 		}
 
 		return NewASMSecretProvider(secretsmanager.NewFromConfig(config), conf), nil
+	case "vault":
+		client, err := NewVaultClient(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewVaultSecretProvider(client, conf), nil
 	default:
 		return nil, fmt.Errorf("no such secret provider %s", conf.Provider)
 	}
@@ -56,7 +69,7 @@ type MockSecretsProvider struct {
 }
 
 // DeleteIdentity implements FactProvider.
-func (m *MockSecretsProvider) DeleteIdentity(ctx *gin.Context, id string) error {
+func (m *MockSecretsProvider) DeleteIdentity(ctx context.Context, id string) error {
 	if _, ok := m.SecretsDB[id]; !ok {
 		return nil
 	}
@@ -66,7 +79,7 @@ func (m *MockSecretsProvider) DeleteIdentity(ctx *gin.Context, id string) error
 }
 
 // DeleteIdentityFact implements FactProvider.
-func (m *MockSecretsProvider) DeleteIdentitySecret(ctx *gin.Context, id string, key string) error {
+func (m *MockSecretsProvider) DeleteIdentitySecret(ctx context.Context, id string, key string) error {
 	if _, ok := m.SecretsDB[id]; !ok {
 		return nil
 	}
@@ -80,7 +93,7 @@ func (m *MockSecretsProvider) DeleteIdentitySecret(ctx *gin.Context, id string,
 }
 
 // GetAllIdentities implements FactProvider.
-func (m *MockSecretsProvider) GetAllIdentities(ctx *gin.Context) ([]string, error) {
+func (m *MockSecretsProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
 	ids := []string{}
 	for k := range m.SecretsDB {
 		ids = append(ids, k)
@@ -89,7 +102,7 @@ func (m *MockSecretsProvider) GetAllIdentities(ctx *gin.Context) ([]string, erro
 }
 
 // GetIdentitySecrets implements FactProvider.
-func (m *MockSecretsProvider) GetIdentitySecrets(ctx *gin.Context, id string) (Secrets, error) {
+func (m *MockSecretsProvider) GetIdentitySecrets(ctx context.Context, id string) (Secrets, error) {
 	identitySecrets, ok := m.SecretsDB[id]
 	if !ok {
 		return nil, ErrIdentityNotFound
@@ -99,7 +112,7 @@ func (m *MockSecretsProvider) GetIdentitySecrets(ctx *gin.Context, id string) (S
 }
 
 // SetIdentityFact implements FactProvider.
-func (m *MockSecretsProvider) SetIdentitySecret(ctx *gin.Context, id string, key string, value string) error {
+func (m *MockSecretsProvider) SetIdentitySecret(ctx context.Context, id string, key string, value string) error {
 	identitySecrets, ok := m.SecretsDB[id]
 	if !ok {
 		return ErrIdentityNotFound
@@ -108,3 +121,13 @@ func (m *MockSecretsProvider) SetIdentitySecret(ctx *gin.Context, id string, key
 	identitySecrets[key] = value
 	return nil
 }
+
+// UpdateIdentitySecretsIfMatch implements SecretProvider.
+func (m *MockSecretsProvider) UpdateIdentitySecretsIfMatch(ctx context.Context, id string, version string, set Secrets, remove []string) error {
+	if err := checkVersion(m.SecretsDB[id], &version); err != nil {
+		return err
+	}
+
+	m.SecretsDB[id] = applyUpdate(m.SecretsDB[id], set, remove)
+	return nil
+}
@@ -0,0 +1,366 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/sirupsen/logrus"
+)
+
+var _ SecretProvider = &VaultSecretProvider{}
+
+// Error vault returns when a write is made with a check-and-set version that is no longer current
+const vaultCheckAndSetMismatch = "check-and-set parameter did not match the current version"
+
+// A secrets provider based on the HashiCorp Vault KV v2 secrets engine. Each
+// identity is stored as a single secret under the configured mount and path prefix.
+type VaultSecretProvider struct {
+	config config.SecretsProviderOptions
+
+	client *vault.Client
+}
+
+func NewVaultSecretProvider(client *vault.Client, config config.SecretsProviderOptions) *VaultSecretProvider {
+	return &VaultSecretProvider{
+		config: config,
+		client: client,
+	}
+}
+
+// Creates a new vault client authenticated with the method selected in the config
+func NewVaultClient(conf config.SecretsProviderOptions) (*vault.Client, error) {
+	vaultConfig := vault.DefaultConfig()
+	if conf.VaultAddress != "" {
+		vaultConfig.Address = conf.VaultAddress
+	}
+
+	client, err := vault.NewClient(vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.VaultAuthMethod {
+	case "token":
+		if conf.VaultToken != "" {
+			client.SetToken(conf.VaultToken)
+		}
+	case "approle":
+		auth, err := approle.NewAppRoleAuth(
+			conf.VaultRoleID,
+			&approle.SecretID{FromString: conf.VaultSecretID},
+			approle.WithMountPath(conf.VaultAppRoleMount),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		authInfo, err := client.Auth().Login(context.Background(), auth)
+		if err != nil {
+			return nil, err
+		}
+		if authInfo == nil {
+			return nil, errors.New("no auth info was returned after approle login")
+		}
+
+		go renewVaultLogin(client, auth, authInfo)
+	default:
+		return nil, fmt.Errorf("no such vault auth method %s", conf.VaultAuthMethod)
+	}
+
+	return client, nil
+}
+
+// Keeps the vault token alive for as long as it can be renewed and logs in
+// again once the token reaches its max TTL
+func renewVaultLogin(client *vault.Client, auth vault.AuthMethod, authInfo *vault.Secret) {
+	log := logrus.WithFields(logrus.Fields{
+		"provider": "vault",
+		"api":      "secrets",
+	})
+
+	for {
+		if authInfo.Auth == nil || !authInfo.Auth.Renewable {
+			log.Debug("vault token is not renewable")
+			return
+		}
+
+		watcher, err := client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{
+			Secret: authInfo,
+		})
+		if err != nil {
+			log.WithError(err).Error("could not start vault token lifetime watcher")
+			return
+		}
+
+		go watcher.Start()
+		for done := false; !done; {
+			select {
+			case err := <-watcher.DoneCh():
+				if err != nil {
+					log.WithError(err).Error("could not renew vault token")
+				}
+				done = true
+			case <-watcher.RenewCh():
+				log.Debug("renewed vault token")
+			}
+		}
+		watcher.Stop()
+
+		authInfo, err = client.Auth().Login(context.Background(), auth)
+		if err != nil {
+			log.WithError(err).Error("could not log in to vault")
+			return
+		}
+		if authInfo == nil {
+			log.Error("no auth info was returned after vault login")
+			return
+		}
+	}
+}
+
+// Checks the id names a single secret under the path prefix
+func checkVaultIdentity(id string) error {
+	if id == "" {
+		return errors.New("id is blank")
+	}
+
+	if strings.Contains(id, "/") || strings.Contains(id, "..") {
+		return fmt.Errorf("id %q can not contain / or ..", id)
+	}
+
+	return nil
+}
+
+func (v *VaultSecretProvider) getIdentitySecretPath(id string) string {
+	return path.Join(v.config.VaultPathPrefix, id)
+}
+
+func (v *VaultSecretProvider) getLogEntry(ctx context.Context, id string, key string) *logrus.Entry {
+	entry := logrus.WithFields(requestmeta.LogFields(ctx)).WithFields(logrus.Fields{
+		"provider": "vault",
+		"api":      "secrets",
+	})
+
+	if id != "" {
+		entry = entry.WithField("id", id)
+	}
+
+	if key != "" {
+		entry = entry.WithField("key", key)
+	}
+
+	return entry
+}
+
+// GetIdentitySecrets implements SecretProvider.
+func (v *VaultSecretProvider) GetIdentitySecrets(ctx context.Context, id string) (Secrets, error) {
+	log := v.getLogEntry(ctx, id, "")
+	log.Debug("fetching identity secrets from provider")
+	if err := checkVaultIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return nil, err
+	}
+
+	results, _, err := v.readIdentitySecrets(ctx, id)
+	if err != nil && !errors.Is(err, ErrIdentityNotFound) {
+		log.WithError(err).Error("could not fetch identity from provider")
+	}
+
+	return results, err
+}
+
+// Returns the secrets of the identity with the vault version they were read at.
+// The version is 0 when the secret has never been written.
+func (v *VaultSecretProvider) readIdentitySecrets(ctx context.Context, id string) (Secrets, int, error) {
+	secret, err := v.client.KVv2(v.config.VaultMount).Get(ctx, v.getIdentitySecretPath(id))
+	if err != nil {
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			return nil, 0, ErrIdentityNotFound
+		}
+		return nil, 0, err
+	}
+
+	version := 0
+	if secret.VersionMetadata != nil {
+		version = secret.VersionMetadata.Version
+	}
+
+	// Latest version of the secret has been deleted
+	if secret.Data == nil {
+		return nil, version, ErrIdentityNotFound
+	}
+
+	results := Secrets{}
+	for k, val := range secret.Data {
+		s, ok := val.(string)
+		if !ok {
+			s = fmt.Sprint(val)
+		}
+		results[k] = s
+	}
+
+	return results, version, nil
+}
+
+// Applies the update with check-and-set on the vault version it was read at so
+// concurrent writers cannot drop each other's keys. When version is set the
+// update is only applied while the secrets still match it.
+func (v *VaultSecretProvider) updateIdentitySecrets(ctx context.Context, log *logrus.Entry, id string, version *string, set Secrets, remove []string) error {
+	for i := 0; i < updateRetries; i++ {
+		current, cas, err := v.readIdentitySecrets(ctx, id)
+		if err != nil && !errors.Is(err, ErrIdentityNotFound) {
+			log.WithError(err).Error("could not fetch identity from provider")
+			return err
+		}
+
+		if err := checkVersion(current, version); err != nil {
+			log.Debug("identity secrets version does not match")
+			return err
+		}
+
+		// Only deleting keys does not create the identity and there is nothing to delete
+		if current == nil && len(set) == 0 {
+			return nil
+		}
+
+		updated := applyUpdate(current, set, remove)
+		data := make(map[string]interface{}, len(updated))
+		for k, val := range updated {
+			data[k] = val
+		}
+
+		_, err = v.client.KVv2(v.config.VaultMount).Put(ctx, v.getIdentitySecretPath(id), data, vault.WithCheckAndSet(cas))
+		if isCheckAndSetMismatch(err) {
+			log.Debug("identity changed while updating secrets retrying")
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("could not update identity secret")
+			return err
+		}
+
+		return nil
+	}
+
+	log.Error("could not update identity secret after max retries")
+	return errors.New("identity secrets changed too many times while updating")
+}
+
+// Vault answers 400 both for invalid writes and when the check-and-set version is no
+// longer the current version, so only the check-and-set error is retried
+func isCheckAndSetMismatch(err error) bool {
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+
+	for _, message := range respErr.Errors {
+		if strings.Contains(message, vaultCheckAndSetMismatch) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetIdentitySecret implements SecretProvider.
+func (v *VaultSecretProvider) SetIdentitySecret(ctx context.Context, id string, key string, value string) error {
+	log := v.getLogEntry(ctx, id, key)
+	log.Debug("setting secret for identity")
+	if err := checkVaultIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
+	}
+
+	if key == "" {
+		log.Debug("called with no key")
+		return errors.New("key is blank")
+	}
+
+	return v.updateIdentitySecrets(ctx, log, id, nil, Secrets{key: value}, nil)
+}
+
+// GetAllIdentities implements SecretProvider.
+func (v *VaultSecretProvider) GetAllIdentities(ctx context.Context) ([]string, error) {
+	log := v.getLogEntry(ctx, "", "")
+	log.Debug("fetching all identities from provider")
+
+	listPath := path.Join(v.config.VaultMount, "metadata", v.config.VaultPathPrefix)
+	res, err := v.client.Logical().ListWithContext(ctx, listPath)
+	if err != nil {
+		log.WithError(err).Error("could not list identities from provider")
+		return nil, err
+	}
+
+	ids := []string{}
+	if res == nil || res.Data == nil { // Nothing has been written under the prefix yet
+		return ids, nil
+	}
+
+	keys, ok := res.Data["keys"].([]interface{})
+	if !ok {
+		return ids, nil
+	}
+	log.WithField("identities", len(keys)).Debug("fetched identities from provider")
+
+	for _, k := range keys {
+		key, ok := k.(string)
+		if !ok || strings.HasSuffix(key, "/") { // Skip nested folders
+			continue
+		}
+		ids = append(ids, key)
+	}
+
+	return ids, nil
+}
+
+// DeleteIdentity implements SecretProvider.
+func (v *VaultSecretProvider) DeleteIdentity(ctx context.Context, id string) error {
+	log := v.getLogEntry(ctx, id, "")
+	log.Debug("deleting identity")
+	if err := checkVaultIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
+	}
+
+	// Deleting metadata on a path that does not exist is a no-op in vault
+	err := v.client.KVv2(v.config.VaultMount).DeleteMetadata(ctx, v.getIdentitySecretPath(id))
+	if err != nil {
+		log.WithError(err).Error("could not delete identity")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIdentitySecret implements SecretProvider.
+func (v *VaultSecretProvider) DeleteIdentitySecret(ctx context.Context, id string, key string) error {
+	log := v.getLogEntry(ctx, id, key)
+	log.Debug("deleting identity secret")
+	if err := checkVaultIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
+	}
+
+	return v.updateIdentitySecrets(ctx, log, id, nil, nil, []string{key})
+}
+
+// UpdateIdentitySecretsIfMatch implements SecretProvider.
+func (v *VaultSecretProvider) UpdateIdentitySecretsIfMatch(ctx context.Context, id string, version string, set Secrets, remove []string) error {
+	log := v.getLogEntry(ctx, id, "")
+	log.Debug("updating identity secrets if version matches")
+	if err := checkVaultIdentity(id); err != nil {
+		log.WithError(err).Debug("called with invalid identity")
+		return err
+	}
+
+	return v.updateIdentitySecrets(ctx, log, id, &version, set, remove)
+}
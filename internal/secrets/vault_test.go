@@ -0,0 +1,301 @@
+package secrets_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+	vaultcontainer "github.com/testcontainers/testcontainers-go/modules/vault"
+)
+
+const vaultRootToken = "root-token"
+
+var vaultProviderOptions = config.SecretsProviderOptions{
+	VaultAuthMethod:   "token",
+	VaultAppRoleMount: "approle",
+	VaultMount:        "secret",
+	VaultPathPrefix:   "flagops",
+}
+
+func getVaultContainer(ctx context.Context) (testcontainers.Container, *vault.Client, error) {
+	vaultC, err := vaultcontainer.Run(ctx, "hashicorp/vault:1.17", vaultcontainer.WithToken(vaultRootToken))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	address, err := vaultC.HttpHostAddress(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := secrets.NewVaultClient(config.SecretsProviderOptions{
+		VaultAddress:    address,
+		VaultAuthMethod: "token",
+		VaultToken:      vaultRootToken,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vaultC, client, nil
+}
+
+func closeVaultContainer(t *testing.T, ctx context.Context, container testcontainers.Container) {
+	if err := container.Terminate(ctx); err != nil {
+		t.Fatalf("Could not stop vault: %s", err)
+	}
+}
+
+func TestVaultGetIdentitySecrets(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	client.KVv2("secret").Put(ctx, "flagops/test-identity", map[string]interface{}{
+		"foo": "bar",
+		"boo": "baz",
+	})
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	secretsOutput, err := provider.GetIdentitySecrets(context.Background(), "test-identity")
+	if assert.NoError(t, err) {
+		assert.Equal(t, secrets.Secrets{"foo": "bar", "boo": "baz"}, secretsOutput)
+	}
+}
+
+func TestVaultGetIdentitySecretsNotFound(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	_, err = provider.GetIdentitySecrets(context.Background(), "test-identity")
+	assert.ErrorIs(t, err, secrets.ErrIdentityNotFound)
+}
+
+func TestVaultSetIdentitySecretNoExistingSecret(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	err = provider.SetIdentitySecret(context.Background(), "test-identity", "foo", "bar")
+	if assert.NoError(t, err) {
+		res, err := client.KVv2("secret").Get(ctx, "flagops/test-identity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]interface{}{"foo": "bar"}, res.Data)
+		}
+	}
+}
+
+func TestVaultSetIdentitySecretExistingSecret(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	client.KVv2("secret").Put(ctx, "flagops/test-identity", map[string]interface{}{
+		"foo": "baz",
+		"boo": "bar",
+	})
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	err = provider.SetIdentitySecret(context.Background(), "test-identity", "foo", "bar")
+	if assert.NoError(t, err) {
+		res, err := client.KVv2("secret").Get(ctx, "flagops/test-identity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]interface{}{"foo": "bar", "boo": "bar"}, res.Data)
+		}
+	}
+}
+
+func TestVaultGetAllIdentities(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	client.KVv2("secret").Put(ctx, "flagops/test-identity-0", map[string]interface{}{"foo": "baz"})
+	client.KVv2("secret").Put(ctx, "flagops/test-identity-1", map[string]interface{}{"foo": "baz"})
+	client.KVv2("secret").Put(ctx, "flagops/test-identity-2", map[string]interface{}{"foo": "baz"})
+	client.KVv2("secret").Put(ctx, "other/test-identity-3", map[string]interface{}{"foo": "baz"})
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	ids, err := provider.GetAllIdentities(context.Background())
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"test-identity-0", "test-identity-1", "test-identity-2"}, ids)
+	}
+}
+
+func TestVaultDeleteIdentitySecret(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	client.KVv2("secret").Put(ctx, "flagops/test-identity", map[string]interface{}{
+		"foo": "baz",
+		"boo": "bar",
+	})
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	err = provider.DeleteIdentitySecret(context.Background(), "test-identity", "boo")
+	if assert.NoError(t, err) {
+		res, err := client.KVv2("secret").Get(ctx, "flagops/test-identity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]interface{}{"foo": "baz"}, res.Data)
+		}
+	}
+
+	// Deleting from a missing identity does nothing
+	assert.NoError(t, provider.DeleteIdentitySecret(context.Background(), "missing-identity", "boo"))
+}
+
+func TestVaultDeleteIdentity(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	client.KVv2("secret").Put(ctx, "flagops/test-identity", map[string]interface{}{
+		"foo": "baz",
+		"boo": "bar",
+	})
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+
+	err = provider.DeleteIdentity(context.Background(), "test-identity")
+	if assert.NoError(t, err) {
+		_, err := client.KVv2("secret").Get(ctx, "flagops/test-identity")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	}
+}
+
+func TestVaultAppRoleAuth(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	err = client.Sys().EnableAuthWithOptions("approle", &vault.EnableAuthOptions{Type: "approle"})
+	if err != nil {
+		t.Fatalf("Could not enable approle auth: %s", err)
+	}
+
+	err = client.Sys().PutPolicy("flagops", `path "secret/*" { capabilities = ["create", "read", "update", "delete", "list"] }`)
+	if err != nil {
+		t.Fatalf("Could not create policy: %s", err)
+	}
+
+	_, err = client.Logical().Write("auth/approle/role/flagops", map[string]interface{}{
+		"token_policies": "flagops",
+	})
+	if err != nil {
+		t.Fatalf("Could not create approle: %s", err)
+	}
+
+	roleID, err := client.Logical().Read("auth/approle/role/flagops/role-id")
+	if err != nil {
+		t.Fatalf("Could not read role id: %s", err)
+	}
+
+	secretID, err := client.Logical().Write("auth/approle/role/flagops/secret-id", nil)
+	if err != nil {
+		t.Fatalf("Could not create secret id: %s", err)
+	}
+
+	opts := vaultProviderOptions
+	opts.VaultAddress = client.Address()
+	opts.VaultAuthMethod = "approle"
+	opts.VaultRoleID = roleID.Data["role_id"].(string)
+	opts.VaultSecretID = secretID.Data["secret_id"].(string)
+
+	appRoleClient, err := secrets.NewVaultClient(opts)
+	if err != nil {
+		t.Fatalf("Could not log in with approle: %s", err)
+	}
+
+	provider := secrets.NewVaultSecretProvider(appRoleClient, opts)
+
+	err = provider.SetIdentitySecret(context.Background(), "test-identity", "foo", "bar")
+	if assert.NoError(t, err) {
+		secretsOutput, err := provider.GetIdentitySecrets(context.Background(), "test-identity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, secrets.Secrets{"foo": "bar"}, secretsOutput)
+		}
+	}
+}
+
+func TestVaultUpdateIdentitySecretsIfMatch(t *testing.T) {
+	ctx := context.Background()
+	container, client, err := getVaultContainer(ctx)
+	if err != nil {
+		t.Fatalf("Could not start vault: %s", err)
+	}
+	defer closeVaultContainer(t, ctx, container)
+
+	client.KVv2("secret").Put(ctx, "flagops/test-identity", map[string]interface{}{
+		"foo": "bar",
+	})
+
+	provider := secrets.NewVaultSecretProvider(client, vaultProviderOptions)
+	version := secrets.Version(secrets.Secrets{"foo": "bar"})
+
+	err = provider.UpdateIdentitySecretsIfMatch(ctx, "test-identity", version, secrets.Secrets{"boo": "baz"}, nil)
+	if assert.NoError(t, err) {
+		res, err := client.KVv2("secret").Get(ctx, "flagops/test-identity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]interface{}{"foo": "bar", "boo": "baz"}, res.Data)
+		}
+	}
+
+	err = provider.UpdateIdentitySecretsIfMatch(ctx, "test-identity", version, nil, []string{"foo"})
+	assert.ErrorIs(t, err, secrets.ErrVersionMismatch)
+}
+
+func TestVaultInvalidIdentity(t *testing.T) {
+	// Invalid ids are rejected before vault is called
+	provider := secrets.NewVaultSecretProvider(nil, vaultProviderOptions)
+
+	for _, id := range []string{"", "../other", "nested/identity", "identity.."} {
+		t.Run(id, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := provider.GetIdentitySecrets(ctx, id)
+			assert.Error(t, err)
+			assert.Error(t, provider.SetIdentitySecret(ctx, id, "foo", "bar"))
+			assert.Error(t, provider.DeleteIdentitySecret(ctx, id, "foo"))
+			assert.Error(t, provider.DeleteIdentity(ctx, id))
+			assert.Error(t, provider.UpdateIdentitySecretsIfMatch(ctx, id, "", secrets.Secrets{"foo": "bar"}, nil))
+		})
+	}
+}
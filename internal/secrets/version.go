@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Number of times an update is retried after losing a race with another writer
+const updateRetries = 5
+
+// Returns the version of the secrets of an identity. The version is derived from
+// the secrets themselves so it is the same for every provider and does not leak
+// the provider's own version ids.
+func Version(s Secrets) string {
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(s)) {
+		// Length prefixed so entries cannot run together
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(key), key, len(s[key]), s[key])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// Checks the secrets are still at the version the caller read them at. A nil
+// version means the caller did not ask for a check.
+func checkVersion(current Secrets, version *string) error {
+	if version != nil && Version(current) != *version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// Returns a copy of the secrets with the update applied
+func applyUpdate(current Secrets, set Secrets, remove []string) Secrets {
+	result := Secrets{}
+	maps.Copy(result, current)
+	maps.Copy(result, set)
+	for _, key := range remove {
+		delete(result, key)
+	}
+	return result
+}
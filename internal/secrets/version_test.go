@@ -0,0 +1,27 @@
+package secrets_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	assert.Equal(t, secrets.Version(nil), secrets.Version(secrets.Secrets{}))
+	assert.Equal(t, secrets.Version(secrets.Secrets{"a": "1", "b": "2"}), secrets.Version(secrets.Secrets{"b": "2", "a": "1"}))
+	assert.NotEqual(t, secrets.Version(secrets.Secrets{"ab": "c"}), secrets.Version(secrets.Secrets{"a": "bc"}))
+}
+
+func TestMockUpdateIdentitySecretsIfMatch(t *testing.T) {
+	provider := &secrets.MockSecretsProvider{SecretsDB: map[string]map[string]string{
+		"customer0": {"foo": "bar"},
+	}}
+	version := secrets.Version(secrets.Secrets{"foo": "bar"})
+
+	assert.NoError(t, provider.UpdateIdentitySecretsIfMatch(context.Background(), "customer0", version, secrets.Secrets{"boo": "baz"}, nil))
+	assert.Equal(t, map[string]string{"foo": "bar", "boo": "baz"}, provider.SecretsDB["customer0"])
+
+	assert.ErrorIs(t, provider.UpdateIdentitySecretsIfMatch(context.Background(), "customer0", version, nil, []string{"foo"}), secrets.ErrVersionMismatch)
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// Key on the gin context handlers can store extra details of an audited call under
+const DetailsKey = "audit_details"
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// Persists and queries audit events
+type AuditService struct {
+	DBClient *gorm.DB
+}
+
+func (as *AuditService) Record(ctx context.Context, event db.AuditEvent) error {
+	return as.DBClient.WithContext(ctx).Create(&event).Error
+}
+
+// Filters applied when listing audit events. Empty fields are ignored.
+type Filter struct {
+	Actor    string    `form:"actor"`
+	Action   string    `form:"action"`
+	Identity string    `form:"identity"`
+	Outcome  string    `form:"outcome"`
+	Since    time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until    time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+
+	Page     int `form:"page"`
+	PageSize int `form:"page_size"`
+}
+
+// A single page of audit events newest first
+type Page struct {
+	Events   []db.AuditEvent `json:"events"`
+	Total    int64           `json:"total"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+}
+
+func (as *AuditService) GetEvents(ctx context.Context, filter Filter) (*Page, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+
+	if filter.PageSize < 1 {
+		filter.PageSize = DefaultPageSize
+	}
+	filter.PageSize = min(filter.PageSize, MaxPageSize)
+
+	query := as.DBClient.WithContext(ctx).Model(&db.AuditEvent{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Identity != "" {
+		query = query.Where("identity = ?", filter.Identity)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	page := &Page{
+		Events:   []db.AuditEvent{},
+		Page:     filter.Page,
+		PageSize: filter.PageSize,
+	}
+
+	err := query.Count(&page.Total).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = query.
+		Order("created_at DESC, id DESC").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&page.Events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
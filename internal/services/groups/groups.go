@@ -0,0 +1,276 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidGroup = errors.New("invalid group")
+	ErrGroupInUse   = errors.New("group is in use")
+)
+
+// Source of facts set on the identity itself in a resolved view
+const SourceIdentity = "identity"
+
+// Handles managing identity groups and resolving the facts identities inherit from them
+type GroupService struct {
+	DBClient *gorm.DB
+}
+
+// The facts of an identity merged with the facts of its groups. Sources holds
+// where each fact came from as either identity or group:<name>.
+type ResolvedFacts struct {
+	Facts   facts.Facts       `json:"facts"`
+	Sources map[string]string `json:"sources"`
+}
+
+// TODO Add pagination
+func (gs *GroupService) GetGroups() ([]db.IdentityGroup, error) {
+	groups := []db.IdentityGroup{}
+
+	err := gs.DBClient.Order("name").Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (gs *GroupService) GetGroup(name string) (*db.IdentityGroup, error) {
+	group := db.IdentityGroup{}
+
+	err := gs.DBClient.Where("name = ?", name).First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// Creates the group or replaces the existing group with the same name
+func (gs *GroupService) UpsertGroup(group db.IdentityGroup) (*db.IdentityGroup, error) {
+	if group.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidGroup)
+	}
+
+	if group.Facts == nil {
+		group.Facts = facts.Facts{}
+	}
+
+	err := gs.DBClient.Transaction(func(tx *gorm.DB) error {
+		groups, err := loadGroups(tx)
+		if err != nil {
+			return err
+		}
+
+		existing, ok := groups[group.Name]
+		if ok {
+			group.ID = existing.ID
+			group.CreatedAt = existing.CreatedAt
+		}
+		groups[group.Name] = group
+
+		if err := checkParents(groups, group); err != nil {
+			return err
+		}
+
+		return tx.Save(&group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// Deletes a group that is not a parent of another group and has no members
+func (gs *GroupService) DeleteGroup(name string) error {
+	return gs.DBClient.Transaction(func(tx *gorm.DB) error {
+		groups, err := loadGroups(tx)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := groups[name]; !ok {
+			return gorm.ErrRecordNotFound
+		}
+
+		for _, group := range groups {
+			if slices.Contains(group.Parents, name) {
+				return fmt.Errorf("%w: parent of group %s", ErrGroupInUse, group.Name)
+			}
+		}
+
+		memberships := []db.IdentityGroupMembership{}
+		err = tx.Find(&memberships).Error
+		if err != nil {
+			return err
+		}
+
+		for _, membership := range memberships {
+			if slices.Contains(membership.Groups, name) {
+				return fmt.Errorf("%w: identity %s is a member", ErrGroupInUse, membership.Identity)
+			}
+		}
+
+		// Hard delete so the name can be used again
+		return tx.Unscoped().Where("name = ?", name).Delete(&db.IdentityGroup{}).Error
+	})
+}
+
+// Returns the ordered list of groups the identity belongs to
+func (gs *GroupService) GetIdentityGroups(identity string) ([]string, error) {
+	membership := db.IdentityGroupMembership{}
+
+	err := gs.DBClient.Where("identity = ?", identity).First(&membership).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	return membership.Groups, nil
+}
+
+// Replaces the ordered list of groups the identity belongs to. Every group must exist.
+func (gs *GroupService) SetIdentityGroups(identity string, names []string) error {
+	return gs.DBClient.Transaction(func(tx *gorm.DB) error {
+		groups, err := loadGroups(tx)
+		if err != nil {
+			return err
+		}
+
+		for i, name := range names {
+			if _, ok := groups[name]; !ok {
+				return fmt.Errorf("%w: no such group %s", ErrInvalidGroup, name)
+			}
+
+			if slices.Contains(names[:i], name) {
+				return fmt.Errorf("%w: group %s listed twice", ErrInvalidGroup, name)
+			}
+		}
+
+		if len(names) == 0 {
+			return tx.Where("identity = ?", identity).Delete(&db.IdentityGroupMembership{}).Error
+		}
+
+		return tx.Save(&db.IdentityGroupMembership{Identity: identity, Groups: names}).Error
+	})
+}
+
+// Merges the facts of the groups of the identity under its own facts
+func (gs *GroupService) Resolve(ctx context.Context, identity string, identityFacts facts.Facts) (*ResolvedFacts, error) {
+	tx := gs.DBClient.WithContext(ctx)
+
+	groups, err := loadGroups(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	membership := db.IdentityGroupMembership{}
+	err = tx.Where("identity = ?", identity).First(&membership).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	return ResolveFacts(groups, membership.Groups, identityFacts), nil
+}
+
+// Returns true if the identity belongs to any group
+func (gs *GroupService) HasGroups(identity string) (bool, error) {
+	names, err := gs.GetIdentityGroups(identity)
+	if err != nil {
+		return false, err
+	}
+
+	return len(names) > 0, nil
+}
+
+func loadGroups(tx *gorm.DB) (map[string]db.IdentityGroup, error) {
+	list := []db.IdentityGroup{}
+	err := tx.Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]db.IdentityGroup{}
+	for _, group := range list {
+		groups[group.Name] = group
+	}
+
+	return groups, nil
+}
+
+// Checks that every parent of the group exists and that the group does not inherit from itself
+func checkParents(groups map[string]db.IdentityGroup, group db.IdentityGroup) error {
+	for i, parent := range group.Parents {
+		if _, ok := groups[parent]; !ok {
+			return fmt.Errorf("%w: no such parent group %s", ErrInvalidGroup, parent)
+		}
+
+		if slices.Contains(group.Parents[:i], parent) {
+			return fmt.Errorf("%w: parent %s listed twice", ErrInvalidGroup, parent)
+		}
+	}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if slices.Contains(path, name) {
+			return fmt.Errorf("%w: cycle %v", ErrInvalidGroup, append(path, name))
+		}
+
+		for _, parent := range groups[name].Parents {
+			if err := visit(parent, append(path, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return visit(group.Name, nil)
+}
+
+// Merges the facts of the groups in order so later groups override earlier ones
+// and the identity facts override every group
+func ResolveFacts(groups map[string]db.IdentityGroup, names []string, identityFacts facts.Facts) *ResolvedFacts {
+	resolved := &ResolvedFacts{
+		Facts:   facts.Facts{},
+		Sources: map[string]string{},
+	}
+
+	var apply func(name string, path []string)
+	apply = func(name string, path []string) {
+		group, ok := groups[name]
+		if !ok || slices.Contains(path, name) {
+			return
+		}
+
+		for _, parent := range group.Parents {
+			apply(parent, append(path, name))
+		}
+
+		for key, value := range group.Facts {
+			resolved.Facts[key] = value
+			resolved.Sources[key] = "group:" + name
+		}
+	}
+
+	for _, name := range names {
+		apply(name, nil)
+	}
+
+	for key, value := range identityFacts {
+		resolved.Facts[key] = value
+		resolved.Sources[key] = SourceIdentity
+	}
+
+	return resolved
+}
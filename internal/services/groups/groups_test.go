@@ -0,0 +1,104 @@
+package groups_test
+
+import (
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/services/groups"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveFacts(t *testing.T) {
+	allGroups := map[string]db.IdentityGroup{
+		"base": {Name: "base", Facts: facts.Facts{
+			"owner_team": facts.NewStringFact("platform"),
+			"tier":       facts.NewStringFact("standard"),
+		}},
+		"eu": {Name: "eu", Parents: []string{"base"}, Facts: facts.Facts{
+			"region": facts.NewStringFact("eu-west-1"),
+		}},
+		"eu-prod": {Name: "eu-prod", Parents: []string{"eu"}, Facts: facts.Facts{
+			"cluster": facts.NewStringFact("prod-1"),
+			"tier":    facts.NewStringFact("premium"),
+		}},
+		"payments": {Name: "payments", Facts: facts.Facts{
+			"owner_team": facts.NewStringFact("payments"),
+		}},
+	}
+
+	tests := []struct {
+		name          string
+		groups        []string
+		identityFacts facts.Facts
+		want          *groups.ResolvedFacts
+	}{
+		{
+			name:          "no groups",
+			identityFacts: facts.Facts{"region": facts.NewStringFact("us-east-1")},
+			want: &groups.ResolvedFacts{
+				Facts:   facts.Facts{"region": facts.NewStringFact("us-east-1")},
+				Sources: map[string]string{"region": groups.SourceIdentity},
+			},
+		},
+		{
+			name:   "nested groups",
+			groups: []string{"eu-prod"},
+			want: &groups.ResolvedFacts{
+				Facts: facts.Facts{
+					"owner_team": facts.NewStringFact("platform"),
+					"tier":       facts.NewStringFact("premium"),
+					"region":     facts.NewStringFact("eu-west-1"),
+					"cluster":    facts.NewStringFact("prod-1"),
+				},
+				Sources: map[string]string{
+					"owner_team": "group:base",
+					"tier":       "group:eu-prod",
+					"region":     "group:eu",
+					"cluster":    "group:eu-prod",
+				},
+			},
+		},
+		{
+			name:          "later groups and identity override",
+			groups:        []string{"eu", "payments"},
+			identityFacts: facts.Facts{"region": facts.NewStringFact("eu-central-1")},
+			want: &groups.ResolvedFacts{
+				Facts: facts.Facts{
+					"owner_team": facts.NewStringFact("payments"),
+					"tier":       facts.NewStringFact("standard"),
+					"region":     facts.NewStringFact("eu-central-1"),
+				},
+				Sources: map[string]string{
+					"owner_team": "group:payments",
+					"tier":       "group:base",
+					"region":     groups.SourceIdentity,
+				},
+			},
+		},
+		{
+			name:   "missing group",
+			groups: []string{"deleted"},
+			want: &groups.ResolvedFacts{
+				Facts:   facts.Facts{},
+				Sources: map[string]string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, groups.ResolveFacts(allGroups, tt.groups, tt.identityFacts))
+		})
+	}
+}
+
+func TestResolveFactsCycle(t *testing.T) {
+	allGroups := map[string]db.IdentityGroup{
+		"a": {Name: "a", Parents: []string{"b"}, Facts: facts.Facts{"key": facts.NewStringFact("a")}},
+		"b": {Name: "b", Parents: []string{"a"}, Facts: facts.Facts{"key": facts.NewStringFact("b")}},
+	}
+
+	resolved := groups.ResolveFacts(allGroups, []string{"a"}, nil)
+	assert.Equal(t, facts.NewStringFact("a"), resolved.Facts["key"])
+}
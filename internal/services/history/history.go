@@ -0,0 +1,96 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"gorm.io/gorm"
+)
+
+var _ facts.FactObserver = &HistoryService{}
+
+// Records every fact change and reconstructs past states of identities from them
+type HistoryService struct {
+	DBClient *gorm.DB
+}
+
+// ObserveFactChange implements facts.FactObserver.
+func (hs *HistoryService) ObserveFactChange(ctx context.Context, change facts.FactChange) error {
+	return hs.DBClient.WithContext(ctx).Create(&db.FactChange{
+		CreatedAt:   change.Time,
+		Identity:    change.Identity,
+		Key:         change.Key,
+		Environment: change.Environment,
+		Operation:   string(change.Operation),
+		OldValue:    change.OldValue,
+		NewValue:    change.NewValue,
+		Actor:       change.Actor,
+	}).Error
+}
+
+// Returns the changes of the identity in the environment newest first. An empty
+// environment returns changes to the base facts. If key is not empty only
+// changes to that fact are returned. A limit of 0 returns every change.
+func (hs *HistoryService) GetIdentityHistory(ctx context.Context, identity string, env string, key string, limit int) ([]db.FactChange, error) {
+	changes := []db.FactChange{}
+
+	query := hs.DBClient.WithContext(ctx).Where("identity = ? AND environment = ?", identity, env)
+	if key != "" {
+		query = query.Where("key = ?", key)
+	}
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	err := query.Order("created_at DESC, id DESC").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// Reconstructs the facts of the identity at the given time by undoing every
+// recorded change made after it, starting from the current facts in the provider
+func (hs *HistoryService) GetIdentityFactsAt(ctx context.Context, provider facts.FactProvider, identity string, at time.Time) (facts.Facts, error) {
+	current, err := provider.GetIdentityFacts(ctx, identity)
+	if err != nil {
+		if !errors.Is(err, facts.ErrIdentityNotFound) {
+			return nil, err
+		}
+		current = facts.Facts{}
+	}
+
+	changes := []db.FactChange{}
+	err = hs.DBClient.WithContext(ctx).
+		Where("identity = ? AND environment = '' AND created_at > ?", identity, at).
+		Order("created_at DESC, id DESC").
+		Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := facts.Facts{}
+	for k, v := range current {
+		result[k] = v
+	}
+
+	for _, change := range changes {
+		if change.OldValue == nil {
+			delete(result, change.Key)
+			continue
+		}
+
+		result[change.Key] = *change.OldValue
+	}
+
+	if len(result) == 0 {
+		return nil, facts.ErrIdentityNotFound
+	}
+
+	return result, nil
+}
@@ -1,11 +1,16 @@
 package jwt
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/graytonio/flagops-data-store/internal/db"
 	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"github.com/sirupsen/logrus"
 )
 
 // Handles the parsing and validation of JWT authentication tokens
@@ -15,14 +20,47 @@ type JWTService struct {
 	SigningSecret string
 
 	UserDataService *user.UserDataService
+
+	// Looks up the current groups of users when their access token is refreshed. Optional.
+	GroupSource GroupSource
+}
+
+// Looks up the groups a user currently belongs to at their identity provider.
+// ErrAccessRevoked is returned when the identity provider no longer accepts the user.
+// Any other error is treated as temporary and the groups from the last sync are kept.
+type GroupSource interface {
+	CurrentGroups(ctx context.Context, user *db.User) ([]string, error)
 }
 
+// Returned by a GroupSource when the identity provider rejected the stored tokens of the user
+var ErrAccessRevoked = errors.New("identity provider revoked access")
+
+// Time allowed for looking up the groups of a user while refreshing their access token
+const groupLookupTimeout = 10 * time.Second
+
 type UserClaims struct {
 	ID          uint     `json:"id"`
 	Permissions []string `json:"permissions"`
+
+	// Set instead of ID when the request was authenticated with a service account api token
+	ServiceAccount string `json:"service_account,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Returns true if the claims hold the permission or the admin permission
+func (c *UserClaims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission) || slices.Contains(c.Permissions, db.AdminPermission)
+}
+
+// Returns the identifier of the user used when recording who made a request
+func (c *UserClaims) Caller() string {
+	if c.ServiceAccount != "" {
+		return fmt.Sprintf("service-account:%s", c.ServiceAccount)
+	}
+
+	return fmt.Sprintf("user:%d", c.ID)
+}
+
 type UserRefreshClaims struct {
 	ID uint `json:"id"`
 	jwt.RegisteredClaims
@@ -111,14 +149,30 @@ func (jh *JWTService) refreshAccessToken(refreshToken *UserRefreshClaims) (*User
 	  return nil, "", err
 	}
 
-	permissions := []string{}
-	for _, p := range user.Permissions {
-		permissions = append(permissions, p.ID)
+	// Group membership is checked again so changes at the identity provider are picked up.
+	// A user the identity provider no longer accepts has to log in again.
+	if jh.GroupSource != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), groupLookupTimeout)
+		groups, err := jh.GroupSource.CurrentGroups(ctx, user)
+		cancel()
+
+		switch {
+		case errors.Is(err, ErrAccessRevoked):
+			return nil, "", err
+		case err != nil:
+			// Outages and rate limits at the identity provider should not log users out
+			logrus.WithError(err).WithField("user_id", user.ID).Warn("could not look up current groups keeping groups from last sync")
+		default:
+			err = jh.UserDataService.SyncUserGroups(user, groups)
+			if err != nil {
+			  return nil, "", err
+			}
+		}
 	}
 
 	claims := &UserClaims{
 		ID: user.ID,
-		Permissions: permissions,
+		Permissions: user.PermissionIDs(),
 	}
 
 	newAccessToken, err := jh.NewUserAccessToken(claims)
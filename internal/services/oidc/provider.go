@@ -0,0 +1,372 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+var _ goth.Provider = &Provider{}
+
+var (
+	ErrIssuerMismatch = errors.New("discovered issuer does not match the configured issuer")
+	ErrUnknownKey     = errors.New("id token signed with unknown key")
+	ErrNonceMismatch  = errors.New("id token nonce does not match")
+	ErrMissingIDToken = errors.New("token response did not contain an id token")
+)
+
+// Signing algorithms accepted for id tokens
+var signingAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+type Options struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	CallbackURL  string
+	Scopes       []string
+
+	// Claims of the id token mapped onto the user. Defaults to preferred_username, email and groups.
+	UsernameClaim string
+	EmailClaim    string
+	GroupsClaim   string
+
+	// Client used for discovery, key and token requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// The parts of the discovery document used by the provider
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// A goth provider for any OpenID Connect identity provider such as Keycloak or Okta.
+// Endpoints are discovered from the issuer, the authorization code flow is protected
+// with PKCE and id tokens are verified against the keys published by the issuer.
+type Provider struct {
+	name   string
+	issuer string
+	config *oauth2.Config
+	client *http.Client
+
+	jwksURI string
+	keysMu  sync.RWMutex
+	keys    jose.JSONWebKeySet
+
+	usernameClaim string
+	emailClaim    string
+	groupsClaim   string
+}
+
+// Creates a provider by fetching the discovery document of the issuer
+func NewProvider(ctx context.Context, name string, opts Options) (*Provider, error) {
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	issuer := strings.TrimSuffix(opts.IssuerURL, "/")
+	discovery, err := discover(ctx, client, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := opts.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	p := &Provider{
+		name:   name,
+		issuer: discovery.Issuer,
+		client: client,
+		config: &oauth2.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			RedirectURL:  opts.CallbackURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+		jwksURI:       discovery.JWKSURI,
+		usernameClaim: opts.UsernameClaim,
+		emailClaim:    opts.EmailClaim,
+		groupsClaim:   opts.GroupsClaim,
+	}
+
+	if p.usernameClaim == "" {
+		p.usernameClaim = "preferred_username"
+	}
+
+	if p.emailClaim == "" {
+		p.emailClaim = "email"
+	}
+
+	if p.groupsClaim == "" {
+		p.groupsClaim = "groups"
+	}
+
+	return p, nil
+}
+
+func discover(ctx context.Context, client *http.Client, issuer string) (*discoveryDocument, error) {
+	var discovery discoveryDocument
+	err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover openid configuration: %w", err)
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("%w: %s", ErrIssuerMismatch, discovery.Issuer)
+	}
+
+	return &discovery, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, url)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Name implements goth.Provider.
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// SetName implements goth.Provider.
+func (p *Provider) SetName(name string) {
+	p.name = name
+}
+
+// Debug implements goth.Provider.
+func (p *Provider) Debug(bool) {}
+
+// BeginAuth implements goth.Provider.
+func (p *Provider) BeginAuth(state string) (goth.Session, error) {
+	verifier := oauth2.GenerateVerifier()
+	nonce := oauth2.GenerateVerifier()
+
+	return &Session{
+		AuthURL:      p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce)),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+	}, nil
+}
+
+// UnmarshalSession implements goth.Provider.
+func (p *Provider) UnmarshalSession(data string) (goth.Session, error) {
+	sess := &Session{}
+	err := json.Unmarshal([]byte(data), sess)
+	return sess, err
+}
+
+// FetchUser implements goth.Provider. The user is built from the claims of the
+// id token verified when the session was authorized.
+func (p *Provider) FetchUser(session goth.Session) (goth.User, error) {
+	sess, ok := session.(*Session)
+	if !ok {
+		return goth.User{}, errors.New("session was not created by an oidc provider")
+	}
+
+	if sess.IDToken == "" {
+		return goth.User{}, fmt.Errorf("%s cannot get user information without an id token", p.name)
+	}
+
+	claims, err := p.verifyIDToken(context.Background(), sess.IDToken, sess.Nonce)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	user := goth.User{
+		RawData:      claims,
+		Provider:     p.name,
+		UserID:       claimString(claims, "sub"),
+		Name:         claimString(claims, p.usernameClaim),
+		NickName:     claimString(claims, p.usernameClaim),
+		Email:        claimString(claims, p.emailClaim),
+		FirstName:    claimString(claims, "given_name"),
+		LastName:     claimString(claims, "family_name"),
+		AccessToken:  sess.AccessToken,
+		RefreshToken: sess.RefreshToken,
+		ExpiresAt:    sess.ExpiresAt,
+		IDToken:      sess.IDToken,
+	}
+
+	return user, nil
+}
+
+func claimString(claims map[string]any, claim string) string {
+	value, _ := claims[claim].(string)
+	return value
+}
+
+// Returns the groups listed in the groups claim. A single group may be sent as a string.
+func (p *Provider) Groups(claims map[string]any) []string {
+	switch value := claims[p.groupsClaim].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		groups := []string{}
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+		return groups
+	default:
+		return []string{}
+	}
+}
+
+// Uses the refresh token to get a new id token and returns its claims and the
+// refresh token to use next time. ErrMissingIDToken is returned when the issuer
+// does not send id tokens on refresh.
+func (p *Provider) RefreshClaims(ctx context.Context, refreshToken string) (jwt.MapClaims, string, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextRefreshToken := token.RefreshToken
+	if nextRefreshToken == "" {
+		nextRefreshToken = refreshToken
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, nextRefreshToken, ErrMissingIDToken
+	}
+
+	claims, err := p.verifyIDToken(ctx, idToken, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	return claims, nextRefreshToken, nil
+}
+
+// Returns true when the issuer rejected the refresh token because it was revoked or the
+// user was disabled. Other errors like timeouts and server errors are temporary.
+func IsRevoked(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.Response == nil {
+		return false
+	}
+
+	switch retrieveErr.Response.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	case http.StatusBadRequest:
+		return retrieveErr.ErrorCode == "invalid_grant"
+	default:
+		return false
+	}
+}
+
+// RefreshTokenAvailable implements goth.Provider.
+func (p *Provider) RefreshTokenAvailable() bool {
+	return true
+}
+
+// RefreshToken implements goth.Provider.
+func (p *Provider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, p.client)
+	return p.config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+// Exchanges the authorization code sending the PKCE verifier of the session
+func (p *Provider) exchange(ctx context.Context, code string, verifier string) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	return p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+}
+
+// Verifies the signature, issuer, audience, expiry and nonce of an id token and returns its claims.
+// The nonce is only checked on login as refreshed id tokens do not need to carry it.
+func (p *Provider) verifyIDToken(ctx context.Context, idToken string, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, kid)
+	},
+		jwt.WithValidMethods(signingAlgorithms),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if nonce != "" && claimString(claims, "nonce") != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// Returns the public key with the id. Keys are fetched again when the id is
+// unknown so keys rotated by the issuer are picked up.
+func (p *Provider) signingKey(ctx context.Context, kid string) (any, error) {
+	if key, ok := p.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	var keys jose.JSONWebKeySet
+	err := getJSON(ctx, p.client, p.jwksURI, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch signing keys: %w", err)
+	}
+
+	p.keysMu.Lock()
+	p.keys = keys
+	p.keysMu.Unlock()
+
+	if key, ok := p.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func (p *Provider) cachedKey(kid string) (any, bool) {
+	p.keysMu.RLock()
+	defer p.keysMu.RUnlock()
+
+	for _, key := range p.keys.Keys {
+		if (kid == "" || key.KeyID == kid) && key.Use != "enc" {
+			return key.Key, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,269 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/graytonio/flagops-data-store/internal/services/oidc"
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+// A minimal OpenID Connect server issuing id tokens for authorization codes registered by the test
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]authorization
+	claims jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{key: key, codes: map[string]authorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", m.token)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	auth, ok := m.codes[r.PostFormValue("code")]
+	m.mu.Unlock()
+
+	if r.PostFormValue("grant_type") == "refresh_token" {
+		// Refreshed id tokens are issued without a nonce
+		ok = r.PostFormValue("refresh_token") == "refresh"
+		auth = authorization{}
+	} else {
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		ok = ok && base64.RawURLEncoding.EncodeToString(verifier[:]) == auth.challenge
+	}
+
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss": m.server.URL,
+		"aud": "flagops",
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, _ := token.SignedString(m.key)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  "access",
+		"refresh_token": "refresh",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"id_token":      idToken,
+	})
+}
+
+// Simulates the user approving the login at the authorization endpoint and returns the callback params
+func (m *mockIssuer) authorize(t *testing.T, authURL string) goth.Params {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+
+	query := u.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	m.mu.Lock()
+	m.codes["code"] = authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+
+	return url.Values{"code": {"code"}, "state": {query.Get("state")}}
+}
+
+func newProvider(t *testing.T, issuer *mockIssuer) *oidc.Provider {
+	provider, err := oidc.NewProvider(context.Background(), "keycloak", oidc.Options{
+		IssuerURL:    issuer.server.URL,
+		ClientID:     "flagops",
+		ClientSecret: "secret",
+		CallbackURL:  "http://localhost:8080/auth/keycloak/callback",
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestProviderLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = jwt.MapClaims{"preferred_username": "jdoe", "email": "jdoe@example.com"}
+	provider := newProvider(t, issuer)
+
+	sess, err := provider.BeginAuth("state")
+	require.NoError(t, err)
+
+	authURL, err := sess.GetAuthURL()
+	require.NoError(t, err)
+	params := issuer.authorize(t, authURL)
+
+	// The session survives the round trip through the gothic cookie
+	sess, err = provider.UnmarshalSession(sess.Marshal())
+	require.NoError(t, err)
+
+	_, err = sess.Authorize(provider, params)
+	require.NoError(t, err)
+
+	user, err := provider.FetchUser(sess)
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", user.Provider)
+	assert.Equal(t, "user-1", user.UserID)
+	assert.Equal(t, "jdoe", user.Name)
+	assert.Equal(t, "jdoe@example.com", user.Email)
+	assert.Equal(t, "refresh", user.RefreshToken)
+}
+
+func TestProviderClaimMapping(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = jwt.MapClaims{"upn": "jdoe", "mail": "jdoe@example.com"}
+
+	provider, err := oidc.NewProvider(context.Background(), "okta", oidc.Options{
+		IssuerURL:     issuer.server.URL,
+		ClientID:      "flagops",
+		UsernameClaim: "upn",
+		EmailClaim:    "mail",
+	})
+	require.NoError(t, err)
+
+	sess, err := provider.BeginAuth("state")
+	require.NoError(t, err)
+	authURL, _ := sess.GetAuthURL()
+
+	_, err = sess.Authorize(provider, issuer.authorize(t, authURL))
+	require.NoError(t, err)
+
+	user, err := provider.FetchUser(sess)
+	require.NoError(t, err)
+	assert.Equal(t, "jdoe", user.Name)
+	assert.Equal(t, "jdoe@example.com", user.Email)
+}
+
+func TestProviderRejectsInvalidLogins(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := newProvider(t, issuer)
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		sess, _ := provider.BeginAuth("state")
+		authURL, _ := sess.GetAuthURL()
+		params := issuer.authorize(t, authURL)
+
+		other, _ := provider.BeginAuth("state")
+		_, err := other.Authorize(provider, params)
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		issuer.claims = jwt.MapClaims{"aud": "other-client"}
+		defer func() { issuer.claims = nil }()
+
+		sess, _ := provider.BeginAuth("state")
+		authURL, _ := sess.GetAuthURL()
+		_, err := sess.Authorize(provider, issuer.authorize(t, authURL))
+		assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		issuer.claims = jwt.MapClaims{"nonce": "replayed"}
+		defer func() { issuer.claims = nil }()
+
+		sess, _ := provider.BeginAuth("state")
+		authURL, _ := sess.GetAuthURL()
+		_, err := sess.Authorize(provider, issuer.authorize(t, authURL))
+		assert.ErrorIs(t, err, oidc.ErrNonceMismatch)
+	})
+}
+
+func TestProviderGroups(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := newProvider(t, issuer)
+
+	assert.Equal(t, []string{"platform", "developers"}, provider.Groups(map[string]any{"groups": []any{"platform", "developers"}}))
+	assert.Equal(t, []string{"platform"}, provider.Groups(map[string]any{"groups": "platform"}))
+	assert.Equal(t, []string{}, provider.Groups(map[string]any{}))
+}
+
+func TestProviderRefreshClaims(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = jwt.MapClaims{"groups": []string{"platform"}}
+	provider := newProvider(t, issuer)
+
+	claims, refreshToken, err := provider.RefreshClaims(context.Background(), "refresh")
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", refreshToken)
+	assert.Equal(t, []string{"platform"}, provider.Groups(claims))
+
+	_, _, err = provider.RefreshClaims(context.Background(), "revoked")
+	assert.Error(t, err)
+	assert.True(t, oidc.IsRevoked(err))
+
+	// Failing to reach the issuer does not mean the user was revoked
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = provider.RefreshClaims(ctx, "refresh")
+	assert.Error(t, err)
+	assert.False(t, oidc.IsRevoked(err))
+}
+
+func TestNewProviderIssuerMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	// The same server reached under another name does not match the issuer it advertises
+	_, err := oidc.NewProvider(context.Background(), "keycloak", oidc.Options{
+		IssuerURL: strings.Replace(issuer.server.URL, "127.0.0.1", "localhost", 1),
+	})
+	assert.ErrorIs(t, err, oidc.ErrIssuerMismatch)
+}
@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+var _ goth.Session = &Session{}
+
+// State of a login stored in the gothic cookie between the redirect to the
+// issuer and the callback
+type Session struct {
+	AuthURL      string
+	CodeVerifier string
+	Nonce        string
+
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	IDToken      string
+}
+
+// GetAuthURL implements goth.Session.
+func (s *Session) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New(goth.NoAuthUrlErrorMessage)
+	}
+
+	return s.AuthURL, nil
+}
+
+// Marshal implements goth.Session.
+func (s *Session) Marshal() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// Authorize implements goth.Session. The id token is verified before the session is accepted.
+func (s *Session) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	p, ok := provider.(*Provider)
+	if !ok {
+		return "", errors.New("provider is not an oidc provider")
+	}
+
+	if errCode := params.Get("error"); errCode != "" {
+		return "", errors.New("authorization failed: " + errCode + " " + params.Get("error_description"))
+	}
+
+	ctx := context.Background()
+	token, err := p.exchange(ctx, params.Get("code"), s.CodeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return "", ErrMissingIDToken
+	}
+
+	_, err = p.verifyIDToken(ctx, idToken, s.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	s.AccessToken = token.AccessToken
+	s.RefreshToken = token.RefreshToken
+	s.ExpiresAt = token.Expiry
+	s.IDToken = idToken
+	return token.AccessToken, nil
+}
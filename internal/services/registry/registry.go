@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidIdentity = errors.New("invalid identity")
+	ErrIdentityExists  = errors.New("identity already exists")
+)
+
+// Keeps the metadata of identities. Providers only know identities implicitly by
+// the data stored for them so the registry is the record of who created and owns them.
+type RegistryService struct {
+	DBClient *gorm.DB
+}
+
+// Changes to the metadata of an identity. Nil fields are left unchanged and a nil
+// label value removes the label.
+type IdentityUpdate struct {
+	Description *string            `json:"description"`
+	Owner       *string            `json:"owner"`
+	Labels      map[string]*string `json:"labels"`
+}
+
+// Checks an identity id can be stored by every provider
+func ValidateID(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalidIdentity)
+	}
+
+	if strings.ContainsAny(id, ":/") {
+		return fmt.Errorf("%w: id can not contain : or /", ErrInvalidIdentity)
+	}
+
+	return nil
+}
+
+// TODO Add pagination
+func (rs *RegistryService) GetIdentities(ctx context.Context) ([]db.Identity, error) {
+	identities := []db.Identity{}
+
+	err := rs.DBClient.WithContext(ctx).Order("id").Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (rs *RegistryService) GetIdentity(ctx context.Context, id string) (*db.Identity, error) {
+	identity := db.Identity{}
+
+	err := rs.DBClient.WithContext(ctx).Where("id = ?", id).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// Registers a new identity created by the caller of the request
+func (rs *RegistryService) CreateIdentity(ctx context.Context, identity db.Identity) (*db.Identity, error) {
+	if err := ValidateID(identity.ID); err != nil {
+		return nil, err
+	}
+
+	if identity.Labels == nil {
+		identity.Labels = map[string]string{}
+	}
+	identity.CreatedBy = requestmeta.Caller(ctx)
+
+	result := rs.DBClient.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&identity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrIdentityExists, identity.ID)
+	}
+
+	return &identity, nil
+}
+
+// Applies the update to a registered identity
+func (rs *RegistryService) UpdateIdentity(ctx context.Context, id string, update IdentityUpdate) (*db.Identity, error) {
+	identity := db.Identity{}
+
+	err := rs.DBClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&identity).Error
+		if err != nil {
+			return err
+		}
+
+		applyUpdate(&identity, update)
+		return tx.Save(&identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (rs *RegistryService) DeleteIdentity(ctx context.Context, id string) error {
+	return rs.DBClient.WithContext(ctx).Where("id = ?", id).Delete(&db.Identity{}).Error
+}
+
+func applyUpdate(identity *db.Identity, update IdentityUpdate) {
+	if update.Description != nil {
+		identity.Description = *update.Description
+	}
+
+	if update.Owner != nil {
+		identity.Owner = *update.Owner
+	}
+
+	if identity.Labels == nil {
+		identity.Labels = map[string]string{}
+	}
+
+	for key, value := range update.Labels {
+		if value == nil {
+			delete(identity.Labels, key)
+			continue
+		}
+		identity.Labels[key] = *value
+	}
+}
+
+// Returns the ids that have provider data but are not registered
+func Orphaned(registered []db.Identity, ids []string) []string {
+	known := map[string]bool{}
+	for _, identity := range registered {
+		known[identity.ID] = true
+	}
+
+	orphaned := []string{}
+	for _, id := range ids {
+		if !known[id] && !slices.Contains(orphaned, id) {
+			orphaned = append(orphaned, id)
+		}
+	}
+
+	slices.Sort(orphaned)
+	return orphaned
+}
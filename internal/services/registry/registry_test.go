@@ -0,0 +1,28 @@
+package registry_test
+
+import (
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateID(t *testing.T) {
+	assert.NoError(t, registry.ValidateID("customer0"))
+
+	assert.ErrorIs(t, registry.ValidateID(""), registry.ErrInvalidIdentity)
+	assert.ErrorIs(t, registry.ValidateID("env:customer0"), registry.ErrInvalidIdentity)
+	assert.ErrorIs(t, registry.ValidateID("customer/0"), registry.ErrInvalidIdentity)
+}
+
+func TestOrphaned(t *testing.T) {
+	registered := []db.Identity{{ID: "customer0"}, {ID: "customer1"}}
+
+	// Identities with both facts and secrets are only reported once
+	ids := []string{"legacy1", "customer0", "legacy0", "legacy1"}
+	assert.Equal(t, []string{"legacy0", "legacy1"}, registry.Orphaned(registered, ids))
+
+	assert.Empty(t, registry.Orphaned(registered, []string{"customer1"}))
+	assert.Empty(t, registry.Orphaned(nil, nil))
+}
@@ -0,0 +1,204 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"gorm.io/gorm"
+)
+
+const (
+	OperationSet    = "set"
+	OperationDelete = "delete"
+)
+
+const (
+	StatusPending = "pending"
+	StatusFailed  = "failed"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+
+	// A due change no longer matches the fact schemas and is not applied
+	ErrScheduleViolatesSchema = errors.New("scheduled change violates fact schema")
+)
+
+var _ facts.FactObserver = &ScheduleService{}
+
+// Stores fact changes that should happen later and applies them once they are due.
+// Schedules are kept in the user database so they work with every fact provider and
+// survive restarts.
+type ScheduleService struct {
+	DBClient *gorm.DB
+
+	// Provider changes are applied through so they are recorded like any other change
+	FactProvider facts.FactProvider
+
+	// Scheduled changes are checked again when applied since schemas can change in between
+	SchemaService *schema.SchemaService
+}
+
+// Returns when a fact set with the ttl or expires_at should be deleted. Nil means it does not expire.
+func ExpiresAt(now time.Time, ttl string, expiresAt *time.Time) (*time.Time, error) {
+	if ttl != "" && expiresAt != nil {
+		return nil, fmt.Errorf("%w: only one of ttl and expires_at can be set", ErrInvalidSchedule)
+	}
+
+	if expiresAt != nil {
+		if !expiresAt.After(now) {
+			return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidSchedule)
+		}
+		return expiresAt, nil
+	}
+
+	if ttl == "" {
+		return nil, nil
+	}
+
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchedule, err)
+	}
+
+	if duration <= 0 {
+		return nil, fmt.Errorf("%w: ttl must be positive", ErrInvalidSchedule)
+	}
+
+	at := now.Add(duration)
+	return &at, nil
+}
+
+// Returns the schedules of the identity that have not been applied yet ordered by when they run
+func (ss *ScheduleService) GetSchedules(ctx context.Context, identity string) ([]db.FactSchedule, error) {
+	schedules := []db.FactSchedule{}
+
+	err := ss.DBClient.WithContext(ctx).Where("identity = ?", identity).Order("run_at, id").Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// Schedules setting the fact to the value at the given time. A nil value deletes the fact.
+func (ss *ScheduleService) CreateSchedule(ctx context.Context, identity string, env string, key string, value *facts.Fact, at time.Time) (*db.FactSchedule, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%w: key is required", ErrInvalidSchedule)
+	}
+
+	if at.IsZero() {
+		return nil, fmt.Errorf("%w: at is required", ErrInvalidSchedule)
+	}
+
+	if env != "" {
+		if err := facts.CheckEnvironment(env); err != nil {
+			return nil, err
+		}
+	}
+
+	schedule := db.FactSchedule{
+		Identity:    identity,
+		Key:         key,
+		Environment: env,
+		Operation:   OperationSet,
+		Value:       value,
+		Actor:       requestmeta.Caller(ctx),
+		Status:      StatusPending,
+		RunAt:       at,
+	}
+	if value == nil {
+		schedule.Operation = OperationDelete
+	}
+
+	err := ss.DBClient.WithContext(ctx).Create(&schedule).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+// Cancels a schedule of the identity
+func (ss *ScheduleService) DeleteSchedule(ctx context.Context, identity string, id uint) error {
+	res := ss.DBClient.WithContext(ctx).Where("identity = ? AND id = ?", identity, id).Delete(&db.FactSchedule{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Replaces the pending expiration of the fact. The expiration only deletes the fact while
+// it still holds the value. A nil time only removes the pending expiration so a fact set
+// again without a ttl no longer expires.
+func (ss *ScheduleService) SetExpiration(ctx context.Context, identity string, env string, key string, value *facts.Fact, at *time.Time) error {
+	return ss.DBClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("identity = ? AND environment = ? AND key = ? AND expiration = ?", identity, env, key, true).Delete(&db.FactSchedule{}).Error
+		if err != nil || at == nil {
+			return err
+		}
+
+		return tx.Create(&db.FactSchedule{
+			Identity:    identity,
+			Key:         key,
+			Environment: env,
+			Operation:   OperationDelete,
+			Value:       value,
+			Expiration:  true,
+			Actor:       requestmeta.Caller(ctx),
+			Status:      StatusPending,
+			RunAt:       *at,
+		}).Error
+	})
+}
+
+type expirationContextKey struct{}
+
+type pendingExpiration struct {
+	identity string
+	env      string
+	key      string
+	at       time.Time
+}
+
+// Returns a context for setting the fact with an expiration. The expiration is stored
+// when the write is recorded, after the fact was written. The write returns
+// facts.ErrChangeNotRecorded when the expiration could not be stored, and a failed write
+// never leaves an expiration behind.
+func WithExpiration(ctx context.Context, identity string, env string, key string, at time.Time) context.Context {
+	return context.WithValue(ctx, expirationContextKey{}, pendingExpiration{
+		identity: identity,
+		env:      env,
+		key:      key,
+		at:       at,
+	})
+}
+
+// ObserveFactChange implements facts.FactObserver. Every write replaces the value the
+// pending expiration of the fact was set for so the expiration is removed, or replaced
+// when the fact was set with WithExpiration.
+func (ss *ScheduleService) ObserveFactChange(ctx context.Context, change facts.FactChange) error {
+	var at *time.Time
+	expiration, ok := ctx.Value(expirationContextKey{}).(pendingExpiration)
+	if ok && change.Operation == facts.ChangeOperationSet && expiration.identity == change.Identity && expiration.env == change.Environment && expiration.key == change.Key {
+		at = &expiration.at
+	}
+
+	return ss.SetExpiration(ctx, change.Identity, change.Environment, change.Key, change.NewValue, at)
+}
+
+// Cancels every schedule of the identity
+func (ss *ScheduleService) DeleteIdentitySchedules(ctx context.Context, identity string) error {
+	return ss.DBClient.WithContext(ctx).Where("identity = ?", identity).Delete(&db.FactSchedule{}).Error
+}
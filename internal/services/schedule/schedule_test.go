@@ -0,0 +1,45 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/services/schedule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	later := now.Add(2 * time.Hour)
+	earlier := now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		ttl       string
+		expiresAt *time.Time
+		want      *time.Time
+		wantErr   bool
+	}{
+		{name: "no expiration"},
+		{name: "ttl", ttl: "2h", want: &later},
+		{name: "expires at", expiresAt: &later, want: &later},
+		{name: "both", ttl: "2h", expiresAt: &later, wantErr: true},
+		{name: "invalid ttl", ttl: "tomorrow", wantErr: true},
+		{name: "negative ttl", ttl: "-1h", wantErr: true},
+		{name: "expires in the past", expiresAt: &earlier, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schedule.ExpiresAt(now, tt.ttl, tt.expiresAt)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, schedule.ErrInvalidSchedule)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
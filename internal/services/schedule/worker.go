@@ -0,0 +1,203 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/requestmeta"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Number of attempts before a schedule is marked as failed
+	maxScheduleAttempts = 5
+
+	// Number of schedules claimed by a worker at once
+	scheduleBatchSize = 50
+
+	// How long a claimed schedule is hidden from other workers while it is applied
+	scheduleLease = time.Minute
+
+	pollInterval = time.Second
+)
+
+// Applies due schedules until ctx is done. Safe to run on every replica at once.
+func (ss *ScheduleService) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ss.applyDue(ctx); err != nil {
+				logrus.WithError(err).Error("could not apply fact schedules")
+			}
+		}
+	}
+}
+
+// Claims due schedules by pushing back when they run so the lock is not held while applying them
+func (ss *ScheduleService) claimSchedules(ctx context.Context) ([]db.FactSchedule, error) {
+	schedules := []db.FactSchedule{}
+
+	err := ss.DBClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ?", StatusPending, time.Now()).
+			Order("run_at, id").
+			Limit(scheduleBatchSize).
+			Find(&schedules).Error
+		if err != nil || len(schedules) == 0 {
+			return err
+		}
+
+		ids := []uint{}
+		for _, schedule := range schedules {
+			ids = append(ids, schedule.ID)
+		}
+
+		return tx.Model(&db.FactSchedule{}).Where("id IN ?", ids).UpdateColumn("run_at", time.Now().Add(scheduleLease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (ss *ScheduleService) applyDue(ctx context.Context) error {
+	schedules, err := ss.claimSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		applyErr := ss.apply(ctx, schedule)
+		if err := ss.recordAttempt(ctx, schedule, applyErr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Applies the change as the caller that scheduled it
+func (ss *ScheduleService) apply(ctx context.Context, schedule db.FactSchedule) error {
+	ctx = requestmeta.WithCaller(ctx, schedule.Actor)
+
+	if schedule.Expiration && schedule.Value != nil {
+		return ss.expire(ctx, schedule)
+	}
+
+	violations, err := ss.validate(schedule)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w: %v", ErrScheduleViolatesSchema, violations)
+	}
+
+	if schedule.Operation == OperationSet && schedule.Value != nil {
+		if schedule.Environment != "" {
+			return ss.FactProvider.SetIdentityEnvironmentFact(ctx, schedule.Identity, schedule.Environment, schedule.Key, *schedule.Value)
+		}
+		return ss.FactProvider.SetIdentityFact(ctx, schedule.Identity, schedule.Key, *schedule.Value)
+	}
+
+	if schedule.Environment != "" {
+		return ss.FactProvider.DeleteIdentityEnvironmentFact(ctx, schedule.Identity, schedule.Environment, schedule.Key)
+	}
+	return ss.FactProvider.DeleteIdentityFact(ctx, schedule.Identity, schedule.Key)
+}
+
+// Deletes the fact only while it still holds the value it was set with the expiration for.
+// An expiration left behind by a write that was not recorded is dropped without deleting
+// the newer value.
+func (ss *ScheduleService) expire(ctx context.Context, schedule db.FactSchedule) error {
+	if schedule.Environment != "" {
+		overrides, err := ss.FactProvider.GetIdentityEnvironmentFacts(ctx, schedule.Identity, schedule.Environment)
+		if errors.Is(err, facts.ErrIdentityNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if current, ok := overrides[schedule.Key]; !ok || current != *schedule.Value {
+			return nil
+		}
+
+		return ss.FactProvider.DeleteIdentityEnvironmentFact(ctx, schedule.Identity, schedule.Environment, schedule.Key)
+	}
+
+	current, err := ss.FactProvider.GetIdentityFacts(ctx, schedule.Identity)
+	if errors.Is(err, facts.ErrIdentityNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if value, ok := current[schedule.Key]; !ok || value != *schedule.Value {
+		return nil
+	}
+
+	violations, err := ss.SchemaService.ValidateDelete(schedule.Identity, schedule.Key)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w: %v", ErrScheduleViolatesSchema, violations)
+	}
+
+	// A write between the read and the delete leaves the expiration to be retried
+	return ss.FactProvider.UpdateIdentityFactsIfMatch(ctx, schedule.Identity, facts.Version(current), nil, []string{schedule.Key})
+}
+
+// Checks the change against the current schemas the same way writes through the api are checked
+func (ss *ScheduleService) validate(schedule db.FactSchedule) ([]schema.Violation, error) {
+	if schedule.Operation == OperationSet && schedule.Value != nil {
+		return ss.SchemaService.ValidateFact(schedule.Identity, schedule.Key, *schedule.Value)
+	}
+
+	// Deleting an override falls back to the base fact so required facts stay set
+	if schedule.Environment != "" {
+		return nil, nil
+	}
+	return ss.SchemaService.ValidateDelete(schedule.Identity, schedule.Key)
+}
+
+// Removes applied schedules and retries failed ones until they run out of attempts
+func (ss *ScheduleService) recordAttempt(ctx context.Context, schedule db.FactSchedule, applyErr error) error {
+	if applyErr == nil {
+		return ss.DBClient.WithContext(ctx).Delete(&schedule).Error
+	}
+
+	schedule.Attempts++
+	schedule.LastError = applyErr.Error()
+	// Retrying does not help until the schedule or the schema is changed
+	if schedule.Attempts >= maxScheduleAttempts || errors.Is(applyErr, ErrScheduleViolatesSchema) {
+		schedule.Status = StatusFailed
+	} else {
+		schedule.RunAt = time.Now().Add(time.Duration(schedule.Attempts) * 10 * time.Second)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"schedule_id": schedule.ID,
+		"id":          schedule.Identity,
+		"key":         schedule.Key,
+		"attempts":    schedule.Attempts,
+	}).WithError(applyErr).Warn("could not apply fact schedule")
+
+	return ss.DBClient.WithContext(ctx).Save(&schedule).Error
+}
@@ -0,0 +1,273 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// Handles managing fact schemas and validating facts against them
+type SchemaService struct {
+	DBClient *gorm.DB
+}
+
+// A single reason a fact does not match its schema
+type Violation struct {
+	Identity string `json:"identity"`
+	Key      string `json:"key"`
+	Message  string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Key, v.Message)
+}
+
+// TODO Add pagination
+func (ss *SchemaService) GetSchemas() ([]db.FactSchema, error) {
+	schemas := []db.FactSchema{}
+
+	err := ss.DBClient.Order("key, identity_prefix").Find(&schemas).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// Creates the schema or replaces the existing schema with the same key and identity prefix
+func (ss *SchemaService) UpsertSchema(schema db.FactSchema) (*db.FactSchema, error) {
+	if err := checkSchema(schema); err != nil {
+		return nil, err
+	}
+
+	err := ss.DBClient.Transaction(func(tx *gorm.DB) error {
+		existing := db.FactSchema{}
+		err := tx.Where("key = ? AND identity_prefix = ?", schema.Key, schema.IdentityPrefix).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		schema.ID = existing.ID
+		schema.CreatedAt = existing.CreatedAt
+		return tx.Save(&schema).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+func (ss *SchemaService) DeleteSchema(id uint) error {
+	// Hard delete so the key and prefix can be defined again
+	res := ss.DBClient.Unscoped().Delete(&db.FactSchema{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Validates setting a single fact on an identity
+func (ss *SchemaService) ValidateFact(identity string, key string, value facts.Fact) ([]Violation, error) {
+	schemas, err := ss.GetSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	return validateFact(schemasForIdentity(schemas, identity), identity, key, value), nil
+}
+
+// Validates deleting a single fact from an identity
+func (ss *SchemaService) ValidateDelete(identity string, key string) ([]Violation, error) {
+	schemas, err := ss.GetSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	schema, ok := schemasForIdentity(schemas, identity)[key]
+	if ok && schema.Required {
+		return []Violation{{Identity: identity, Key: key, Message: "fact is required"}}, nil
+	}
+
+	return nil, nil
+}
+
+// Validates setting and deleting multiple facts of an identity at once
+func (ss *SchemaService) ValidateUpdate(identity string, set facts.Facts, remove []string) ([]Violation, error) {
+	schemas, err := ss.GetSchemas()
+	if err != nil {
+		return nil, err
+	}
+	scoped := schemasForIdentity(schemas, identity)
+
+	violations := []Violation{}
+	for _, key := range slices.Sorted(maps.Keys(set)) {
+		violations = append(violations, validateFact(scoped, identity, key, set[key])...)
+	}
+
+	for _, key := range remove {
+		if schema, ok := scoped[key]; ok && schema.Required {
+			violations = append(violations, Violation{Identity: identity, Key: key, Message: "fact is required"})
+		}
+	}
+
+	return violations, nil
+}
+
+// The result of validating every identity against the schemas
+type Report struct {
+	Identities int         `json:"identities"`
+	Invalid    int         `json:"invalid"`
+	Violations []Violation `json:"violations"`
+}
+
+// Validates the facts of every identity in the provider
+func (ss *SchemaService) ValidateAllIdentities(ctx context.Context, provider facts.FactProvider) (*Report, error) {
+	schemas, err := ss.GetSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := provider.GetAllIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+
+	report := &Report{
+		Identities: len(ids),
+		Violations: []Violation{},
+	}
+	for _, id := range ids {
+		identityFacts, err := provider.GetIdentityFacts(ctx, id)
+		if err != nil {
+			if errors.Is(err, facts.ErrIdentityNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		violations := ValidateIdentity(schemas, id, identityFacts)
+		if len(violations) > 0 {
+			report.Invalid++
+			report.Violations = append(report.Violations, violations...)
+		}
+	}
+
+	return report, nil
+}
+
+// Validates all facts of an identity including that required facts are present
+func ValidateIdentity(schemas []db.FactSchema, identity string, identityFacts facts.Facts) []Violation {
+	scoped := schemasForIdentity(schemas, identity)
+
+	keys := []string{}
+	for key := range identityFacts {
+		keys = append(keys, key)
+	}
+	for key := range scoped {
+		if _, ok := identityFacts[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	violations := []Violation{}
+	for _, key := range keys {
+		value, ok := identityFacts[key]
+		if !ok {
+			if scoped[key].Required {
+				violations = append(violations, Violation{Identity: identity, Key: key, Message: "fact is required"})
+			}
+			continue
+		}
+
+		violations = append(violations, validateFact(scoped, identity, key, value)...)
+	}
+
+	return violations
+}
+
+// Returns the schemas that apply to the identity by key. When a key is defined
+// more than once the schema with the longest matching prefix is used.
+func schemasForIdentity(schemas []db.FactSchema, identity string) map[string]db.FactSchema {
+	scoped := map[string]db.FactSchema{}
+	for _, schema := range schemas {
+		if !strings.HasPrefix(identity, schema.IdentityPrefix) {
+			continue
+		}
+
+		existing, ok := scoped[schema.Key]
+		if ok && len(existing.IdentityPrefix) >= len(schema.IdentityPrefix) {
+			continue
+		}
+
+		scoped[schema.Key] = schema
+	}
+
+	return scoped
+}
+
+func validateFact(scoped map[string]db.FactSchema, identity string, key string, value facts.Fact) []Violation {
+	// Identities without any schemas are not restricted
+	if len(scoped) == 0 {
+		return nil
+	}
+
+	schema, ok := scoped[key]
+	if !ok {
+		return []Violation{{Identity: identity, Key: key, Message: "fact key is not defined in any schema"}}
+	}
+
+	violations := []Violation{}
+	if schema.Type != "" && facts.FactType(schema.Type) != value.Type {
+		violations = append(violations, Violation{Identity: identity, Key: key, Message: fmt.Sprintf("expected type %s but got %s", schema.Type, value.Type)})
+	}
+
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value.String()) {
+		violations = append(violations, Violation{Identity: identity, Key: key, Message: fmt.Sprintf("value must be one of %s", strings.Join(schema.Enum, ", "))})
+	}
+
+	if schema.Pattern != "" {
+		// Patterns are checked when the schema is saved
+		matched, _ := regexp.MatchString(schema.Pattern, value.String())
+		if !matched {
+			violations = append(violations, Violation{Identity: identity, Key: key, Message: fmt.Sprintf("value must match %s", schema.Pattern)})
+		}
+	}
+
+	return violations
+}
+
+func checkSchema(schema db.FactSchema) error {
+	if schema.Key == "" {
+		return fmt.Errorf("%w: key must not be empty", ErrInvalidSchema)
+	}
+
+	if schema.Type != "" && !slices.Contains(facts.FactTypes, facts.FactType(schema.Type)) {
+		return fmt.Errorf("%w: %w %s", ErrInvalidSchema, facts.ErrInvalidFactType, schema.Type)
+	}
+
+	if schema.Pattern != "" {
+		if _, err := regexp.Compile(schema.Pattern); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package schema_test
+
+import (
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSchemas = []db.FactSchema{
+	{Key: "cluster", Type: "string", Enum: []string{"us-east", "eu-west"}, Required: true},
+	{Key: "max_replicas", Type: "number"},
+	{Key: "owner", Pattern: "^team-[a-z]+$"},
+	{Key: "cluster", IdentityPrefix: "internal-", Type: "string"},
+}
+
+func TestValidateIdentity(t *testing.T) {
+	var tests = []struct {
+		name               string
+		schemas            []db.FactSchema
+		identity           string
+		facts              facts.Facts
+		expectedViolations []schema.Violation
+	}{
+		{
+			name:     "valid facts",
+			schemas:  testSchemas,
+			identity: "customer0",
+			facts: facts.Facts{
+				"cluster":      facts.NewStringFact("us-east"),
+				"max_replicas": {Type: facts.FactTypeNumber, Value: "3"},
+				"owner":        facts.NewStringFact("team-a"),
+			},
+			expectedViolations: []schema.Violation{},
+		},
+		{
+			name:     "unknown key",
+			schemas:  testSchemas,
+			identity: "customer0",
+			facts: facts.Facts{
+				"cluster": facts.NewStringFact("us-east"),
+				"clsuter": facts.NewStringFact("us-east"),
+			},
+			expectedViolations: []schema.Violation{
+				{Identity: "customer0", Key: "clsuter", Message: "fact key is not defined in any schema"},
+			},
+		},
+		{
+			name:     "wrong type, enum and pattern",
+			schemas:  testSchemas,
+			identity: "customer0",
+			facts: facts.Facts{
+				"cluster":      facts.NewStringFact("ap-south"),
+				"max_replicas": facts.NewStringFact("3"),
+				"owner":        facts.NewStringFact("alice"),
+			},
+			expectedViolations: []schema.Violation{
+				{Identity: "customer0", Key: "cluster", Message: "value must be one of us-east, eu-west"},
+				{Identity: "customer0", Key: "max_replicas", Message: "expected type number but got string"},
+				{Identity: "customer0", Key: "owner", Message: "value must match ^team-[a-z]+$"},
+			},
+		},
+		{
+			name:     "missing required fact",
+			schemas:  testSchemas,
+			identity: "customer0",
+			facts: facts.Facts{
+				"owner": facts.NewStringFact("team-a"),
+			},
+			expectedViolations: []schema.Violation{
+				{Identity: "customer0", Key: "cluster", Message: "fact is required"},
+			},
+		},
+		{
+			name:     "prefixed schema takes precedence",
+			schemas:  testSchemas,
+			identity: "internal-tools",
+			facts: facts.Facts{
+				"cluster": facts.NewStringFact("office"),
+			},
+			expectedViolations: []schema.Violation{},
+		},
+		{
+			name:     "no schemas allows anything",
+			schemas:  []db.FactSchema{},
+			identity: "customer0",
+			facts: facts.Facts{
+				"clsuter": facts.NewStringFact("us-east"),
+			},
+			expectedViolations: []schema.Violation{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := schema.ValidateIdentity(tt.schemas, tt.identity, tt.facts)
+			assert.Equal(t, tt.expectedViolations, violations)
+		})
+	}
+}
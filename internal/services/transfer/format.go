@@ -0,0 +1,318 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatYAML  = "yaml"
+	FormatCSV   = "csv"
+)
+
+var Formats = []string{FormatJSONL, FormatYAML, FormatCSV}
+
+var ErrInvalidFormat = errors.New("invalid format")
+
+// Maximum size of a single JSON Lines record
+const maxRecordSize = 16 << 20
+
+// The facts and secrets of a single identity. Secrets is nil when secrets were not exported.
+type Record struct {
+	Identity string      `json:"identity" yaml:"identity"`
+	Facts    facts.Facts `json:"facts" yaml:"facts"`
+
+	// Overrides of the facts by environment. Nil when the identity has no overrides.
+	Environments map[string]facts.Facts `json:"environments,omitempty" yaml:"environments,omitempty"`
+
+	Secrets secrets.Secrets `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+}
+
+// Columns of the CSV format. Each row holds a single fact or secret. Facts with an
+// environment are overrides. Files without the environment column are still read.
+var csvHeader = []string{"identity", "kind", "key", "type", "value", "environment"}
+
+const (
+	csvKindFact   = "fact"
+	csvKindSecret = "secret"
+)
+
+// Writes records one at a time so exports can be streamed
+type RecordWriter interface {
+	Write(record Record) error
+
+	// Flushes any buffered output
+	Close() error
+}
+
+// Returns the content type used for the format
+func ContentType(format string) string {
+	switch format {
+	case FormatYAML:
+		return "application/yaml"
+	case FormatCSV:
+		return "text/csv"
+	default:
+		return "application/jsonl"
+	}
+}
+
+func NewRecordWriter(format string, w io.Writer) (RecordWriter, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatYAML:
+		return &yamlWriter{w: w}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, format)
+	}
+}
+
+// Reads every record from r
+func ReadRecords(format string, r io.Reader) ([]Record, error) {
+	switch format {
+	case FormatJSONL:
+		return readJSONL(r)
+	case FormatYAML:
+		return readYAML(r)
+	case FormatCSV:
+		return readCSV(r)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, format)
+	}
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonlWriter) Write(record Record) error {
+	return j.encoder.Encode(record)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+func readJSONL(r io.Reader) ([]Record, error) {
+	records := []Record{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Writes each record as its own YAML document
+type yamlWriter struct {
+	w io.Writer
+}
+
+func (y *yamlWriter) Write(record Record) error {
+	out, err := yaml.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(y.w, "---\n"); err != nil {
+		return err
+	}
+
+	_, err = y.w.Write(out)
+	return err
+}
+
+func (y *yamlWriter) Close() error {
+	return nil
+}
+
+func readYAML(r io.Reader) ([]Record, error) {
+	records := []Record{}
+
+	decoder := yaml.NewDecoder(r)
+	for {
+		record := Record{}
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", len(records)+1, err)
+		}
+
+		// Null values are decoded as empty facts without calling Fact.UnmarshalYAML
+		for key, fact := range record.Facts {
+			if fact.Type == "" {
+				return nil, fmt.Errorf("document %d: fact %s must not be null", len(records)+1, key)
+			}
+		}
+
+		records = append(records, record)
+	}
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvWriter) Write(record Record) error {
+	if !c.headerWritten {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+
+	if err := c.writeFacts(record.Identity, "", record.Facts); err != nil {
+		return err
+	}
+
+	for _, env := range slices.Sorted(maps.Keys(record.Environments)) {
+		if err := c.writeFacts(record.Identity, env, record.Environments[env]); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(record.Secrets)) {
+		if err := c.w.Write([]string{record.Identity, csvKindSecret, key, "", record.Secrets[key], ""}); err != nil {
+			return err
+		}
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) writeFacts(identity string, env string, identityFacts facts.Facts) error {
+	for _, key := range slices.Sorted(maps.Keys(identityFacts)) {
+		fact := identityFacts[key]
+		factType := fact.Type
+		if factType == "" {
+			factType = facts.FactTypeString
+		}
+
+		if err := c.w.Write([]string{identity, csvKindFact, key, string(factType), fact.Value, env}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	if !c.headerWritten {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Groups the rows of each identity into a record keeping the order identities first appear in
+func readCSV(r io.Reader) ([]Record, error) {
+	// Every row must have as many fields as the header
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return []Record{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Exports from before environment overrides have no environment column
+	if len(header) < len(csvHeader)-1 || !slices.Equal(header, csvHeader[:min(len(header), len(csvHeader))]) {
+		return nil, fmt.Errorf("expected header %v", csvHeader)
+	}
+
+	records := []Record{}
+	index := map[string]int{}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		identity, kind, key, factType, value := row[0], row[1], row[2], row[3], row[4]
+		line, _ := reader.FieldPos(0)
+
+		env := ""
+		if len(row) > 5 {
+			env = row[5]
+		}
+
+		i, ok := index[identity]
+		if !ok {
+			i = len(records)
+			index[identity] = i
+			records = append(records, Record{Identity: identity, Facts: facts.Facts{}})
+		}
+
+		switch kind {
+		case csvKindFact:
+			fact, err := facts.ParseFact(facts.FactType(factType), value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			if env == "" {
+				records[i].Facts[key] = fact
+				continue
+			}
+
+			if records[i].Environments == nil {
+				records[i].Environments = map[string]facts.Facts{}
+			}
+			if records[i].Environments[env] == nil {
+				records[i].Environments[env] = facts.Facts{}
+			}
+			records[i].Environments[env][key] = fact
+		case csvKindSecret:
+			if env != "" {
+				return nil, fmt.Errorf("line %d: secrets have no environment", line)
+			}
+
+			if records[i].Secrets == nil {
+				records[i].Secrets = secrets.Secrets{}
+			}
+			records[i].Secrets[key] = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %s", line, kind)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package transfer_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/transfer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRecords = []transfer.Record{
+	{
+		Identity: "customer0",
+		Facts: facts.Facts{
+			"tier":     facts.NewStringFact("gold"),
+			"replicas": {Type: facts.FactTypeNumber, Value: "3"},
+			"ratio":    {Type: facts.FactTypeNumber, Value: "0.5"},
+			"ha":       {Type: facts.FactTypeBool, Value: "true"},
+			"limits":   {Type: facts.FactTypeJSON, Value: `{"cpu":2,"regions":["us-east-1"]}`},
+			"version":  facts.NewStringFact("10"),
+		},
+		Environments: map[string]facts.Facts{
+			"prod": {"replicas": {Type: facts.FactTypeNumber, Value: "5"}},
+		},
+		Secrets: secrets.Secrets{"db_password": "hunter2"},
+	},
+	{
+		Identity: "customer1",
+		Facts:    facts.Facts{"tier": facts.NewStringFact("silver, with comma")},
+	},
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, format := range transfer.Formats {
+		t.Run(format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w, err := transfer.NewRecordWriter(format, buf)
+			require.NoError(t, err)
+
+			for _, record := range testRecords {
+				require.NoError(t, w.Write(record))
+			}
+			require.NoError(t, w.Close())
+
+			records, err := transfer.ReadRecords(format, buf)
+			require.NoError(t, err)
+			assert.Equal(t, testRecords, records)
+		})
+	}
+}
+
+func TestReadRecordsInvalid(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+	}{
+		{format: transfer.FormatJSONL, input: `{"identity": "customer0", "facts": {"tier": null}}`},
+		{format: transfer.FormatYAML, input: "identity: customer0\nfacts:\n  tier: null\n"},
+		{format: transfer.FormatCSV, input: "identity,kind,key,type,value\ncustomer0,fact,replicas,number,three\n"},
+		{format: transfer.FormatCSV, input: "identity,kind,key,type,value\ncustomer0,other,tier,string,gold\n"},
+		{format: transfer.FormatCSV, input: "id,kind,key,type,value\n"},
+		{format: transfer.FormatCSV, input: "identity,kind,key,type,value,environment\ncustomer0,secret,token,,abc,prod\n"},
+		{format: "xml", input: ""},
+	}
+
+	for _, tt := range tests {
+		_, err := transfer.ReadRecords(tt.format, strings.NewReader(tt.input))
+		assert.Error(t, err, "%s: %s", tt.format, tt.input)
+	}
+}
+
+func TestReadCSVWithoutEnvironment(t *testing.T) {
+	records, err := transfer.ReadRecords(transfer.FormatCSV, strings.NewReader("identity,kind,key,type,value\ncustomer0,fact,tier,string,gold\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []transfer.Record{{Identity: "customer0", Facts: facts.Facts{"tier": facts.NewStringFact("gold")}}}, records)
+}
@@ -0,0 +1,312 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"github.com/graytonio/flagops-data-store/internal/utils"
+)
+
+const (
+	// Sets the facts and secrets in each record leaving other keys unchanged
+	ModeMerge = "merge"
+
+	// Replaces all facts, and overrides and secrets when included, of each identity in the import
+	ModeReplace = "replace"
+
+	// Reports the changes a merge would make without writing anything
+	ModeDryRun = "dry-run"
+)
+
+var Modes = []string{ModeMerge, ModeReplace, ModeDryRun}
+
+var ErrInvalidMode = errors.New("invalid import mode")
+
+// Number of identities read from the providers at once while exporting
+const exportBatchSize = 100
+
+// Exports and imports identities across any combination of fact and secret providers
+type TransferService struct {
+	FactProvider   facts.FactProvider
+	SecretProvider secrets.SecretProvider
+	SchemaService  *schema.SchemaService
+}
+
+type ExportOptions struct {
+	// Only export identities starting with this prefix
+	Prefix string
+
+	// Include the secrets of each identity
+	Secrets bool
+}
+
+// Writes a record for every identity and returns the identities whose secrets were written.
+// Identities are read in batches so the export can be streamed without holding the whole store in memory.
+func (ts *TransferService) Export(ctx context.Context, w RecordWriter, opts ExportOptions) ([]string, error) {
+	ids, err := ts.FactProvider.GetAllIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Secrets {
+		secretIds, err := ts.SecretProvider.GetAllIdentities(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, secretIds...)
+	}
+
+	ids = slices.DeleteFunc(utils.RemoveDuplicate(ids), func(id string) bool {
+		return !strings.HasPrefix(id, opts.Prefix)
+	})
+	slices.Sort(ids)
+
+	exportedSecrets := []string{}
+	for batch := range slices.Chunk(ids, exportBatchSize) {
+		identityFacts, err := facts.GetIdentitiesFacts(ctx, ts.FactProvider, batch)
+		if err != nil {
+			return exportedSecrets, err
+		}
+
+		identitySecrets := map[string]secrets.Secrets{}
+		if opts.Secrets {
+			identitySecrets, err = secrets.GetIdentitiesSecrets(ctx, ts.SecretProvider, batch)
+			if err != nil {
+				return exportedSecrets, err
+			}
+		}
+
+		for _, id := range batch {
+			record := Record{Identity: id, Facts: identityFacts[id]}
+			if record.Facts == nil {
+				record.Facts = facts.Facts{}
+			}
+
+			overrides, err := facts.GetIdentityOverrides(ctx, ts.FactProvider, id)
+			if err != nil {
+				return exportedSecrets, err
+			}
+			if len(overrides) > 0 {
+				record.Environments = overrides
+			}
+
+			if opts.Secrets {
+				record.Secrets = identitySecrets[id]
+			}
+
+			if err := w.Write(record); err != nil {
+				return exportedSecrets, err
+			}
+
+			if len(record.Secrets) > 0 {
+				exportedSecrets = append(exportedSecrets, id)
+			}
+		}
+	}
+
+	return exportedSecrets, w.Close()
+}
+
+// Keys that an import adds, changes or removes. Values are left out so secrets are never reported.
+type Diff struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// The result of importing a single identity
+type IdentityResult struct {
+	Identity string `json:"identity"`
+	Facts    Diff   `json:"facts"`
+
+	// Overrides keyed by env:key. Nil when the record has no environment overrides.
+	Environments *Diff `json:"environments,omitempty"`
+
+	// Nil when the record has no secrets
+	Secrets *Diff `json:"secrets,omitempty"`
+
+	Violations []schema.Violation `json:"violations,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	Mode       string           `json:"mode"`
+	Identities []IdentityResult `json:"identities"`
+
+	// Number of identities that were not imported because of an error or schema violation
+	Failed int `json:"failed"`
+}
+
+// Compares the current values with the imported ones. When replace is set keys
+// missing from the import are removed.
+func diffValues[V comparable](current map[string]V, imported map[string]V, replace bool) Diff {
+	diff := Diff{Added: []string{}, Changed: []string{}, Removed: []string{}}
+
+	for _, key := range slices.Sorted(maps.Keys(imported)) {
+		old, ok := current[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case old != imported[key]:
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+
+	if replace {
+		for _, key := range slices.Sorted(maps.Keys(current)) {
+			if _, ok := imported[key]; !ok {
+				diff.Removed = append(diff.Removed, key)
+			}
+		}
+	}
+
+	return diff
+}
+
+// Imports each record in turn. Each identity's facts are written atomically and an
+// identity failing validation is reported and skipped without stopping the import.
+func (ts *TransferService) Import(ctx context.Context, records []Record, mode string) (*ImportReport, error) {
+	if !slices.Contains(Modes, mode) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMode, mode)
+	}
+
+	report := &ImportReport{Mode: mode, Identities: []IdentityResult{}}
+	for _, record := range records {
+		result := ts.importRecord(ctx, record, mode)
+		if result.Error != "" || len(result.Violations) > 0 {
+			report.Failed++
+		}
+		report.Identities = append(report.Identities, result)
+	}
+
+	return report, nil
+}
+
+func (ts *TransferService) importRecord(ctx context.Context, record Record, mode string) IdentityResult {
+	result := IdentityResult{Identity: record.Identity}
+	if record.Identity == "" {
+		result.Error = "identity must not be empty"
+		return result
+	}
+
+	currentFacts, err := ts.FactProvider.GetIdentityFacts(ctx, record.Identity)
+	if err != nil && !errors.Is(err, facts.ErrIdentityNotFound) {
+		result.Error = err.Error()
+		return result
+	}
+
+	replace := mode == ModeReplace
+	result.Facts = diffValues(currentFacts, record.Facts, replace)
+
+	set := facts.Facts{}
+	for _, key := range append(result.Facts.Added, result.Facts.Changed...) {
+		set[key] = record.Facts[key]
+	}
+
+	result.Violations, err = ts.SchemaService.ValidateUpdate(record.Identity, set, result.Facts.Removed)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var currentOverrides, importedOverrides facts.Facts
+	if record.Environments != nil {
+		currentOverrides, err = facts.GetIdentityOverridesByKey(ctx, ts.FactProvider, record.Identity)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		importedOverrides = facts.Facts{}
+		for env, envFacts := range record.Environments {
+			if err := facts.CheckEnvironment(env); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+
+			for key, value := range envFacts {
+				violations, err := ts.SchemaService.ValidateFact(record.Identity, key, value)
+				if err != nil {
+					result.Error = err.Error()
+					return result
+				}
+				result.Violations = append(result.Violations, violations...)
+				importedOverrides[facts.OverrideKey(env, key)] = value
+			}
+		}
+
+		overridesDiff := diffValues(currentOverrides, importedOverrides, replace)
+		result.Environments = &overridesDiff
+	}
+
+	var currentSecrets secrets.Secrets
+	if record.Secrets != nil {
+		currentSecrets, err = ts.SecretProvider.GetIdentitySecrets(ctx, record.Identity)
+		if err != nil && !errors.Is(err, secrets.ErrIdentityNotFound) {
+			result.Error = err.Error()
+			return result
+		}
+
+		secretsDiff := diffValues(currentSecrets, record.Secrets, replace)
+		result.Secrets = &secretsDiff
+	}
+
+	if mode == ModeDryRun || len(result.Violations) > 0 {
+		return result
+	}
+
+	if len(set) > 0 || len(result.Facts.Removed) > 0 {
+		err = ts.FactProvider.UpdateIdentityFacts(ctx, record.Identity, set, result.Facts.Removed)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	if result.Environments != nil {
+		for _, override := range append(result.Environments.Added, result.Environments.Changed...) {
+			env, key := facts.SplitOverrideKey(override)
+			if err := ts.FactProvider.SetIdentityEnvironmentFact(ctx, record.Identity, env, key, importedOverrides[override]); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+
+		for _, override := range result.Environments.Removed {
+			env, key := facts.SplitOverrideKey(override)
+			if err := ts.FactProvider.DeleteIdentityEnvironmentFact(ctx, record.Identity, env, key); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+	}
+
+	if result.Secrets != nil {
+		for _, key := range append(result.Secrets.Added, result.Secrets.Changed...) {
+			if err := ts.SecretProvider.SetIdentitySecret(ctx, record.Identity, key, record.Secrets[key]); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+
+		for _, key := range result.Secrets.Removed {
+			if err := ts.SecretProvider.DeleteIdentitySecret(ctx, record.Identity, key); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+	}
+
+	return result
+}
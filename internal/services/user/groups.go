@@ -0,0 +1,100 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidGroupMapping = errors.New("invalid group mapping")
+
+func (ud *UserDataService) GetGroupMappings() ([]db.GroupMapping, error) {
+	mappings := []db.GroupMapping{}
+
+	err := ud.DBClient.Find(&mappings).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+func (ud *UserDataService) CreateGroupMapping(mapping db.GroupMapping) (*db.GroupMapping, error) {
+	if mapping.Group == "" || mapping.PermissionID == "" {
+		return nil, fmt.Errorf("%w: group and permission are required", ErrInvalidGroupMapping)
+	}
+
+	err := ud.DBClient.First(&db.Permission{}, "id = ?", mapping.PermissionID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: no such permission %s", ErrInvalidGroupMapping, mapping.PermissionID)
+		}
+		return nil, err
+	}
+
+	err = ud.DBClient.Create(&mapping).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &mapping, nil
+}
+
+func (ud *UserDataService) DeleteGroupMapping(id uint) error {
+	// Hard delete so the same mapping can be created again
+	res := ud.DBClient.Unscoped().Delete(&db.GroupMapping{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Stores the groups reported by the identity provider and replaces the group
+// permissions of the user with the ones currently mapped to those groups.
+// Manually granted permissions are left untouched.
+func (ud *UserDataService) SyncUserGroups(user *db.User, groups []string) error {
+	mappings, err := ud.GetGroupMappings()
+	if err != nil {
+		return err
+	}
+
+	permissions := []db.Permission{}
+	for _, id := range MappedPermissions(mappings, user.SSOProvider, groups) {
+		permissions = append(permissions, db.Permission{ID: id})
+	}
+
+	return ud.DBClient.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Association("GroupPermissions").Replace(permissions)
+		if err != nil {
+			return err
+		}
+
+		user.Groups = groups
+		return tx.Model(user).Select("Groups").Updates(&db.User{Groups: groups}).Error
+	})
+}
+
+// Returns the ids of the permissions mapped to any of the groups from the provider
+func MappedPermissions(mappings []db.GroupMapping, provider string, groups []string) []string {
+	permissions := []string{}
+	for _, mapping := range mappings {
+		if mapping.Provider != "" && mapping.Provider != provider {
+			continue
+		}
+
+		if !slices.Contains(groups, mapping.Group) || slices.Contains(permissions, mapping.PermissionID) {
+			continue
+		}
+
+		permissions = append(permissions, mapping.PermissionID)
+	}
+	return permissions
+}
@@ -0,0 +1,38 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMappedPermissions(t *testing.T) {
+	mappings := []db.GroupMapping{
+		{Group: "platform", PermissionID: db.FactsWrite},
+		{Group: "platform", PermissionID: db.FactsRead},
+		{Group: "developers", PermissionID: db.FactsRead},
+		{Provider: "github", Group: "acme/admins", PermissionID: db.AdminPermission},
+		{Provider: "keycloak", Group: "security", PermissionID: db.AuditRead},
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		groups   []string
+		want     []string
+	}{
+		{name: "no groups", provider: "keycloak", groups: nil, want: []string{}},
+		{name: "any provider", provider: "keycloak", groups: []string{"platform", "developers"}, want: []string{db.FactsWrite, db.FactsRead}},
+		{name: "provider specific", provider: "keycloak", groups: []string{"security"}, want: []string{db.AuditRead}},
+		{name: "other provider", provider: "okta", groups: []string{"security", "acme/admins"}, want: []string{}},
+		{name: "github team", provider: "github", groups: []string{"acme", "acme/admins"}, want: []string{db.AdminPermission}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, user.MappedPermissions(mappings, tt.provider, tt.groups))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+)
+
+// Prefix of stored identity provider tokens encrypted by a TokenCipher. Tokens stored
+// before they were encrypted have no prefix.
+const encryptedTokenPrefix = "enc:v1:"
+
+var ErrInvalidEncryptedToken = errors.New("invalid encrypted token")
+
+// Encrypts the tokens of identity providers so they are not stored in plain text
+type TokenCipher struct {
+	aead cipher.AEAD
+}
+
+// Creates a cipher with a key derived from the secret. Changing the secret makes
+// stored tokens unreadable so users have to log in again to store new ones.
+func NewTokenCipher(secret string) (*TokenCipher, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("flagops sso tokens"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenCipher{aead: aead}, nil
+}
+
+func (tc *TokenCipher) Encrypt(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, tc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := tc.aead.Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypts a stored token. Tokens stored before encryption are returned as is.
+func (tc *TokenCipher) Decrypt(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedTokenPrefix)
+	if !ok {
+		return stored, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < tc.aead.NonceSize() {
+		return "", ErrInvalidEncryptedToken
+	}
+
+	nonce, ciphertext := sealed[:tc.aead.NonceSize()], sealed[tc.aead.NonceSize():]
+	token, err := tc.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrInvalidEncryptedToken
+	}
+
+	return string(token), nil
+}
+
+// Stores the tokens of the identity provider used to look up the groups of the user on token refresh
+func (ud *UserDataService) SetSSOTokens(user *db.User, accessToken string, refreshToken string) error {
+	encryptedAccess, err := ud.SSOTokenCipher.Encrypt(accessToken)
+	if err != nil {
+		return err
+	}
+
+	encryptedRefresh, err := ud.SSOTokenCipher.Encrypt(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	user.SSOAccessToken = encryptedAccess
+	user.SSORefreshToken = encryptedRefresh
+
+	return ud.DBClient.Model(user).Select("SSOAccessToken", "SSORefreshToken").Updates(&db.User{
+		SSOAccessToken:  encryptedAccess,
+		SSORefreshToken: encryptedRefresh,
+	}).Error
+}
+
+// Returns the decrypted access and refresh tokens of the identity provider stored for the user
+func (ud *UserDataService) SSOTokens(user *db.User) (string, string, error) {
+	accessToken, err := ud.SSOTokenCipher.Decrypt(user.SSOAccessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := ud.SSOTokenCipher.Decrypt(user.SSORefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// Encrypts the tokens of users stored before tokens were encrypted
+func (ud *UserDataService) EncryptStoredSSOTokens() error {
+	users := []db.User{}
+	err := ud.DBClient.
+		Where("(sso_access_token <> '' AND sso_access_token NOT LIKE ?) OR (sso_refresh_token <> '' AND sso_refresh_token NOT LIKE ?)", encryptedTokenPrefix+"%", encryptedTokenPrefix+"%").
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		accessToken, refreshToken, err := ud.SSOTokens(&user)
+		if err != nil {
+			return err
+		}
+
+		err = ud.SetSSOTokens(&user, accessToken, refreshToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package user_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCipher(t *testing.T) {
+	tc, err := user.NewTokenCipher("flagops-salt")
+	require.NoError(t, err)
+
+	encrypted, err := tc.Encrypt("gho_token")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "gho_token")
+
+	decrypted, err := tc.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "gho_token", decrypted)
+
+	// Missing tokens stay empty and tokens stored before encryption are still readable
+	empty, err := tc.Encrypt("")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	legacy, err := tc.Decrypt("gho_legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "gho_legacy", legacy)
+
+	// Tokens can only be read with the secret they were encrypted with
+	other, err := user.NewTokenCipher("other-secret")
+	require.NoError(t, err)
+	_, err = other.Decrypt(encrypted)
+	assert.ErrorIs(t, err, user.ErrInvalidEncryptedToken)
+
+	_, err = tc.Decrypt(strings.TrimSuffix(encrypted, encrypted[len(encrypted)-4:]))
+	assert.ErrorIs(t, err, user.ErrInvalidEncryptedToken)
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Prefix of every api token to make them easy to recognize in configs and secret scanners
+const apiTokenPrefix = "fos_"
+
+var (
+	ErrInvalidAPIToken    = errors.New("invalid api token")
+	ErrExpiredAPIToken    = errors.New("api token has expired")
+	ErrUnknownPermissions = errors.New("unknown permissions")
+)
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return apiTokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// Creates a new api token for the service account, creating the account if it
+// does not exist yet. The plain text token is only ever returned from this call.
+func (ud *UserDataService) CreateAPIToken(serviceAccount string, name string, permissionIDs []string, expiresAt *time.Time) (*db.APIToken, string, error) {
+	// Repeating a permission grants nothing extra
+	permissionIDs = slices.Compact(slices.Sorted(slices.Values(permissionIDs)))
+
+	permissions := []db.Permission{}
+	if len(permissionIDs) > 0 {
+		err := ud.DBClient.Where("id IN ?", permissionIDs).Find(&permissions).Error
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if len(permissions) != len(permissionIDs) {
+		return nil, "", ErrUnknownPermissions
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiToken := db.APIToken{
+		Name:        name,
+		TokenHash:   hashAPIToken(token),
+		Permissions: permissions,
+		ExpiresAt:   expiresAt,
+	}
+
+	err = ud.DBClient.Transaction(func(tx *gorm.DB) error {
+		account := db.ServiceAccount{}
+		err := tx.Where(db.ServiceAccount{Name: serviceAccount}).FirstOrCreate(&account).Error
+		if err != nil {
+			return err
+		}
+
+		apiToken.ServiceAccountID = account.ID
+		apiToken.ServiceAccount = account
+		return tx.Omit("ServiceAccount", "Permissions.*").Create(&apiToken).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &apiToken, token, nil
+}
+
+// TODO Add pagination
+func (ud *UserDataService) GetAPITokens() ([]db.APIToken, error) {
+	tokens := []db.APIToken{}
+
+	err := ud.DBClient.Preload(clause.Associations).Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revokes the api token so it can no longer be used to authenticate
+func (ud *UserDataService) RevokeAPIToken(id uint) error {
+	return ud.DBClient.Transaction(func(tx *gorm.DB) error {
+		apiToken := db.APIToken{}
+		err := tx.First(&apiToken, id).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&apiToken).Association("Permissions").Clear()
+		if err != nil {
+			return err
+		}
+
+		// Hard deleted so the token hash does not linger behind its unique index
+		return tx.Unscoped().Delete(&apiToken).Error
+	})
+}
+
+// Looks up the api token and records that it has been used
+func (ud *UserDataService) ValidateAPIToken(token string) (*db.APIToken, error) {
+	apiToken := db.APIToken{}
+	err := ud.DBClient.Preload(clause.Associations).Where("token_hash = ?", hashAPIToken(token)).First(&apiToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(now) {
+		return nil, ErrExpiredAPIToken
+	}
+
+	err = ud.DBClient.Model(&apiToken).UpdateColumn("last_used_at", now).Error
+	if err != nil {
+		return nil, fmt.Errorf("could not record api token usage: %w", err)
+	}
+
+	return &apiToken, nil
+}
@@ -9,6 +9,9 @@ import (
 // Handles managing user data including permissions and authentication source
 type UserDataService struct {
 	DBClient *gorm.DB
+
+	// Encrypts the tokens of identity providers stored for users
+	SSOTokenCipher *TokenCipher
 }
 
 // TODO Add pagination
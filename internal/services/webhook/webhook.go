@@ -0,0 +1,223 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"gorm.io/gorm"
+)
+
+const (
+	EventFactSet        = "fact.set"
+	EventFactDelete     = "fact.delete"
+	EventSecretSet      = "secret.set"
+	EventSecretDelete   = "secret.delete"
+	EventIdentityDelete = "identity.delete"
+)
+
+var Events = []string{EventFactSet, EventFactDelete, EventSecretSet, EventSecretDelete, EventIdentityDelete}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+var (
+	_ facts.FactObserver     = &WebhookService{}
+	_ secrets.SecretObserver = &WebhookService{}
+)
+
+// Manages webhook subscriptions and queues deliveries for data changes
+type WebhookService struct {
+	DBClient   *gorm.DB
+	HTTPClient *http.Client
+}
+
+// The body sent to webhooks. Secret values are never sent.
+type Payload struct {
+	Event    string      `json:"event"`
+	Identity string      `json:"identity"`
+	Key      string      `json:"key,omitempty"`
+	Env      string      `json:"environment,omitempty"`
+	Value    *facts.Fact `json:"value,omitempty"`
+	Actor    string      `json:"actor,omitempty"`
+	Time     time.Time   `json:"time"`
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Creates a webhook generating a signing secret if none is given
+func (ws *WebhookService) CreateWebhook(webhook db.Webhook) (*db.Webhook, error) {
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https url", ErrInvalidWebhook)
+	}
+
+	for _, event := range webhook.Events {
+		if !slices.Contains(Events, event) {
+			return nil, fmt.Errorf("%w: unknown event %s", ErrInvalidWebhook, event)
+		}
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = generateSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	webhook.Enabled = true
+	err = ws.DBClient.Create(&webhook).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// TODO Add pagination
+func (ws *WebhookService) GetWebhooks() ([]db.Webhook, error) {
+	webhooks := []db.Webhook{}
+
+	err := ws.DBClient.Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Deletes the webhook along with its queued deliveries
+func (ws *WebhookService) DeleteWebhook(id uint) error {
+	return ws.DBClient.Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(&db.Webhook{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("webhook_id = ?", id).Delete(&db.WebhookDelivery{}).Error
+	})
+}
+
+// Returns the most recent deliveries of the webhook newest first
+func (ws *WebhookService) GetDeliveries(webhookID uint, limit int) ([]db.WebhookDelivery, error) {
+	err := ws.DBClient.First(&db.Webhook{}, webhookID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []db.WebhookDelivery{}
+	err = ws.DBClient.
+		Where("webhook_id = ?", webhookID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func matchesWebhook(webhook db.Webhook, payload Payload) bool {
+	if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, payload.Event) {
+		return false
+	}
+
+	return strings.HasPrefix(payload.Identity, webhook.IdentityPrefix)
+}
+
+// Queues a delivery of the payload for every enabled webhook subscribed to it
+func (ws *WebhookService) Enqueue(ctx context.Context, payload Payload) error {
+	webhooks := []db.Webhook{}
+	err := ws.DBClient.WithContext(ctx).Where("enabled = ?", true).Find(&webhooks).Error
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	deliveries := []db.WebhookDelivery{}
+	for _, webhook := range webhooks {
+		if !matchesWebhook(webhook, payload) {
+			continue
+		}
+
+		deliveries = append(deliveries, db.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         payload.Event,
+			Payload:       string(body),
+			Status:        DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return ws.DBClient.WithContext(ctx).Create(&deliveries).Error
+}
+
+// ObserveFactChange implements facts.FactObserver.
+func (ws *WebhookService) ObserveFactChange(ctx context.Context, change facts.FactChange) error {
+	event := EventFactSet
+	if change.Operation == facts.ChangeOperationDelete {
+		event = EventFactDelete
+	}
+
+	return ws.Enqueue(ctx, Payload{
+		Event:    event,
+		Identity: change.Identity,
+		Key:      change.Key,
+		Env:      change.Environment,
+		Value:    change.NewValue,
+		Actor:    change.Actor,
+		Time:     change.Time,
+	})
+}
+
+// ObserveSecretChange implements secrets.SecretObserver.
+func (ws *WebhookService) ObserveSecretChange(ctx context.Context, change secrets.SecretChange) error {
+	event := EventSecretSet
+	if change.Operation != secrets.ChangeOperationSet {
+		event = EventSecretDelete
+	}
+
+	return ws.Enqueue(ctx, Payload{
+		Event:    event,
+		Identity: change.Identity,
+		Key:      change.Key,
+		Actor:    change.Actor,
+		Time:     change.Time,
+	})
+}
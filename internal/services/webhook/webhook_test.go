@@ -0,0 +1,23 @@
+package webhook_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/graytonio/flagops-data-store/internal/services/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"fact.set","identity":"customer0"}`)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, expected, webhook.Sign("secret", "1700000000", body))
+	assert.NotEqual(t, expected, webhook.Sign("other-secret", "1700000000", body))
+	assert.NotEqual(t, expected, webhook.Sign("secret", "1700000001", body))
+}
@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Number of attempts before a delivery is marked as failed
+	maxDeliveryAttempts = 8
+
+	// Number of deliveries claimed by a worker at once
+	deliveryBatchSize = 10
+
+	// How long a claimed delivery is hidden from other workers while it is being sent
+	deliveryLease = time.Minute
+
+	pollInterval = time.Second
+)
+
+// Returns how long to wait before retrying a delivery that failed the given number of times
+func retryBackoff(attempts int) time.Duration {
+	backoff := 10 * time.Second << (attempts - 1)
+	return min(backoff, time.Hour)
+}
+
+// Signs the timestamp and body so receivers can verify the request and reject replays
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delivers queued events until ctx is done. Safe to run on every replica at once.
+func (ws *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ws.deliverPending(ctx); err != nil {
+				logrus.WithError(err).Error("could not deliver webhooks")
+			}
+		}
+	}
+}
+
+// Claims due deliveries by pushing back their next attempt so the lock is not held while sending
+func (ws *WebhookService) claimDeliveries(ctx context.Context) ([]db.WebhookDelivery, error) {
+	deliveries := []db.WebhookDelivery{}
+
+	err := ws.DBClient.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(deliveryBatchSize).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		ids := []uint{}
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+
+		return tx.Model(&db.WebhookDelivery{}).Where("id IN ?", ids).UpdateColumn("next_attempt_at", time.Now().Add(deliveryLease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (ws *WebhookService) deliverPending(ctx context.Context) error {
+	deliveries, err := ws.claimDeliveries(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		webhook := db.Webhook{}
+		err := ws.DBClient.WithContext(ctx).First(&webhook, delivery.WebhookID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The webhook was deleted after the delivery was queued
+			if err := ws.DBClient.WithContext(ctx).Delete(&delivery).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		statusCode, sendErr := ws.send(ctx, webhook, delivery)
+		if err := ws.recordAttempt(ctx, delivery, statusCode, sendErr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ws *WebhookService) send(ctx context.Context, webhook db.Webhook, delivery db.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "flagops-data-store")
+	req.Header.Set("X-Flagops-Event", delivery.Event)
+	req.Header.Set("X-Flagops-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Flagops-Timestamp", timestamp)
+	req.Header.Set("X-Flagops-Signature", Sign(webhook.Secret, timestamp, body))
+
+	resp, err := ws.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (ws *WebhookService) recordAttempt(ctx context.Context, delivery db.WebhookDelivery, statusCode int, sendErr error) error {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = DeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= maxDeliveryAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.LastError = sendErr.Error()
+	default:
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = now.Add(retryBackoff(delivery.Attempts))
+	}
+
+	if sendErr != nil {
+		logrus.WithFields(logrus.Fields{
+			"webhook_id":  delivery.WebhookID,
+			"delivery_id": delivery.ID,
+			"attempts":    delivery.Attempts,
+		}).WithError(sendErr).Warn("webhook delivery failed")
+	}
+
+	return ws.DBClient.WithContext(ctx).Save(&delivery).Error
+}
@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/chenjiandongx/ginprom"
 	"github.com/gin-gonic/gin"
 	"github.com/graytonio/flagops-data-store/internal/config"
 	"github.com/graytonio/flagops-data-store/internal/db"
+	"github.com/graytonio/flagops-data-store/internal/events"
 	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/flags"
 	"github.com/graytonio/flagops-data-store/internal/renderer"
 	"github.com/graytonio/flagops-data-store/internal/routes"
 	"github.com/graytonio/flagops-data-store/internal/routes/api"
 	"github.com/graytonio/flagops-data-store/internal/routes/ui"
 	"github.com/graytonio/flagops-data-store/internal/secrets"
+	"github.com/graytonio/flagops-data-store/internal/services/audit"
+	"github.com/graytonio/flagops-data-store/internal/services/groups"
+	"github.com/graytonio/flagops-data-store/internal/services/history"
 	"github.com/graytonio/flagops-data-store/internal/services/jwt"
+	"github.com/graytonio/flagops-data-store/internal/services/registry"
+	"github.com/graytonio/flagops-data-store/internal/services/schedule"
+	"github.com/graytonio/flagops-data-store/internal/services/schema"
+	"github.com/graytonio/flagops-data-store/internal/services/transfer"
 	"github.com/graytonio/flagops-data-store/internal/services/user"
+	"github.com/graytonio/flagops-data-store/internal/services/webhook"
 	"github.com/graytonio/flagops-data-store/templates/pages"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
@@ -27,25 +39,105 @@ func main() {
 		logrus.WithError(err).Fatal("cannot parse config")
 	}
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate-redis-layout":
+			if err := runRedisLayoutMigration(conf, os.Args[2:]); err != nil {
+				logrus.WithError(err).Fatal("could not migrate redis layout")
+			}
+		case "migrate":
+			if err := runProviderMigration(conf, os.Args[2:]); err != nil {
+				logrus.WithError(err).Fatal("could not migrate providers")
+			}
+		default:
+			logrus.WithField("command", os.Args[1]).Fatal("unknown command")
+		}
+		return
+	}
+
 	dbClient, err := db.GetDBClient(conf.UserDatabaseOptions.PostgresDSN)
 	if err != nil {
 		logrus.WithError(err).Fatal("could not connect to db deployment")
 	}
 
-	factProvider, err := facts.GetFactProvider(conf.FactsProviderOptions)
+	auditService := &audit.AuditService{
+		DBClient: dbClient,
+	}
+
+	webhookService := &webhook.WebhookService{
+		DBClient:   dbClient,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	go webhookService.Run(context.Background())
+
+	historyService := &history.HistoryService{
+		DBClient: dbClient,
+	}
+
+	baseFactProvider, err := facts.GetFactProvider(conf.FactsProviderOptions)
 	if err != nil {
 		logrus.WithError(err).Fatal("cannot init fact provider")
 	}
 
-	secretProvider, err := secrets.GetSecretsProvider(conf.SecretsProviderOptions)
+	eventBus, err := events.GetBus(conf.FactsProviderOptions)
+	if err != nil {
+		logrus.WithError(err).Fatal("cannot init event bus")
+	}
+
+	factProvider := facts.NewObservedFactProvider(baseFactProvider, &events.FactPublisher{Bus: eventBus}, webhookService).WithRecorders(historyService)
+
+	flagSource, err := flags.GetFlagSource(conf.FlagsOptions)
+	if err != nil {
+		logrus.WithError(err).Fatal("cannot init flag source")
+	}
+
+	baseSecretProvider, err := secrets.GetSecretsProvider(conf.SecretsProviderOptions)
 	if err != nil {
 		logrus.WithError(err).Fatal("cannot init secrets provider")
 	}
+	secretProvider := secrets.NewObservedSecretProvider(baseSecretProvider, webhookService)
+
+	ssoTokenCipher, err := user.NewTokenCipher(conf.UserDatabaseOptions.JWTSecret)
+	if err != nil {
+		logrus.WithError(err).Fatal("cannot init sso token cipher")
+	}
 
 	userDataService := &user.UserDataService{
+		DBClient:       dbClient,
+		SSOTokenCipher: ssoTokenCipher,
+	}
+
+	err = userDataService.EncryptStoredSSOTokens()
+	if err != nil {
+		logrus.WithError(err).Fatal("cannot encrypt stored sso tokens")
+	}
+
+	schemaService := &schema.SchemaService{
+		DBClient: dbClient,
+	}
+
+	groupService := &groups.GroupService{
 		DBClient: dbClient,
 	}
 
+	registryService := &registry.RegistryService{
+		DBClient: dbClient,
+	}
+
+	scheduleService := &schedule.ScheduleService{
+		DBClient:      dbClient,
+		FactProvider:  factProvider,
+		SchemaService: schemaService,
+	}
+	factProvider.WithRecorders(scheduleService)
+	go scheduleService.Run(context.Background())
+
+	transferService := &transfer.TransferService{
+		FactProvider:   factProvider,
+		SecretProvider: secretProvider,
+		SchemaService:  schemaService,
+	}
+
 	jwtService := &jwt.JWTService{
 		AccessExpires:   time.Minute * time.Duration(conf.UserDatabaseOptions.AccessTokenExpirationMinutes),
 		RefreshExpires:  time.Minute * time.Duration(conf.UserDatabaseOptions.RefreshTokenExpirationMinutes),
@@ -61,8 +153,10 @@ func main() {
 
 		UserDataService: userDataService,
 		JWTService:      jwtService,
+		AuditService:    auditService,
 	}
 	routeHandlers.InitOauthProvider()
+	jwtService.GroupSource = routeHandlers
 
 	apiRoutesHandlers := &api.APIRoutes{
 		Config: *conf,
@@ -72,6 +166,17 @@ func main() {
 
 		UserDataService: userDataService,
 		JWTService:      jwtService,
+		SchemaService:   schemaService,
+		HistoryService:  historyService,
+		AuditService:    auditService,
+		WebhookService:  webhookService,
+		TransferService: transferService,
+		GroupService:    groupService,
+		ScheduleService: scheduleService,
+		RegistryService: registryService,
+
+		EventBus:   eventBus,
+		FlagSource: flagSource,
 	}
 
 	uiRoutesHandlers := &ui.UIRoutes{
@@ -82,6 +187,8 @@ func main() {
 
 		UserDataService: userDataService,
 		JWTService:      jwtService,
+		SchemaService:   schemaService,
+		AuditService:    auditService,
 	}
 
 	r := gin.Default()
@@ -90,6 +197,7 @@ func main() {
 	r.Static("/assets", "/assets")
 
 	r.Use(routes.ErrorLogger())
+	r.Use(routes.RequestMetadata())
 
 	ginPromOpts := ginprom.NewDefaultOpts()
 	ginPromOpts.EndpointLabelMappingFn = func(c *gin.Context) string {
@@ -104,19 +212,47 @@ func main() {
 	})
 
 	apiRoutes := r.Group("/api")
+	apiRoutes.Use(routeHandlers.AuditLog())
 	{
 		// Managing identities
-		apiRoutes.GET("/identity", routeHandlers.RequiresAuth(db.FactsRead, db.SecretsRead), apiRoutesHandlers.GetAllIdentities)      // Get all identities
-		apiRoutes.DELETE("/identity/:id", routeHandlers.RequiresAuth(db.FactsRead, db.SecretsRead), apiRoutesHandlers.DeleteIdentity) // Delete an identity
+		apiRoutes.GET("/identity", routeHandlers.RequiresAuth(db.FactsRead, db.SecretsRead), apiRoutesHandlers.GetAllIdentities)         // Get all identities
+		apiRoutes.POST("/identity", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.CreateIdentity)                         // Register an identity
+		apiRoutes.GET("/identity/:id", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentity)                          // Get identity metadata
+		apiRoutes.PATCH("/identity/:id", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.UpdateIdentity)                    // Update identity metadata
+		apiRoutes.DELETE("/identity/:id", routeHandlers.RequiresAuth(db.FactsRead, db.SecretsRead), apiRoutesHandlers.DeleteIdentity)    // Delete an identity
+		apiRoutes.GET("/identity/:id/groups", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityGroups)             // Get ordered groups of identity
+		apiRoutes.PUT("/identity/:id/groups", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.SetIdentityGroups)            // Replace ordered groups of identity
+		apiRoutes.GET("/identity/:id/environments", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityEnvironments) // Get environments identity has overrides in
+
+		// Managing identity groups
+		apiRoutes.GET("/group", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetGroups)             // Fetch list of identity groups
+		apiRoutes.GET("/group/:name", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetGroup)        // Get identity group
+		apiRoutes.PUT("/group/:name", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.UpsertGroup)    // Create or replace identity group
+		apiRoutes.DELETE("/group/:name", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.DeleteGroup) // Delete identity group
 
 		// Managing facts
-		apiRoutes.GET("/fact/:id", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityFacts)         // Get all indentity facts
-		apiRoutes.GET("/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityFact)    // Get specific fact of identity
-		apiRoutes.PUT("/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.SetIdentityFact)   // Set fact for identity
-		apiRoutes.DELETE("/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.DeleteIdentity) // Delete single fact for identity
+		apiRoutes.GET("/fact/:id", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityFacts)                           // Get all indentity facts
+		apiRoutes.PUT("/fact/:id", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.ReplaceIdentityFacts)                      // Replace all identity facts
+		apiRoutes.PATCH("/fact/:id", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.PatchIdentityFacts)                      // Merge patch identity facts
+		apiRoutes.POST("/fact/_batch", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.BatchGetIdentityFacts)                  // Get facts of many identities
+		apiRoutes.GET("/watch", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.WatchFacts)                                    // Stream fact changes as server sent events
+		apiRoutes.GET("/fact/:id/_history", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityFactHistory)            // Get change history of identity facts
+		apiRoutes.GET("/fact/:id/_schedule", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetFactSchedules)                 // Get pending scheduled changes of identity facts
+		apiRoutes.POST("/fact/:id/_schedule", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.CreateFactSchedule)             // Schedule a change to an identity fact
+		apiRoutes.DELETE("/fact/:id/_schedule/:schedule", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.DeleteFactSchedule) // Cancel a scheduled change
+		apiRoutes.GET("/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetIdentityFact)                      // Get specific fact of identity
+		apiRoutes.PUT("/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.SetIdentityFact)                     // Set fact for identity
+		apiRoutes.DELETE("/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.DeleteIdentityFact)               // Delete single fact for identity
+
+		// Managing fact schemas
+		apiRoutes.GET("/schema", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetSchemas)                // Fetch list of fact schemas
+		apiRoutes.GET("/schema/report", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.GetSchemaReport)    // Validate all identities against the schemas
+		apiRoutes.POST("/schema", routeHandlers.RequiresAuth(db.AdminPermission), apiRoutesHandlers.UpsertSchema)       // Create or replace fact schema
+		apiRoutes.DELETE("/schema/:id", routeHandlers.RequiresAuth(db.AdminPermission), apiRoutesHandlers.DeleteSchema) // Delete fact schema
 
 		// Managing secrets
 		apiRoutes.GET("/secret/:id", routeHandlers.RequiresAuth(db.SecretsRead), apiRoutesHandlers.GetIdentitySecrets)               // Get all identity secrets
+		apiRoutes.POST("/secret/_batch", routeHandlers.RequiresAuth(db.SecretsRead), apiRoutesHandlers.BatchGetIdentitySecrets)      // Get secrets of many identities
 		apiRoutes.GET("/secret/:id/:secret", routeHandlers.RequiresAuth(db.SecretsRead), apiRoutesHandlers.GetIdentitySecret)        // Get specific secret of identity
 		apiRoutes.PUT("/secret/:id/:secret", routeHandlers.RequiresAuth(db.SecretsWrite), apiRoutesHandlers.SetIdentitySecret)       // Set secret for identity
 		apiRoutes.DELETE("/secret/:id/:secret", routeHandlers.RequiresAuth(db.SecretsWrite), apiRoutesHandlers.DeleteIdentitySecret) // Delete secret for identity
@@ -127,6 +263,42 @@ func main() {
 		apiRoutes.GET("/permission", routeHandlers.RequiresAuth(db.ReadUsers), apiRoutesHandlers.GetPermisssions)                    // Fetch list of available permissions
 		apiRoutes.PUT("/user/:id/permission", routeHandlers.RequiresAuth(db.WriteUsers), apiRoutesHandlers.AddUserPermissions)       // Assign permission to user
 		apiRoutes.DELETE("/user/:id/permission", routeHandlers.RequiresAuth(db.WriteUsers), apiRoutesHandlers.RemoveUserPermissions) // Remove permission from user
+		apiRoutes.GET("/group-mapping", routeHandlers.RequiresAuth(db.ReadUsers), apiRoutesHandlers.GetGroupMappings)                // Fetch list of identity provider group to permission mappings
+		apiRoutes.POST("/group-mapping", routeHandlers.RequiresAuth(db.WriteUsers), apiRoutesHandlers.CreateGroupMapping)            // Grant permission to members of identity provider group
+		apiRoutes.DELETE("/group-mapping/:id", routeHandlers.RequiresAuth(db.WriteUsers), apiRoutesHandlers.DeleteGroupMapping)      // Delete group mapping
+
+		// Managing service account api tokens
+		apiRoutes.GET("/token", routeHandlers.RequiresAuth(db.ReadUsers), apiRoutesHandlers.GetAPITokens)           // Fetch list of api tokens
+		apiRoutes.POST("/token", routeHandlers.RequiresAuth(db.WriteUsers), apiRoutesHandlers.CreateAPIToken)       // Create api token for service account
+		apiRoutes.DELETE("/token/:id", routeHandlers.RequiresAuth(db.WriteUsers), apiRoutesHandlers.RevokeAPIToken) // Revoke api token
+
+		// Managing webhooks
+		apiRoutes.GET("/webhook", routeHandlers.RequiresAuth(db.AdminPermission), apiRoutesHandlers.GetWebhooks)                         // Fetch list of webhooks
+		apiRoutes.POST("/webhook", routeHandlers.RequiresAuth(db.AdminPermission), apiRoutesHandlers.CreateWebhook)                      // Create webhook
+		apiRoutes.DELETE("/webhook/:id", routeHandlers.RequiresAuth(db.AdminPermission), apiRoutesHandlers.DeleteWebhook)                // Delete webhook
+		apiRoutes.GET("/webhook/:id/deliveries", routeHandlers.RequiresAuth(db.AdminPermission), apiRoutesHandlers.GetWebhookDeliveries) // Fetch recent deliveries of webhook
+
+		// Export and import
+		apiRoutes.GET("/export", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.ExportIdentities)   // Export all identities
+		apiRoutes.POST("/import", routeHandlers.RequiresAuth(db.FactsWrite), apiRoutesHandlers.ImportIdentities) // Import identities
+
+		// Audit log
+		apiRoutes.GET("/audit", routeHandlers.RequiresAuth(db.AuditRead), apiRoutesHandlers.GetAuditEvents) // Fetch audit events
+	}
+
+	// ArgoCD ApplicationSet plugin generator. Polled frequently so it is not audited.
+	argoRoutes := r.Group("/api/v1")
+	{
+		argoRoutes.POST("/getparams.execute", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.ArgoCDGetParams) // Fetch parameter set per identity
+	}
+
+	// OpenFeature remote evaluation protocol
+	if flagSource != nil {
+		ofrepRoutes := r.Group("/ofrep/v1")
+		{
+			ofrepRoutes.POST("/evaluate/flags", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.OFREPEvaluateFlags)     // Evaluate all flags
+			ofrepRoutes.POST("/evaluate/flags/:key", routeHandlers.RequiresAuth(db.FactsRead), apiRoutesHandlers.OFREPEvaluateFlag) // Evaluate single flag
+		}
 	}
 
 	uiRoutes := r.Group("/ui")
@@ -136,16 +308,21 @@ func main() {
 
 		uiRoutes.GET("/identity/:id", uiRoutesHandlers.IdentityFactsDashboard)
 		uiRoutes.GET("/htmx/fact/:id", uiRoutesHandlers.IdentityFactsTable)
-		uiRoutes.GET("/htmx/fact/:id/:fact/edit", uiRoutesHandlers.EditIdentityFactRowForm)
-		uiRoutes.PUT("/htmx/fact/:id/:fact", uiRoutesHandlers.EditIdentityFactRow)
+		uiRoutes.GET("/htmx/fact/:id/:fact/edit", routeHandlers.RequiresAuth(db.FactsWrite), uiRoutesHandlers.EditIdentityFactRowForm)
+		uiRoutes.PUT("/htmx/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsWrite), uiRoutesHandlers.EditIdentityFactRow)
+		uiRoutes.DELETE("/htmx/fact/:id/:fact", routeHandlers.RequiresAuth(db.FactsWrite), uiRoutesHandlers.ResetIdentityFactRow)
+
+		uiRoutes.GET("/audit", routeHandlers.RequiresAuth(db.AuditRead), uiRoutesHandlers.AuditDashboard)
+		uiRoutes.GET("/htmx/audit", routeHandlers.RequiresAuth(db.AuditRead), uiRoutesHandlers.AuditEventsTable)
 	}
 
 	// Authentication
-	r.GET("/auth/login", routeHandlers.OauthLogin)
-	r.GET("/auth/github/callback", routeHandlers.OauthCallback)
+	r.GET("/auth/login", routeHandlers.OauthLogin)                 // Login with the first enabled provider
+	r.GET("/auth/:provider/login", routeHandlers.OauthLogin)       // Login with a specific provider
+	r.GET("/auth/:provider/callback", routeHandlers.OauthCallback) // Oauth2 callback of each provider
 
 	r.GET("/login", func(ctx *gin.Context) {
-		ctx.HTML(http.StatusOK, "", pages.LoginPage(conf.OAuthOptions.Provider))
+		ctx.HTML(http.StatusOK, "", pages.LoginPage(routeHandlers.LoginProviders()))
 	})
 
 	if err := r.Run(":8080"); err != nil {
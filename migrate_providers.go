@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/graytonio/flagops-data-store/internal/migrate"
+	"github.com/graytonio/flagops-data-store/internal/secrets"
+)
+
+// Copies every identity from the configured providers, or the providers in the
+// -from config file, to the providers in the -to config file
+func runProviderMigration(conf *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "config file of the source providers, defaults to the environment")
+	to := flags.String("to", "", "config file of the destination providers")
+	concurrency := flags.Int("concurrency", 8, "number of identities copied at once")
+	checkpoint := flags.String("checkpoint", "", "file recording migrated identities so an interrupted migration can be resumed")
+	dryRun := flags.Bool("dry-run", false, "report what would be copied without writing anything")
+	migrateFacts := flags.Bool("facts", true, "migrate facts")
+	migrateSecrets := flags.Bool("secrets", true, "migrate secrets")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *to == "" {
+		return errors.New("-to is required")
+	}
+
+	source := conf
+	if *from != "" {
+		var err error
+		source, err = config.ParseConfigFile(*from)
+		if err != nil {
+			return fmt.Errorf("could not read source config: %w", err)
+		}
+	}
+
+	destination, err := config.ParseConfigFile(*to)
+	if err != nil {
+		return fmt.Errorf("could not read destination config: %w", err)
+	}
+
+	migrator := &migrate.Migrator{
+		Options: migrate.Options{
+			Concurrency:    *concurrency,
+			CheckpointPath: *checkpoint,
+			DryRun:         *dryRun,
+			Facts:          *migrateFacts,
+			Secrets:        *migrateSecrets,
+		},
+	}
+
+	if *migrateFacts {
+		if migrator.SourceFacts, err = facts.GetFactProvider(source.FactsProviderOptions); err != nil {
+			return fmt.Errorf("could not init source fact provider: %w", err)
+		}
+
+		if migrator.DestinationFacts, err = facts.GetFactProvider(destination.FactsProviderOptions); err != nil {
+			return fmt.Errorf("could not init destination fact provider: %w", err)
+		}
+	}
+
+	if *migrateSecrets {
+		if migrator.SourceSecrets, err = secrets.GetSecretsProvider(source.SecretsProviderOptions); err != nil {
+			return fmt.Errorf("could not init source secrets provider: %w", err)
+		}
+
+		if migrator.DestinationSecrets, err = secrets.GetSecretsProvider(destination.SecretsProviderOptions); err != nil {
+			return fmt.Errorf("could not init destination secrets provider: %w", err)
+		}
+	}
+
+	// Stop handing out identities on interrupt so the checkpoint stays consistent
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := migrator.Run(ctx)
+	if report != nil {
+		printMigrationReport(report, *dryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("%d identities could not be migrated", len(report.Failures))
+	}
+
+	if !*dryRun && report.DestinationIdentities != report.Identities {
+		return fmt.Errorf("found %d of %d identities in the destination", report.DestinationIdentities, report.Identities)
+	}
+
+	return nil
+}
+
+func printMigrationReport(report *migrate.Report, dryRun bool) {
+	copied := "copied"
+	if dryRun {
+		copied = "to copy"
+	}
+
+	fmt.Printf("identities: %d\n", report.Identities)
+	fmt.Printf("  %s: %d\n", copied, report.Copied)
+	fmt.Printf("  unchanged: %d\n", report.Unchanged)
+	fmt.Printf("  skipped by checkpoint: %d\n", report.Skipped)
+	fmt.Printf("  failed: %d\n", len(report.Failures))
+	fmt.Printf("facts written: %d removed: %d\n", report.FactsWritten, report.FactsRemoved)
+	fmt.Printf("overrides written: %d removed: %d\n", report.OverridesWritten, report.OverridesRemoved)
+	fmt.Printf("secrets written: %d removed: %d\n", report.SecretsWritten, report.SecretsRemoved)
+	if !dryRun {
+		fmt.Printf("identities in destination: %d\n", report.DestinationIdentities)
+	}
+
+	for _, failure := range report.Failures {
+		fmt.Printf("failed %s: %s\n", failure.Identity, failure.Error)
+	}
+}
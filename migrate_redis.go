@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/graytonio/flagops-data-store/internal/config"
+	"github.com/graytonio/flagops-data-store/internal/facts"
+	"github.com/redis/go-redis/v9"
+)
+
+// Copies facts from the redis string layout into the hash layout
+func runRedisLayoutMigration(conf *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate-redis-layout", flag.ExitOnError)
+	removeOld := flags.Bool("remove-old", false, "delete the string layout keys once they have been copied")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if conf.FactsProviderOptions.Provider != "redis" {
+		return fmt.Errorf("fact provider %s is not redis", conf.FactsProviderOptions.Provider)
+	}
+
+	opts, err := redis.ParseURL(conf.FactsProviderOptions.RedisURI)
+	if err != nil {
+		return err
+	}
+
+	migrated, err := facts.MigrateRedisStringLayoutToHash(context.Background(), redis.NewClient(opts), *removeOld)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("migrated %d facts to the hash layout\n", migrated)
+	return nil
+}
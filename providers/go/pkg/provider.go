@@ -7,12 +7,16 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/open-feature/go-sdk/openfeature"
 )
 
 type ProviderOption func(*Provider)
 
+// Returned by the IfMatch methods when the identity changed since its version was read
+var ErrVersionMismatch = errors.New("version does not match")
+
 var _ openfeature.FeatureProvider = &Provider{}
 
 type Provider struct {
@@ -48,10 +52,12 @@ func (p *Provider) Hooks() []openfeature.Hook {
 	return []openfeature.Hook{}
 }
 
-func (p *Provider) getIdentityContext(id string) (map[string]string, error) {
+// Fetches the facts of the identity including the facts inherited from its groups
+func (p *Provider) getIdentityContext(ctx context.Context, id string) (map[string]interface{}, error) {
 	reqURL := p.baseURL.JoinPath("/fact", id)
+	reqURL.RawQuery = url.Values{"resolved": {"true"}}.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 	  return nil, err
 	}
@@ -62,16 +68,22 @@ func (p *Provider) getIdentityContext(id string) (map[string]string, error) {
 	}
 	defer resp.Body.Close()
 
-	facts := map[string]string{}
-	err = json.NewDecoder(resp.Body).Decode(&facts)
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	resolved := struct {
+		Facts map[string]interface{} `json:"facts"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&resolved)
 	if err != nil {
 	  return nil, err
 	}
 
-	return facts, nil
+	return resolved.Facts, nil
 }
 
-func injectIdentityContext(identityCtx map[string]string, evalCtx openfeature.FlattenedContext) openfeature.FlattenedContext {
+func injectIdentityContext(identityCtx map[string]interface{}, evalCtx openfeature.FlattenedContext) openfeature.FlattenedContext {
 	for k, v := range identityCtx {
 		evalCtx[k] = v
 	}
@@ -86,7 +98,7 @@ func (p *Provider) BooleanEvaluation(ctx context.Context, flag string, defaultVa
 		return p.featureProvider.BooleanEvaluation(ctx, flag, defaultValue, evalCtx)
 	}
 
-	context, err := p.getIdentityContext(targetKey.(string))
+	context, err := p.getIdentityContext(ctx, targetKey.(string))
 	if err != nil {
 	  return openfeature.BoolResolutionDetail{
 		Value: defaultValue,
@@ -107,7 +119,7 @@ func (p *Provider) FloatEvaluation(ctx context.Context, flag string, defaultValu
 		return p.featureProvider.FloatEvaluation(ctx, flag, defaultValue, evalCtx)
 	}
 
-	context, err := p.getIdentityContext(targetKey.(string))
+	context, err := p.getIdentityContext(ctx, targetKey.(string))
 	if err != nil {
 	  return openfeature.FloatResolutionDetail{
 		Value: defaultValue,
@@ -128,7 +140,7 @@ func (p *Provider) IntEvaluation(ctx context.Context, flag string, defaultValue
 		return p.featureProvider.IntEvaluation(ctx, flag, defaultValue, evalCtx)
 	}
 
-	context, err := p.getIdentityContext(targetKey.(string))
+	context, err := p.getIdentityContext(ctx, targetKey.(string))
 	if err != nil {
 	  return openfeature.IntResolutionDetail{
 		Value: defaultValue,
@@ -149,7 +161,7 @@ func (p *Provider) ObjectEvaluation(ctx context.Context, flag string, defaultVal
 		return p.featureProvider.ObjectEvaluation(ctx, flag, defaultValue, evalCtx)
 	}
 
-	context, err := p.getIdentityContext(targetKey.(string))
+	context, err := p.getIdentityContext(ctx, targetKey.(string))
 	if err != nil {
 	  return openfeature.InterfaceResolutionDetail{
 		Value: defaultValue,
@@ -170,7 +182,7 @@ func (p *Provider) StringEvaluation(ctx context.Context, flag string, defaultVal
 		return p.featureProvider.StringEvaluation(ctx, flag, defaultValue, evalCtx)
 	}
 
-	context, err := p.getIdentityContext(targetKey.(string))
+	context, err := p.getIdentityContext(ctx, targetKey.(string))
 	if err != nil {
 	  return openfeature.StringResolutionDetail{
 		Value: defaultValue,
@@ -184,86 +196,131 @@ func (p *Provider) StringEvaluation(ctx context.Context, flag string, defaultVal
 	return p.featureProvider.StringEvaluation(ctx, flag, defaultValue, evalCtx)
 }
 
-func (p *Provider) GetIdentityFacts(ctx context.Context, identity string) (map[string]string, error) {
-	reqURL := p.baseURL.JoinPath("/fact", identity)
-
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
-	if err != nil {
-	  return nil, err
-	}
-
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-	  return nil, err
-	}
-	defer resp.Body.Close()
+// Facts are returned as their native JSON types
+func (p *Provider) GetIdentityFacts(ctx context.Context, identity string) (map[string]interface{}, error) {
+	facts, _, err := p.GetIdentityFactsVersion(ctx, identity)
+	return facts, err
+}
 
-	facts := map[string]string{}
-	err = json.NewDecoder(resp.Body).Decode(&facts)
+// Same as GetIdentityFacts but also returns the version of the facts to pass to SetIdentityFactIfMatch
+func (p *Provider) GetIdentityFactsVersion(ctx context.Context, identity string) (map[string]interface{}, string, error) {
+	facts := map[string]interface{}{}
+	version, err := p.getVersioned(ctx, p.baseURL.JoinPath("/fact", identity), &facts)
 	if err != nil {
-	  return nil, err
+	  return nil, "", err
 	}
 
-	return facts, nil
+	return facts, version, nil
 }
 
-func (p *Provider) SetIdentityFact(ctx context.Context, identity string, key string, value string) error {
-	reqUrl := p.baseURL.JoinPath("/fact", identity, key)
+// Returns the facts of many identities in a single request. Identities that do not
+// exist are left out. When keys are given only those facts are returned.
+func (p *Provider) GetIdentitiesFacts(ctx context.Context, identities []string, keys ...string) (map[string]map[string]interface{}, error) {
+	reqUrl := p.baseURL.JoinPath("/fact", "_batch")
 
-	body := map[string]string{
-		"value": value,
+	body := map[string]interface{}{
+		"ids": identities,
+		"keys": keys,
 	}
 
 	bodyBytes := bytes.NewBuffer(nil)
 	err := json.NewEncoder(bodyBytes).Encode(body)
 	if err != nil {
-	  return err
+	  return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPut, reqUrl.String(), bodyBytes)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqUrl.String(), bodyBytes)
 	if err != nil {
-	  return err
+	  return nil, err
 	}
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-	  return err
+	  return nil, err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode == 200 {
-		return nil
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	result := struct {
+		Identities map[string]map[string]interface{} `json:"identities"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+	  return nil, err
 	}
 
-	return errors.New(resp.Status)
+	return result.Identities, nil
+}
+
+// Value can be any type that encodes to a non null JSON value
+func (p *Provider) SetIdentityFact(ctx context.Context, identity string, key string, value interface{}) error {
+	return p.putValue(ctx, p.baseURL.JoinPath("/fact", identity, key), value, "")
+}
+
+// Sets the fact only if the facts of the identity are still at the version returned by
+// GetIdentityFactsVersion. Returns ErrVersionMismatch if they changed in the meantime.
+func (p *Provider) SetIdentityFactIfMatch(ctx context.Context, identity string, key string, value interface{}, version string) error {
+	return p.putValue(ctx, p.baseURL.JoinPath("/fact", identity, key), value, version)
 }
 
 func (p *Provider) GetIdentitySecrets(ctx context.Context, identity string) (map[string]string, error) {
-	reqURL := p.baseURL.JoinPath("/secret", identity)
+	secrets, _, err := p.GetIdentitySecretsVersion(ctx, identity)
+	return secrets, err
+}
 
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+// Same as GetIdentitySecrets but also returns the version of the secrets to pass to SetIdentitySecretIfMatch
+func (p *Provider) GetIdentitySecretsVersion(ctx context.Context, identity string) (map[string]string, string, error) {
+	secrets := map[string]string{}
+	version, err := p.getVersioned(ctx, p.baseURL.JoinPath("/secret", identity), &secrets)
 	if err != nil {
-	  return nil, err
+	  return nil, "", err
+	}
+
+	return secrets, version, nil
+}
+
+func (p *Provider) SetIdentitySecret(ctx context.Context, identity string, key string, value string) error {
+	return p.putValue(ctx, p.baseURL.JoinPath("/secret", identity, key), value, "")
+}
+
+// Sets the secret only if the secrets of the identity are still at the version returned by
+// GetIdentitySecretsVersion. Returns ErrVersionMismatch if they changed in the meantime.
+func (p *Provider) SetIdentitySecretIfMatch(ctx context.Context, identity string, key string, value string, version string) error {
+	return p.putValue(ctx, p.baseURL.JoinPath("/secret", identity, key), value, version)
+}
+
+// Decodes the response into result and returns the version from its ETag
+func (p *Provider) getVersioned(ctx context.Context, reqURL *url.URL, result interface{}) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+	  return "", err
 	}
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-	  return nil, err
+	  return "", err
 	}
 	defer resp.Body.Close()
 
-	secrets := map[string]string{}
-	err = json.NewDecoder(resp.Body).Decode(&secrets)
+	if resp.StatusCode != 200 {
+		return "", errors.New(resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(result)
 	if err != nil {
-	  return nil, err
+	  return "", err
 	}
 
-	return secrets, nil
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
 }
 
-func (p *Provider) SetIdentitySecret(ctx context.Context, identity string, key string, value string) error {
-	reqUrl := p.baseURL.JoinPath("/secret", identity, key)
-
-	body := map[string]string{
+// Puts the value to the url. A non empty version is sent as If-Match so the write is
+// rejected when the identity changed since it was read.
+func (p *Provider) putValue(ctx context.Context, reqURL *url.URL, value interface{}, version string) error {
+	body := map[string]interface{}{
 		"value": value,
 	}
 
@@ -273,19 +330,27 @@ func (p *Provider) SetIdentitySecret(ctx context.Context, identity string, key s
 	  return err
 	}
 
-	req, err := http.NewRequest(http.MethodPut, reqUrl.String(), bodyBytes)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL.String(), bodyBytes)
 	if err != nil {
 	  return err
 	}
 
+	if version != "" {
+		req.Header.Set("If-Match", `"`+version+`"`)
+	}
+
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
 	  return err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode == 200 {
+	switch resp.StatusCode {
+	case 200:
 		return nil
+	case http.StatusPreconditionFailed:
+		return ErrVersionMismatch
+	default:
+		return errors.New(resp.Status)
 	}
-
-	return errors.New(resp.Status)
 }
\ No newline at end of file
@@ -76,7 +76,7 @@ func IdentitiesPage() templ.Component {
 			templ_7745c5c3_Var4 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<div class=\"mt-8 flow-root\"><div class=\"-mx-4 -my-2 overflow-x-auto sm:-mx-6 lg:-mx-8\"><div class=\"inline-block min-w-full py-2 align-middle sm:px-6 lg:px-8\"><div class=\"overflow-hidden shadow ring-1 ring-black ring-opacity-5 sm:rounded-lg\"><input class=\"form-control m-2 block rounded-md border-0 py-1.5 text-gray-900 shadow-sm ring-1 ring-inset ring-gray-300 placeholder:text-gray-400 focus:ring-2 focus:ring-inset focus:ring-indigo-600 sm:text-sm sm:leading-6\" type=\"search\" name=\"search\" placeholder=\"Filter...\" hx-post=\"/ui/htmx/searchIdentities\" hx-trigger=\"input changed delay:500ms, search\" hx-target=\"#search-results\"><table class=\"min-w-full divide-y divide-gray-300\"><thead class=\"bg-gray-50\"><tr><th scope=\"col\" class=\"py-3.5 pl-4 pr-3 text-left text-sm font-semibold text-gray-900 sm:pl-6\">Identities</th></tr></thead> <tbody id=\"search-results\" class=\"divide-y divide-gray-200 bg-white\"></tbody></table></div></div></div></div>")
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<div class=\"mt-8 flow-root\"><div class=\"-mx-4 -my-2 overflow-x-auto sm:-mx-6 lg:-mx-8\"><div class=\"inline-block min-w-full py-2 align-middle sm:px-6 lg:px-8\"><div class=\"overflow-hidden shadow ring-1 ring-black ring-opacity-5 sm:rounded-lg\"><form class=\"m-2 flex items-center gap-4\" hx-post=\"/ui/htmx/searchIdentities\" hx-trigger=\"submit, input changed delay:500ms from:input[name=&#39;search&#39;], search, change from:input[name=&#39;selector&#39;]\" hx-target=\"#search-results\"><input class=\"form-control block rounded-md border-0 py-1.5 text-gray-900 shadow-sm ring-1 ring-inset ring-gray-300 placeholder:text-gray-400 focus:ring-2 focus:ring-inset focus:ring-indigo-600 sm:text-sm sm:leading-6\" type=\"search\" name=\"search\" placeholder=\"Filter by name, or selector e.g. tier in (gold, platinum)\"> <label class=\"flex items-center gap-2 text-sm text-gray-900\"><input type=\"checkbox\" name=\"selector\" value=\"true\" class=\"rounded border-gray-300 text-indigo-600 focus:ring-indigo-600\"> Selector</label></form><table class=\"min-w-full divide-y divide-gray-300\"><thead class=\"bg-gray-50\"><tr><th scope=\"col\" class=\"py-3.5 pl-4 pr-3 text-left text-sm font-semibold text-gray-900 sm:pl-6\">Identities</th></tr></thead> <tbody id=\"search-results\" class=\"divide-y divide-gray-200 bg-white\"></tbody></table></div></div></div></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}